@@ -1,7 +1,6 @@
 package cache
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -13,62 +12,69 @@ import (
 
 // Entry represents a cached step result.
 type Entry struct {
-	StepID     string      `json:"step_id"`
-	CachedAt   time.Time   `json:"cached_at"`
-	ExpiresAt  *time.Time  `json:"expires_at,omitempty"`
-	ExitCode   int         `json:"exit_code"`
-	Output     string      `json:"output,omitempty"`
-	Sensitive  bool        `json:"sensitive"`
-	SubOutputs []SubEntry  `json:"sub_outputs,omitempty"`
-	RunType    string      `json:"run_type"` // single, strings, subruns
+	Pipeline   string            `json:"pipeline"`
+	StepID     string            `json:"step_id"`
+	Key        string            `json:"key"`
+	KeyParts   KeyParts          `json:"key_parts"`
+	CachedAt   time.Time         `json:"cached_at"`
+	ExpiresAt  *time.Time        `json:"expires_at,omitempty"`
+	ExitCode   int               `json:"exit_code"`
+	Output     string            `json:"output,omitempty"`
+	Outputs    map[string]string `json:"outputs,omitempty"`
+	Sensitive  bool              `json:"sensitive"`
+	SubOutputs []SubEntry        `json:"sub_outputs,omitempty"`
+	Artifacts  []string          `json:"artifacts,omitempty"` // paths kept with the entry, see Store.Artifacts
+	// ArtifactsSize is the size in bytes of the stored artifacts.
+	ArtifactsSize int64  `json:"artifacts_size,omitempty"`
+	RunType       string `json:"run_type"` // single, strings, subruns
+	Stats
 }
 
-// SubEntry stores per-sub-run cached output.
-type SubEntry struct {
-	ID        string `json:"id"`
-	Output    string `json:"output,omitempty"`
-	Sensitive bool   `json:"sensitive"`
-	ExitCode  int    `json:"exit_code"`
+// Size returns the bytes an entry keeps: its stored artifacts and the
+// stdout and named outputs of the step and its sub-runs.
+func (e *Entry) Size() int64 {
+	n := e.ArtifactsSize + int64(len(e.Output)) + outputsSize(e.Outputs)
+	for _, sub := range e.SubOutputs {
+		n += int64(len(sub.Output)) + outputsSize(sub.Outputs)
+	}
+	return n
 }
 
-func cachePath(stepID string) string {
-	return filepath.Join(config.CacheDir, stepID+".json")
+func outputsSize(outputs map[string]string) int64 {
+	var n int64
+	for k, v := range outputs {
+		n += int64(len(k) + len(v))
+	}
+	return n
 }
 
-// Save writes a cache entry atomically (tmp + rename).
-func Save(entry *Entry) error {
-	path := cachePath(entry.StepID)
-	data, err := json.MarshalIndent(entry, "", "  ")
-	if err != nil {
-		return fmt.Errorf("marshaling cache: %w", err)
-	}
+// Stats counts how often runs of a pipeline's step found its entry usable.
+// They carry over when the entry is replaced, so they describe the step
+// rather than one key. Counts from concurrent runs may be lost.
+type Stats struct {
+	Hits      int        `json:"hits"`
+	Misses    int        `json:"misses"`
+	LastHitAt *time.Time `json:"last_hit_at,omitempty"`
+}
 
-	tmp := path + ".tmp"
-	if err := os.WriteFile(tmp, data, 0o644); err != nil {
-		return fmt.Errorf("writing cache tmp: %w", err)
-	}
-	if err := os.Rename(tmp, path); err != nil {
-		return fmt.Errorf("renaming cache: %w", err)
-	}
-	return nil
+// SubEntry stores per-sub-run cached output.
+type SubEntry struct {
+	ID        string            `json:"id"`
+	Output    string            `json:"output,omitempty"`
+	Outputs   map[string]string `json:"outputs,omitempty"`
+	Sensitive bool              `json:"sensitive"`
+	ExitCode  int               `json:"exit_code"`
 }
 
-// Load reads a cache entry by step ID.
-// Returns nil, nil if the file does not exist (not an error).
-func Load(stepID string) (*Entry, error) {
-	path := cachePath(stepID)
-	data, err := os.ReadFile(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("reading cache: %w", err)
-	}
-	var entry Entry
-	if err := json.Unmarshal(data, &entry); err != nil {
-		return nil, fmt.Errorf("parsing cache for %q: %w", stepID, err)
-	}
-	return &entry, nil
+// Save writes a cache entry to the local store.
+func Save(entry *Entry) error {
+	return Local().Save(entry, "")
+}
+
+// Load reads the cache entry of a pipeline's step from the local store.
+// Returns nil, nil if there is none (not an error).
+func Load(pipeline, stepID string) (*Entry, error) {
+	return Local().Load(pipeline, stepID)
 }
 
 // IsValid checks whether a cache entry is still valid at the given time.
@@ -83,59 +89,66 @@ func IsValid(entry *Entry, now time.Time) bool {
 	return now.Before(*entry.ExpiresAt)
 }
 
-// Clear removes the cache entry for a specific step.
-func Clear(stepID string) error {
-	path := cachePath(stepID)
-	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("clearing cache for %q: %w", stepID, err)
-	}
-	return nil
+// Clear removes the local cache entry of a pipeline's step and its artifacts.
+func Clear(pipeline, stepID string) error {
+	return Local().Clear(pipeline, stepID)
 }
 
-// ClearAll removes all cache entries.
+// ClearAll removes all local cache entries and their artifacts.
 func ClearAll() error {
-	entries, err := os.ReadDir(config.CacheDir)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
-		return fmt.Errorf("reading cache dir: %w", err)
-	}
-	for _, e := range entries {
-		if !strings.HasSuffix(e.Name(), ".json") {
-			continue
-		}
-		path := filepath.Join(config.CacheDir, e.Name())
-		if err := os.Remove(path); err != nil {
-			return fmt.Errorf("removing %s: %w", e.Name(), err)
-		}
-	}
-	return nil
+	return Local().ClearAll()
 }
 
-// List returns all cache entries.
+// List returns all local cache entries.
 func List() ([]*Entry, error) {
-	entries, err := os.ReadDir(config.CacheDir)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("reading cache dir: %w", err)
-	}
+	return Local().List()
+}
+
+// layoutFile records the layout of the cache directory. Migrate writes it
+// once the directory holds no entries of an older layout, so later calls
+// only read it.
+const layoutFile = ".layout"
+
+// layoutVersion is the layout DirStore uses: entries keyed by pipeline.
+const layoutVersion = "2"
 
-	var result []*Entry
-	for _, e := range entries {
-		if !strings.HasSuffix(e.Name(), ".json") {
+// Migrate removes entries left in the flat <step>.json layout used before
+// entries were keyed by pipeline, with their artifacts, and returns how many
+// it removed. Those entries name no pipeline and have no key, so they can
+// neither be moved nor ever hit; the steps are simply cached again on their
+// next run. The directory is only scanned until the layout file is written.
+func Migrate() (int, error) {
+	marker := filepath.Join(config.CacheDir, layoutFile)
+	if data, err := os.ReadFile(marker); err == nil && strings.TrimSpace(string(data)) == layoutVersion {
+		return 0, nil
+	}
+	files, err := os.ReadDir(config.CacheDir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("reading cache dir: %w", err)
+	}
+	var n int
+	for _, f := range files {
+		stepID, ok := strings.CutSuffix(f.Name(), ".json")
+		if f.IsDir() || !ok {
 			continue
 		}
-		stepID := strings.TrimSuffix(e.Name(), ".json")
-		entry, err := Load(stepID)
-		if err != nil {
-			continue // skip corrupt entries
+		if err := os.Remove(filepath.Join(config.CacheDir, f.Name())); err != nil {
+			return n, fmt.Errorf("removing old cache entry %s: %w", f.Name(), err)
 		}
-		if entry != nil {
-			result = append(result, entry)
+		// artifacts/<name> is also where a pipeline of that name keeps
+		// its artifacts now; leave those alone.
+		if _, err := os.Stat(filepath.Join(config.CacheDir, "entries", stepID)); os.IsNotExist(err) {
+			if err := os.RemoveAll(filepath.Join(config.CacheDir, "artifacts", stepID)); err != nil {
+				return n, fmt.Errorf("removing old cached artifacts of %q: %w", stepID, err)
+			}
 		}
+		n++
+	}
+	if err := os.MkdirAll(config.CacheDir, 0o755); err != nil {
+		return n, fmt.Errorf("creating cache dir: %w", err)
+	}
+	if err := os.WriteFile(marker, []byte(layoutVersion+"\n"), 0o644); err != nil {
+		return n, fmt.Errorf("writing cache layout version: %w", err)
 	}
-	return result, nil
+	return n, nil
 }
@@ -24,6 +24,7 @@ func TestSaveLoad_Roundtrip(t *testing.T) {
 	now := time.Now().Truncate(time.Second)
 	exp := now.Add(time.Hour)
 	entry := &Entry{
+		Pipeline:  "app",
 		StepID:    "build",
 		CachedAt:  now,
 		ExpiresAt: &exp,
@@ -37,7 +38,7 @@ func TestSaveLoad_Roundtrip(t *testing.T) {
 		t.Fatalf("Save: %v", err)
 	}
 
-	loaded, err := Load("build")
+	loaded, err := Load("app", "build")
 	if err != nil {
 		t.Fatalf("Load: %v", err)
 	}
@@ -65,6 +66,7 @@ func TestSaveLoad_WithSubOutputs(t *testing.T) {
 	overrideCacheDir(t)
 
 	entry := &Entry{
+		Pipeline: "app",
 		StepID:   "deploy",
 		CachedAt: time.Now(),
 		ExitCode: 0,
@@ -79,7 +81,7 @@ func TestSaveLoad_WithSubOutputs(t *testing.T) {
 		t.Fatalf("Save: %v", err)
 	}
 
-	loaded, err := Load("deploy")
+	loaded, err := Load("app", "deploy")
 	if err != nil {
 		t.Fatalf("Load: %v", err)
 	}
@@ -94,10 +96,36 @@ func TestSaveLoad_WithSubOutputs(t *testing.T) {
 	}
 }
 
+func TestSaveLoad_NamespacedByPipeline(t *testing.T) {
+	overrideCacheDir(t)
+
+	for _, p := range []string{"api", "web"} {
+		if err := Save(&Entry{Pipeline: p, StepID: "build", Output: p, RunType: "single"}); err != nil {
+			t.Fatalf("Save %s: %v", p, err)
+		}
+	}
+	for _, p := range []string{"api", "web"} {
+		loaded, err := Load(p, "build")
+		if err != nil {
+			t.Fatalf("Load %s: %v", p, err)
+		}
+		if loaded == nil || loaded.Output != p {
+			t.Fatalf("expected %s's entry, got %+v", p, loaded)
+		}
+	}
+
+	if err := Clear("api", "build"); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if loaded, _ := Load("web", "build"); loaded == nil {
+		t.Fatal("clearing api's entry removed web's")
+	}
+}
+
 func TestLoad_Missing(t *testing.T) {
 	overrideCacheDir(t)
 
-	entry, err := Load("nonexistent")
+	entry, err := Load("app", "nonexistent")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -107,7 +135,7 @@ func TestLoad_Missing(t *testing.T) {
 }
 
 func TestIsValid_NoExpiry(t *testing.T) {
-	entry := &Entry{StepID: "test", CachedAt: time.Now()}
+	entry := &Entry{Pipeline: "app", StepID: "test", CachedAt: time.Now()}
 	if !IsValid(entry, time.Now()) {
 		t.Fatal("entry with no expiry should always be valid")
 	}
@@ -138,16 +166,16 @@ func TestIsValid_Nil(t *testing.T) {
 func TestClear(t *testing.T) {
 	overrideCacheDir(t)
 
-	entry := &Entry{StepID: "test", CachedAt: time.Now(), RunType: "single"}
+	entry := &Entry{Pipeline: "app", StepID: "test", CachedAt: time.Now(), RunType: "single"}
 	if err := Save(entry); err != nil {
 		t.Fatalf("Save: %v", err)
 	}
 
-	if err := Clear("test"); err != nil {
+	if err := Clear("app", "test"); err != nil {
 		t.Fatalf("Clear: %v", err)
 	}
 
-	loaded, err := Load("test")
+	loaded, err := Load("app", "test")
 	if err != nil {
 		t.Fatalf("Load: %v", err)
 	}
@@ -158,7 +186,7 @@ func TestClear(t *testing.T) {
 
 func TestClear_Nonexistent(t *testing.T) {
 	overrideCacheDir(t)
-	if err := Clear("nonexistent"); err != nil {
+	if err := Clear("app", "nonexistent"); err != nil {
 		t.Fatalf("Clear nonexistent should not error: %v", err)
 	}
 }
@@ -167,7 +195,7 @@ func TestClearAll(t *testing.T) {
 	overrideCacheDir(t)
 
 	for _, id := range []string{"a", "b", "c"} {
-		entry := &Entry{StepID: id, CachedAt: time.Now(), RunType: "single"}
+		entry := &Entry{Pipeline: "app", StepID: id, CachedAt: time.Now(), RunType: "single"}
 		if err := Save(entry); err != nil {
 			t.Fatalf("Save %s: %v", id, err)
 		}
@@ -190,7 +218,7 @@ func TestList(t *testing.T) {
 	overrideCacheDir(t)
 
 	for _, id := range []string{"x", "y"} {
-		entry := &Entry{StepID: id, CachedAt: time.Now(), RunType: "single"}
+		entry := &Entry{Pipeline: "app", StepID: id, CachedAt: time.Now(), RunType: "single"}
 		if err := Save(entry); err != nil {
 			t.Fatalf("Save %s: %v", id, err)
 		}
@@ -220,12 +248,12 @@ func TestList_EmptyDir(t *testing.T) {
 func TestSave_NoTmpFileRemains(t *testing.T) {
 	dir := overrideCacheDir(t)
 
-	entry := &Entry{StepID: "clean", CachedAt: time.Now(), RunType: "single"}
+	entry := &Entry{Pipeline: "app", StepID: "clean", CachedAt: time.Now(), RunType: "single"}
 	if err := Save(entry); err != nil {
 		t.Fatalf("Save: %v", err)
 	}
 
-	files, _ := os.ReadDir(dir)
+	files, _ := os.ReadDir(filepath.Join(dir, "entries", "app"))
 	for _, f := range files {
 		if filepath.Ext(f.Name()) == ".tmp" {
 			t.Fatalf("tmp file leaked: %s", f.Name())
@@ -237,6 +265,7 @@ func TestSave_SensitiveNoOutput(t *testing.T) {
 	overrideCacheDir(t)
 
 	entry := &Entry{
+		Pipeline:  "app",
 		StepID:    "secret",
 		CachedAt:  time.Now(),
 		ExitCode:  0,
@@ -248,7 +277,7 @@ func TestSave_SensitiveNoOutput(t *testing.T) {
 		t.Fatalf("Save: %v", err)
 	}
 
-	loaded, err := Load("secret")
+	loaded, err := Load("app", "secret")
 	if err != nil {
 		t.Fatalf("Load: %v", err)
 	}
@@ -259,3 +288,84 @@ func TestSave_SensitiveNoOutput(t *testing.T) {
 		t.Fatal("expected sensitive=true")
 	}
 }
+
+func TestMigrate(t *testing.T) {
+	dir := overrideCacheDir(t)
+
+	if err := Save(&Entry{Pipeline: "app", StepID: "build", RunType: "single"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	// Flat layout: <step>.json with artifacts/<step>.
+	if err := os.WriteFile(filepath.Join(dir, "build.json"), []byte(`{"step_id":"build"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "artifacts", "build"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := Migrate()
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 migrated entry, got %d", n)
+	}
+	for _, p := range []string{"build.json", filepath.Join("artifacts", "build")} {
+		if _, err := os.Stat(filepath.Join(dir, p)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed, got %v", p, err)
+		}
+	}
+	if loaded, _ := Load("app", "build"); loaded == nil {
+		t.Fatal("Migrate removed an entry in the current layout")
+	}
+
+	if n, err := Migrate(); err != nil || n != 0 {
+		t.Fatalf("second Migrate: n=%d err=%v", n, err)
+	}
+
+	// Once the layout is recorded the directory is not scanned again.
+	if err := os.WriteFile(filepath.Join(dir, "deploy.json"), []byte(`{"step_id":"deploy"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := Migrate(); err != nil || n != 0 {
+		t.Fatalf("third Migrate: n=%d err=%v", n, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "deploy.json")); err != nil {
+		t.Fatalf("expected Migrate to skip the scan, got %v", err)
+	}
+}
+
+func TestMigrate_KeepsPipelineArtifacts(t *testing.T) {
+	dir := overrideCacheDir(t)
+
+	// A pipeline named like an old flat entry's step.
+	if err := Save(&Entry{Pipeline: "build", StepID: "compile", RunType: "single"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	kept := filepath.Join(dir, "artifacts", "build", "compile")
+	if err := os.MkdirAll(kept, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "build.json"), []byte(`{"step_id":"build"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := Migrate(); err != nil || n != 1 {
+		t.Fatalf("Migrate: n=%d err=%v", n, err)
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Fatalf("expected the pipeline's artifacts to be kept, got %v", err)
+	}
+}
+
+func TestEntry_Size(t *testing.T) {
+	e := &Entry{
+		Output:        "1.2.0\n",
+		Outputs:       map[string]string{"tag": "v1"},
+		SubOutputs:    []SubEntry{{ID: "linux", Output: "ok"}},
+		ArtifactsSize: 100,
+	}
+	if got := e.Size(); got != 100+6+5+2 {
+		t.Fatalf("expected 113 bytes, got %d", got)
+	}
+}
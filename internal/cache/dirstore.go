@@ -0,0 +1,200 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DirStore keeps entries as <Root>/entries/<pipeline>/<step>.json and their
+// artifacts as tar files in <Root>/artifacts/<pipeline>/<step>/<key>.tar.
+// Every file is written to a unique temp file and renamed into place, so
+// several processes or machines can share Root (e.g. an NFS mount): readers
+// see a whole file or none, and the last writer wins. Artifacts are named by
+// the entry's key, so an entry never points at another writer's files.
+type DirStore struct {
+	Root   string
+	Shared bool // a directory other than ~/.pipe/cache, for String
+}
+
+func (s *DirStore) entryPath(pipeline, stepID string) string {
+	return filepath.Join(s.Root, "entries", pipeline, stepID+".json")
+}
+
+func (s *DirStore) artifactsDir(pipeline, stepID string) string {
+	return filepath.Join(s.Root, "artifacts", pipeline, stepID)
+}
+
+func (s *DirStore) Load(pipeline, stepID string) (*Entry, error) {
+	return loadEntry(s.entryPath(pipeline, stepID))
+}
+
+func loadEntry(path string) (*Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading cache: %w", err)
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("parsing cache %s: %w", path, err)
+	}
+	return &entry, nil
+}
+
+func (s *DirStore) Save(entry *Entry, artifactsDir string) error {
+	dir := s.artifactsDir(entry.Pipeline, entry.StepID)
+	keep := entry.Key + ".tar"
+	if len(entry.Artifacts) > 0 {
+		path := filepath.Join(dir, keep)
+		err := writeAtomic(path, func(w io.Writer) error {
+			return writeTar(w, artifactsDir)
+		})
+		if err != nil {
+			return fmt.Errorf("storing cached artifacts: %w", err)
+		}
+		if info, err := os.Stat(path); err == nil {
+			entry.ArtifactsSize = info.Size()
+		}
+	}
+	if err := s.writeEntry(entry); err != nil {
+		return err
+	}
+	// Drop the artifacts of entries this one replaced. Recent ones may
+	// belong to a writer that has not saved its entry yet.
+	old, _ := os.ReadDir(dir)
+	for _, f := range old {
+		if f.Name() == keep || !strings.HasSuffix(f.Name(), ".tar") {
+			continue
+		}
+		if info, err := f.Info(); err == nil && time.Since(info.ModTime()) > artifactsGrace {
+			_ = os.Remove(filepath.Join(dir, f.Name()))
+		}
+	}
+	return nil
+}
+
+// Update rewrites entry unless another writer has replaced it with an entry
+// for a different key since it was loaded.
+func (s *DirStore) Update(entry *Entry) error {
+	current, err := s.Load(entry.Pipeline, entry.StepID)
+	if err != nil || current == nil || current.Key != entry.Key {
+		return err
+	}
+	return s.writeEntry(entry)
+}
+
+func (s *DirStore) writeEntry(entry *Entry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling cache: %w", err)
+	}
+	err = writeAtomic(s.entryPath(entry.Pipeline, entry.StepID), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("writing cache: %w", err)
+	}
+	return nil
+}
+
+// artifactsGrace is how long artifacts not referenced by an entry are kept,
+// so a concurrent writer's artifacts survive until its entry is saved.
+const artifactsGrace = 10 * time.Minute
+
+func (s *DirStore) Artifacts(entry *Entry, dir string) error {
+	f, err := os.Open(filepath.Join(s.artifactsDir(entry.Pipeline, entry.StepID), entry.Key+".tar"))
+	if err != nil {
+		return fmt.Errorf("reading cached artifacts: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	return readTar(f, dir)
+}
+
+func (s *DirStore) List() ([]*Entry, error) {
+	var result []*Entry
+	err := filepath.WalkDir(filepath.Join(s.Root, "entries"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return fmt.Errorf("reading cache dir: %w", err)
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+		entry, err := loadEntry(path)
+		if err != nil || entry == nil {
+			return nil // skip corrupt entries
+		}
+		result = append(result, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *DirStore) Clear(pipeline, stepID string) error {
+	if err := os.Remove(s.entryPath(pipeline, stepID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("clearing cache for %q: %w", stepID, err)
+	}
+	if err := os.RemoveAll(s.artifactsDir(pipeline, stepID)); err != nil {
+		return fmt.Errorf("clearing cached artifacts for %q: %w", stepID, err)
+	}
+	return nil
+}
+
+func (s *DirStore) ClearAll() error {
+	if err := os.RemoveAll(filepath.Join(s.Root, "artifacts")); err != nil {
+		return fmt.Errorf("removing cached artifacts: %w", err)
+	}
+	if err := os.RemoveAll(filepath.Join(s.Root, "entries")); err != nil {
+		return fmt.Errorf("removing cache entries: %w", err)
+	}
+	return nil
+}
+
+func (s *DirStore) String() string {
+	if s.Shared {
+		return "cache store " + s.Root
+	}
+	return "local cache"
+}
+
+// writeAtomic writes path through a uniquely named temp file in the same
+// directory, synced and renamed into place.
+func writeAtomic(path string, write func(io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if err := write(tmp); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -1,58 +1,245 @@
 package cache
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// expiryHelp lists the accepted formats in ParseExpiry errors.
+const expiryHelp = "expected a duration (1h, 1d, 2w, P1DT2H), a time of day (18:10 UTC, 09:00 Europe/Berlin), a weekday and time (monday 06:00), end_of_day or a cron expression (0 6 * * 1-5)"
+
 // ParseExpiry computes the concrete expiration time from an expireAfter string.
 // Supported formats:
-//   - Duration: "10m", "1h", "30s" — expires at cachedAt + duration
-//   - Absolute: "18:10 UTC", "18:10" — next occurrence of that wall-clock time
+//   - Duration: "10m", "1h", "1d", "2w", "1d12h" — expires at cachedAt + duration
+//   - ISO-8601 duration: "P1D", "PT30M", "P1DT2H" — expires at cachedAt + duration
+//   - Time of day: "18:10", "18:10 UTC", "09:00 Europe/Berlin" — next occurrence
+//   - Weekday: "monday 06:00", "fri 17:00 UTC" — next occurrence
+//   - "end_of_day", "end_of_day UTC" — next midnight
+//   - Cron: "0 6 * * 1-5", "@daily" — next scheduled time
 //   - Empty: returns zero time (no expiry, cache is permanent)
+//
+// Times without a zone are in cachedAt's location, which is local time when
+// the runner saves an entry.
 func ParseExpiry(expireAfter string, cachedAt time.Time) (time.Time, error) {
-	if expireAfter == "" {
+	s := strings.TrimSpace(expireAfter)
+	if s == "" {
 		return time.Time{}, nil
 	}
 
-	// Try duration first (e.g. "10m", "1h", "30s")
-	if d, err := time.ParseDuration(expireAfter); err == nil {
-		return cachedAt.Add(d), nil
+	exp, err := parseExpiry(s, cachedAt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiry %q: %w", expireAfter, err)
 	}
+	return exp, nil
+}
 
-	// Try absolute time (e.g. "18:10 UTC", "18:10")
-	return parseAbsoluteTime(expireAfter, cachedAt)
+// ValidateExpiry reports whether expireAfter is a valid ParseExpiry format.
+func ValidateExpiry(expireAfter string) error {
+	_, err := ParseExpiry(expireAfter, time.Now())
+	return err
+}
+
+func parseExpiry(s string, cachedAt time.Time) (time.Time, error) {
+	if strings.HasPrefix(s, "@") {
+		return parseCronExpiry(s, cachedAt)
+	}
+	fields := strings.Fields(s)
+	switch len(fields) {
+	case 1:
+		if fields[0] == "end_of_day" {
+			return nextClock(cachedAt, cachedAt.Location(), 0, 0), nil
+		}
+		if strings.HasPrefix(strings.ToUpper(s), "P") {
+			return parseISODuration(s, cachedAt)
+		}
+		if t, ok, err := parseDuration(s, cachedAt); ok || err != nil {
+			return t, err
+		}
+		return parseTimeOfDay(fields[0], "", cachedAt)
+	case 2:
+		if fields[0] == "end_of_day" {
+			loc, err := loadZone(fields[1])
+			if err != nil {
+				return time.Time{}, err
+			}
+			return nextClock(cachedAt, loc, 0, 0), nil
+		}
+		if _, ok := parseWeekday(fields[0]); ok {
+			return parseWeekdayTime(fields[0], fields[1], "", cachedAt)
+		}
+		return parseTimeOfDay(fields[0], fields[1], cachedAt)
+	case 3:
+		return parseWeekdayTime(fields[0], fields[1], fields[2], cachedAt)
+	case 5:
+		return parseCronExpiry(s, cachedAt)
+	}
+	return time.Time{}, errors.New(expiryHelp)
 }
 
-// parseAbsoluteTime handles "HH:MM UTC" and "HH:MM" (local time).
-func parseAbsoluteTime(s string, cachedAt time.Time) (time.Time, error) {
-	s = strings.TrimSpace(s)
+// dayDuration splits weeks and days off the front of a Go duration, which
+// has no units longer than an hour.
+var dayDuration = regexp.MustCompile(`^(?:(\d+)w)?(?:(\d+)d)?(.*)$`)
+
+// errNotDuration is returned by splitDuration for strings that are not
+// shaped like a duration at all.
+var errNotDuration = errors.New("not a duration")
+
+// splitDuration parses a Go duration extended with d and w units into whole
+// days and the remaining clock duration.
+func splitDuration(s string) (days int, clock time.Duration, err error) {
+	m := dayDuration.FindStringSubmatch(s)
+	days, rest := atoi(m[1])*7+atoi(m[2]), m[3]
+	hasDays := m[1] != "" || m[2] != ""
 
-	var loc *time.Location
-	var timePart string
+	if rest != "" || !hasDays {
+		clock, err = time.ParseDuration(rest)
+		if err != nil {
+			if hasDays {
+				return 0, 0, errors.New(expiryHelp)
+			}
+			return 0, 0, errNotDuration
+		}
+	}
+	if clock < 0 {
+		return 0, 0, errors.New("duration must not be negative")
+	}
+	return days, clock, nil
+}
 
-	if strings.HasSuffix(s, " UTC") {
-		loc = time.UTC
-		timePart = strings.TrimSuffix(s, " UTC")
-	} else {
-		loc = cachedAt.Location()
-		timePart = s
+// parseDuration handles Go durations extended with d and w units. ok is
+// false when s is not shaped like a duration at all.
+func parseDuration(s string, cachedAt time.Time) (t time.Time, ok bool, err error) {
+	days, clock, err := splitDuration(s)
+	if errors.Is(err, errNotDuration) {
+		return time.Time{}, false, nil
 	}
+	if err != nil {
+		return time.Time{}, true, err
+	}
+	return cachedAt.AddDate(0, 0, days).Add(clock), true, nil
+}
 
-	parsed, err := time.Parse("15:04", timePart)
+// ParseDuration parses a Go duration extended with d (24h) and w (7d)
+// units, such as 7d or 1d12h.
+func ParseDuration(s string) (time.Duration, error) {
+	days, clock, err := splitDuration(strings.TrimSpace(s))
 	if err != nil {
-		return time.Time{}, fmt.Errorf("invalid expiry %q: expected duration (e.g. 1h) or time (e.g. 18:10 UTC)", s)
+		return 0, fmt.Errorf("invalid duration %q: expected e.g. 12h, 7d or 2w", s)
 	}
+	return time.Duration(days)*24*time.Hour + clock, nil
+}
 
-	// Build today's occurrence of that time in the target timezone
-	y, m, d := cachedAt.In(loc).Date()
-	candidate := time.Date(y, m, d, parsed.Hour(), parsed.Minute(), 0, 0, loc)
+// isoDuration matches ISO-8601 durations such as P1Y2M, P2W, P1DT2H30M.
+var isoDuration = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
 
-	// If the time has already passed, push to tomorrow
-	if !candidate.After(cachedAt) {
-		candidate = candidate.Add(24 * time.Hour)
+// parseISODuration handles ISO-8601 durations. Years, months, weeks and days
+// are calendar units; hours, minutes and seconds are elapsed time.
+func parseISODuration(s string, cachedAt time.Time) (time.Time, error) {
+	u := strings.ToUpper(s)
+	m := isoDuration.FindStringSubmatch(u)
+	if m == nil || u == "P" || strings.HasSuffix(u, "T") {
+		return time.Time{}, fmt.Errorf("invalid ISO-8601 duration; %s", expiryHelp)
 	}
+	secs, _ := strconv.ParseFloat(m[7], 64)
+	clock := time.Duration(atoi(m[5]))*time.Hour +
+		time.Duration(atoi(m[6]))*time.Minute +
+		time.Duration(secs*float64(time.Second))
+	return cachedAt.AddDate(atoi(m[1]), atoi(m[2]), atoi(m[3])*7+atoi(m[4])).Add(clock), nil
+}
+
+// parseTimeOfDay handles "HH:MM" with an optional zone: UTC, Local or an
+// IANA name such as Europe/Berlin.
+func parseTimeOfDay(clock, zone string, cachedAt time.Time) (time.Time, error) {
+	hour, minute, err := parseClock(clock)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc := cachedAt.Location()
+	if zone != "" {
+		if loc, err = loadZone(zone); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return nextClock(cachedAt, loc, hour, minute), nil
+}
 
+// parseWeekdayTime handles "<weekday> HH:MM" with an optional zone.
+func parseWeekdayTime(day, clock, zone string, cachedAt time.Time) (time.Time, error) {
+	wd, ok := parseWeekday(day)
+	if !ok {
+		return time.Time{}, fmt.Errorf("unknown weekday %q; %s", day, expiryHelp)
+	}
+	hour, minute, err := parseClock(clock)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc := cachedAt.Location()
+	if zone != "" {
+		if loc, err = loadZone(zone); err != nil {
+			return time.Time{}, err
+		}
+	}
+	local := cachedAt.In(loc)
+	ahead := (int(wd) - int(local.Weekday()) + 7) % 7
+	y, m, d := local.Date()
+	candidate := time.Date(y, m, d+ahead, hour, minute, 0, 0, loc)
+	if !candidate.After(cachedAt) {
+		candidate = time.Date(y, m, d+ahead+7, hour, minute, 0, 0, loc)
+	}
 	return candidate, nil
 }
+
+// nextClock returns the first hour:minute in loc after t.
+func nextClock(t time.Time, loc *time.Location, hour, minute int) time.Time {
+	y, m, d := t.In(loc).Date()
+	candidate := time.Date(y, m, d, hour, minute, 0, 0, loc)
+	// If the time has already passed, push to tomorrow
+	if !candidate.After(t) {
+		candidate = time.Date(y, m, d+1, hour, minute, 0, 0, loc)
+	}
+	return candidate
+}
+
+func parseClock(s string) (hour, minute int, err error) {
+	parsed, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, 0, errors.New(expiryHelp)
+	}
+	return parsed.Hour(), parsed.Minute(), nil
+}
+
+func loadZone(name string) (*time.Location, error) {
+	if name == "UTC" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "" {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return loc, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+func parseWeekday(s string) (time.Weekday, bool) {
+	wd, ok := weekdays[strings.ToLower(s)]
+	return wd, ok
+}
+
+// atoi converts a regexp group that is empty or all digits.
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
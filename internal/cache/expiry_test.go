@@ -109,3 +109,83 @@ func TestParseExpiry_Invalid(t *testing.T) {
 		t.Fatal("expected error for invalid expiry")
 	}
 }
+
+func TestParseExpiry_Formats(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	// Tuesday 10:00 UTC.
+	cachedAt := time.Date(2026, 2, 17, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"1d", time.Date(2026, 2, 18, 10, 0, 0, 0, time.UTC)},
+		{"2w", time.Date(2026, 3, 3, 10, 0, 0, 0, time.UTC)},
+		{"1d12h", time.Date(2026, 2, 18, 22, 0, 0, 0, time.UTC)},
+		{"P1DT2H", time.Date(2026, 2, 18, 12, 0, 0, 0, time.UTC)},
+		{"PT30M", time.Date(2026, 2, 17, 10, 30, 0, 0, time.UTC)},
+		{"P1M", time.Date(2026, 3, 17, 10, 0, 0, 0, time.UTC)},
+		{"09:00 Europe/Berlin", time.Date(2026, 2, 18, 9, 0, 0, 0, berlin)},
+		{"12:00 Europe/Berlin", time.Date(2026, 2, 17, 12, 0, 0, 0, berlin)},
+		{"monday 06:00", time.Date(2026, 2, 23, 6, 0, 0, 0, time.UTC)},
+		{"tue 09:00", time.Date(2026, 2, 24, 9, 0, 0, 0, time.UTC)},
+		{"Tuesday 11:00 UTC", time.Date(2026, 2, 17, 11, 0, 0, 0, time.UTC)},
+		{"end_of_day", time.Date(2026, 2, 18, 0, 0, 0, 0, time.UTC)},
+		{"end_of_day Europe/Berlin", time.Date(2026, 2, 18, 0, 0, 0, 0, berlin)},
+		{"0 6 * * 1-5", time.Date(2026, 2, 18, 6, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 2, 17, 10, 15, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 4 * * sun", time.Date(2026, 2, 22, 4, 30, 0, 0, time.UTC)},
+		{"0 0 13 * fri", time.Date(2026, 2, 20, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2026, 2, 22, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			exp, err := ParseExpiry(tt.expr, cachedAt)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !exp.Equal(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, exp)
+			}
+		})
+	}
+}
+
+func TestParseExpiry_InvalidFormats(t *testing.T) {
+	for _, expr := range []string{
+		"1x",
+		"1d2",
+		"-1h",
+		"P",
+		"P1DT",
+		"P1H",
+		"25:00",
+		"09:00 Mars/Olympus",
+		"someday 06:00",
+		"0 6 * *",
+		"60 * * * *",
+		"0 0 30 2 *",
+		"*/0 * * * *",
+		"@fortnightly",
+	} {
+		if err := ValidateExpiry(expr); err == nil {
+			t.Errorf("expected error for %q", expr)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for in, want := range map[string]time.Duration{"12h": 12 * time.Hour, "7d": 7 * 24 * time.Hour, "2w": 14 * 24 * time.Hour, "1d30m": 24*time.Hour + 30*time.Minute} {
+		if got, err := ParseDuration(in); err != nil || got != want {
+			t.Errorf("ParseDuration(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "7", "7x", "-1d", "monday"} {
+		if _, err := ParseDuration(in); err == nil {
+			t.Errorf("expected error for %q", in)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// HTTPStore keeps entries on a server speaking a plain GET/PUT/DELETE
+// protocol under a base URL:
+//
+//	GET|PUT|DELETE <base>/<pipeline>/<step>.json     the entry as JSON
+//	GET|PUT        <base>/<pipeline>/<step>/<key>.tar its artifacts
+//
+// A missing entry is a 404. Any static file server that accepts PUT, or a
+// bucket behind a proxy, will do. Requests carry PIPE_CACHE_TOKEN as a
+// bearer token when it is set.
+type HTTPStore struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+// NewHTTPStore returns a store for the server at base.
+func NewHTTPStore(base, token string) *HTTPStore {
+	return &HTTPStore{
+		base:  strings.TrimSuffix(base, "/"),
+		token: token,
+		client: &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: 10 * time.Second}).DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+		}},
+	}
+}
+
+// errNotFound is returned by do for a 404 response.
+var errNotFound = errors.New("not found")
+
+// url joins the base URL and path segments, escaping each segment of path.
+func (s *HTTPStore) url(path string) string {
+	segs := strings.Split(path, "/")
+	for i, seg := range segs {
+		segs[i] = url.PathEscape(seg)
+	}
+	return s.base + "/" + strings.Join(segs, "/")
+}
+
+// do sends a request and returns the response body for a 2xx status. The
+// caller closes the body.
+func (s *HTTPStore) do(method, path string, body io.Reader) (io.ReadCloser, error) {
+	req, err := http.NewRequest(method, s.url(path), body)
+	if err != nil {
+		return nil, err
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		_ = resp.Body.Close()
+		return nil, errNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", method, req.URL.Redacted(), resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func (s *HTTPStore) Load(pipeline, stepID string) (*Entry, error) {
+	body, err := s.do(http.MethodGet, pipeline+"/"+stepID+".json", nil)
+	if errors.Is(err, errNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = body.Close() }()
+	var entry Entry
+	if err := json.NewDecoder(body).Decode(&entry); err != nil {
+		return nil, fmt.Errorf("parsing cache for %q: %w", stepID, err)
+	}
+	return &entry, nil
+}
+
+func (s *HTTPStore) Save(entry *Entry, artifactsDir string) error {
+	if len(entry.Artifacts) > 0 {
+		// Buffered in a temp file so the request has a Content-Length.
+		tmp, err := os.CreateTemp("", "pipe-artifacts-*.tar")
+		if err != nil {
+			return err
+		}
+		defer func() { _ = os.Remove(tmp.Name()) }()
+		defer func() { _ = tmp.Close() }()
+		if err := writeTar(tmp, artifactsDir); err != nil {
+			return fmt.Errorf("storing cached artifacts: %w", err)
+		}
+		size, err := tmp.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := s.put(entry.Pipeline+"/"+entry.StepID+"/"+entry.Key+".tar", tmp); err != nil {
+			return err
+		}
+		entry.ArtifactsSize = size
+	}
+	return s.Update(entry)
+}
+
+// Update rewrites entry. Unlike DirStore it does not check that the server
+// still holds an entry for the same key, to save a request per cache hit.
+func (s *HTTPStore) Update(entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("marshaling cache: %w", err)
+	}
+	return s.put(entry.Pipeline+"/"+entry.StepID+".json", bytes.NewReader(data))
+}
+
+func (s *HTTPStore) put(path string, body io.Reader) error {
+	resp, err := s.do(http.MethodPut, path, body)
+	if err != nil {
+		return err
+	}
+	return resp.Close()
+}
+
+func (s *HTTPStore) Artifacts(entry *Entry, dir string) error {
+	body, err := s.do(http.MethodGet, entry.Pipeline+"/"+entry.StepID+"/"+entry.Key+".tar", nil)
+	if err != nil {
+		return fmt.Errorf("fetching cached artifacts: %w", err)
+	}
+	defer func() { _ = body.Close() }()
+	return readTar(body, dir)
+}
+
+func (s *HTTPStore) List() ([]*Entry, error) {
+	return nil, fmt.Errorf("listing the entries of %s: %w", s, errors.ErrUnsupported)
+}
+
+func (s *HTTPStore) Clear(pipeline, stepID string) error {
+	body, err := s.do(http.MethodDelete, pipeline+"/"+stepID+".json", nil)
+	if errors.Is(err, errNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return body.Close()
+}
+
+func (s *HTTPStore) ClearAll() error {
+	return fmt.Errorf("clearing all entries of %s: %w", s, errors.ErrUnsupported)
+}
+
+func (s *HTTPStore) String() string {
+	u, err := url.Parse(s.base)
+	if err != nil {
+		return "cache store " + s.base
+	}
+	return "cache store " + u.Redacted()
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// KeyParts records the digests a step's cache key was computed from, so an
+// entry shows what it depends on without storing var values or outputs.
+type KeyParts struct {
+	Command  string            `json:"command"`            // digest of the step's run, shell, dir, env and foreach
+	Vars     map[string]string `json:"vars,omitempty"`     // referenced PIPE_VAR_* name → digest of its value
+	Upstream map[string]string `json:"upstream,omitempty"` // upstream step ID → digest of its outputs
+	Files    map[string]string `json:"files,omitempty"`    // key_files path → digest of its contents
+}
+
+// Digest returns the hex SHA-256 of parts. Each part is length-prefixed so
+// ("ab", "c") and ("a", "bc") differ.
+func Digest(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(strconv.Itoa(len(p))))
+		h.Write([]byte{':'})
+		h.Write([]byte(p))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Key returns the cache key of a step in a pipeline with the given parts.
+func Key(pipeline, stepID string, parts KeyParts) string {
+	fields := []string{pipeline, stepID, parts.Command}
+	for _, group := range []struct {
+		name string
+		m    map[string]string
+	}{{"var", parts.Vars}, {"upstream", parts.Upstream}, {"file", parts.Files}} {
+		for _, k := range slices.Sorted(maps.Keys(group.m)) {
+			fields = append(fields, group.name, k, group.m[k])
+		}
+	}
+	return Digest(fields...)
+}
+
+// Changes describes how p differs from old, the parts of an earlier entry,
+// one line per component, e.g. `upstream "build" output changed (3f2a9c1d → 9be0412a)`.
+func (p KeyParts) Changes(old KeyParts) []string {
+	var changes []string
+	if p.Command != old.Command {
+		changes = append(changes, "command changed")
+	}
+	for _, group := range []struct {
+		format   string
+		old, cur map[string]string
+	}{
+		{"var %s", old.Vars, p.Vars},
+		{"upstream %q output", old.Upstream, p.Upstream},
+		{"key file %s", old.Files, p.Files},
+	} {
+		names := slices.Sorted(maps.Keys(group.old))
+		for name := range group.cur {
+			if _, ok := group.old[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			what := fmt.Sprintf(group.format, name)
+			was, hadOld := group.old[name]
+			now, hasNew := group.cur[name]
+			switch {
+			case !hadOld:
+				changes = append(changes, what+" is new")
+			case !hasNew:
+				changes = append(changes, what+" is no longer used")
+			case was != now:
+				changes = append(changes, fmt.Sprintf("%s changed (%s → %s)", what, short(was), short(now)))
+			}
+		}
+	}
+	return changes
+}
+
+// short abbreviates a digest for logs.
+func short(digest string) string {
+	if len(digest) > 8 {
+		return digest[:8]
+	}
+	return digest
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDigest_LengthPrefixed(t *testing.T) {
+	if Digest("ab", "c") == Digest("a", "bc") {
+		t.Fatal("expected different digests for different splits")
+	}
+	if Digest("x") != Digest("x") {
+		t.Fatal("expected Digest to be deterministic")
+	}
+}
+
+func TestKey(t *testing.T) {
+	base := KeyParts{
+		Command:  Digest("make"),
+		Vars:     map[string]string{"PIPE_VAR_ENV": Digest("prod")},
+		Upstream: map[string]string{"fetch": Digest("v1")},
+		Files:    map[string]string{"go.sum": Digest("h1")},
+	}
+	key := Key("app", "build", base)
+	if key != Key("app", "build", base) {
+		t.Fatal("expected Key to be deterministic")
+	}
+
+	changed := func(mod func(*KeyParts)) KeyParts {
+		p := KeyParts{Command: base.Command}
+		p.Vars = map[string]string{"PIPE_VAR_ENV": base.Vars["PIPE_VAR_ENV"]}
+		p.Upstream = map[string]string{"fetch": base.Upstream["fetch"]}
+		p.Files = map[string]string{"go.sum": base.Files["go.sum"]}
+		mod(&p)
+		return p
+	}
+	tests := []struct {
+		name     string
+		pipeline string
+		step     string
+		parts    KeyParts
+	}{
+		{"pipeline", "web", "build", base},
+		{"step", "app", "test", base},
+		{"command", "app", "build", changed(func(p *KeyParts) { p.Command = Digest("make all") })},
+		{"var", "app", "build", changed(func(p *KeyParts) { p.Vars["PIPE_VAR_ENV"] = Digest("dev") })},
+		{"upstream", "app", "build", changed(func(p *KeyParts) { p.Upstream["fetch"] = Digest("v2") })},
+		{"file", "app", "build", changed(func(p *KeyParts) { p.Files["go.sum"] = Digest("h2") })},
+		{"new file", "app", "build", changed(func(p *KeyParts) { p.Files["go.mod"] = Digest("m") })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if Key(tt.pipeline, tt.step, tt.parts) == key {
+				t.Fatalf("expected a different key when the %s changes", tt.name)
+			}
+		})
+	}
+}
+
+func TestKeyParts_Changes(t *testing.T) {
+	old := KeyParts{
+		Command:  "c1",
+		Vars:     map[string]string{"PIPE_VAR_ENV": "aaaaaaaaaaaa", "PIPE_VAR_OLD": "x"},
+		Upstream: map[string]string{"build": "1111111111111111"},
+	}
+	cur := KeyParts{
+		Command:  "c1",
+		Vars:     map[string]string{"PIPE_VAR_ENV": "bbbbbbbbbbbb"},
+		Upstream: map[string]string{"build": "2222222222222222", "fetch": "f"},
+		Files:    map[string]string{"go.sum": "s"},
+	}
+	want := []string{
+		"var PIPE_VAR_ENV changed (aaaaaaaa → bbbbbbbb)",
+		"var PIPE_VAR_OLD is no longer used",
+		`upstream "build" output changed (11111111 → 22222222)`,
+		`upstream "fetch" output is new`,
+		"key file go.sum is new",
+	}
+	if got := cur.Changes(old); !slices.Equal(got, want) {
+		t.Fatalf("Changes:\n got %q\nwant %q", got, want)
+	}
+	if got := old.Changes(old); len(got) != 0 {
+		t.Fatalf("expected no changes, got %q", got)
+	}
+	if got := (KeyParts{Command: "c2"}).Changes(KeyParts{Command: "c1"}); !slices.Equal(got, []string{"command changed"}) {
+		t.Fatalf("expected command change, got %q", got)
+	}
+}
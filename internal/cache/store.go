@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/getpipe-dev/pipe/internal/config"
+	"github.com/getpipe-dev/pipe/internal/model"
+)
+
+// Store keeps cache entries and the artifacts saved with them.
+type Store interface {
+	// Load returns the entry of a pipeline's step, or nil, nil if there is none.
+	Load(pipeline, stepID string) (*Entry, error)
+	// Save stores entry. When entry.Artifacts is set, the files under
+	// artifactsDir are stored with it first.
+	Save(entry *Entry, artifactsDir string) error
+	// Update rewrites a saved entry, e.g. its Stats, keeping its artifacts.
+	Update(entry *Entry) error
+	// Artifacts copies the artifacts stored with entry into dir.
+	Artifacts(entry *Entry, dir string) error
+	// List returns all entries, ordered by pipeline and step. Stores that
+	// cannot enumerate entries return an error wrapping
+	// errors.ErrUnsupported.
+	List() ([]*Entry, error)
+	// Clear removes the entry of a pipeline's step and its artifacts.
+	Clear(pipeline, stepID string) error
+	// ClearAll removes all entries and artifacts, or returns an error
+	// wrapping errors.ErrUnsupported like List.
+	ClearAll() error
+	// String describes the store for logs and messages.
+	String() string
+}
+
+// StoreEnv selects the cache store when a pipeline does not set cache_store.
+const StoreEnv = "PIPE_CACHE_STORE"
+
+// TokenEnv holds the bearer token sent to HTTP cache stores.
+const TokenEnv = "PIPE_CACHE_TOKEN"
+
+// Local returns the store in ~/.pipe/cache.
+func Local() Store {
+	return &DirStore{Root: config.CacheDir}
+}
+
+// Open returns the store a cache_store value or PIPE_CACHE_STORE names:
+// empty or "local" for ~/.pipe/cache, an http:// or https:// URL for an
+// HTTP store, or any other value as the path of a shared directory.
+func Open(spec string) (Store, error) {
+	switch {
+	case spec == "" || spec == "local":
+		return Local(), nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		u, err := url.Parse(spec)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("cache store %q: invalid URL", spec)
+		}
+		return NewHTTPStore(spec, os.Getenv(TokenEnv)), nil
+	default:
+		if rest, ok := strings.CutPrefix(spec, "~/"); ok {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("cache store %q: %w", spec, err)
+			}
+			spec = filepath.Join(home, rest)
+		}
+		return &DirStore{Root: spec, Shared: true}, nil
+	}
+}
+
+// ForPipeline returns the store a pipeline's cache: steps use: its
+// cache_store, else PIPE_CACHE_STORE, else the local store. Runs and the
+// cache commands both find a pipeline's entries through it; a nil p, for
+// commands not about one pipeline, selects by PIPE_CACHE_STORE alone.
+func ForPipeline(p *model.Pipeline) (Store, error) {
+	if p != nil && p.CacheStore != "" {
+		return Open(p.CacheStore)
+	}
+	return Open(os.Getenv(StoreEnv))
+}
+
+// Fallback returns a store that uses primary until one of its operations
+// fails, then reports the error through warn and uses fallback from then on.
+func Fallback(primary, fallback Store, warn func(error)) Store {
+	return &fallbackStore{primary: primary, fallback: fallback, warn: warn}
+}
+
+type fallbackStore struct {
+	primary, fallback Store
+	warn              func(error)
+	down              atomic.Bool
+}
+
+// do runs fn on the primary store while it works, and on the fallback once
+// it has failed.
+func (s *fallbackStore) do(fn func(Store) error) error {
+	if !s.down.Load() {
+		err := fn(s.primary)
+		if err == nil {
+			return nil
+		}
+		if !s.down.Swap(true) {
+			s.warn(fmt.Errorf("%s: %w", s.primary, err))
+		}
+	}
+	return fn(s.fallback)
+}
+
+func (s *fallbackStore) Load(pipeline, stepID string) (entry *Entry, err error) {
+	err = s.do(func(st Store) error {
+		entry, err = st.Load(pipeline, stepID)
+		return err
+	})
+	return entry, err
+}
+
+func (s *fallbackStore) Save(entry *Entry, artifactsDir string) error {
+	return s.do(func(st Store) error { return st.Save(entry, artifactsDir) })
+}
+
+func (s *fallbackStore) Update(entry *Entry) error {
+	return s.do(func(st Store) error { return st.Update(entry) })
+}
+
+func (s *fallbackStore) Artifacts(entry *Entry, dir string) error {
+	return s.do(func(st Store) error { return st.Artifacts(entry, dir) })
+}
+
+func (s *fallbackStore) List() (entries []*Entry, err error) {
+	err = s.do(func(st Store) error {
+		entries, err = st.List()
+		return err
+	})
+	return entries, err
+}
+
+func (s *fallbackStore) Clear(pipeline, stepID string) error {
+	return s.do(func(st Store) error { return st.Clear(pipeline, stepID) })
+}
+
+func (s *fallbackStore) ClearAll() error {
+	return s.do(func(st Store) error { return st.ClearAll() })
+}
+
+func (s *fallbackStore) String() string {
+	if s.down.Load() {
+		return s.fallback.String()
+	}
+	return s.primary.String()
+}
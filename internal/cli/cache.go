@@ -1,9 +1,15 @@
 package cli
 
-import "github.com/spf13/cobra"
+import (
+	"github.com/charmbracelet/log"
+	"github.com/getpipe-dev/pipe/internal/cache"
+	"github.com/getpipe-dev/pipe/internal/parser"
+	"github.com/getpipe-dev/pipe/internal/resolve"
+	"github.com/spf13/cobra"
+)
 
 var cacheCmd = &cobra.Command{
-	Use:   "cache",
+	Use:     "cache",
 	Short:   "Manage step cache entries",
 	GroupID: "core",
 }
@@ -11,4 +17,39 @@ var cacheCmd = &cobra.Command{
 func init() {
 	cacheCmd.AddCommand(cacheListCmd)
 	cacheCmd.AddCommand(cacheClearCmd)
+	cacheCmd.AddCommand(cacheShowCmd)
+}
+
+// configuredStore returns the cache store of the named pipeline, the one a
+// run of it uses. Without a name, or for a pipeline that cannot be loaded
+// any more, it is the store PIPE_CACHE_STORE selects, the local cache by
+// default.
+func configuredStore(pipeline string) (cache.Store, error) {
+	if pipeline == "" {
+		return cache.ForPipeline(nil)
+	}
+	ref, err := resolve.Resolve(pipeline)
+	if err != nil {
+		log.Debug("cannot resolve pipeline for its cache store", "name", pipeline, "err", err)
+		return cache.ForPipeline(nil)
+	}
+	p, err := parser.LoadPipelineFromPath(ref.Path, ref.Name)
+	if err != nil {
+		log.Debug("cannot load pipeline for its cache store", "name", pipeline, "err", err)
+		return cache.ForPipeline(nil)
+	}
+	return cache.ForPipeline(p)
+}
+
+// migrateCache drops cache entries left in the layout used before entries
+// were keyed by pipeline. After the first run it only reads a marker file.
+func migrateCache() {
+	n, err := cache.Migrate()
+	if err != nil {
+		log.Warn("cache migration failed", "err", err)
+		return
+	}
+	if n > 0 {
+		log.Info("removed cache entries from the old layout — their steps are cached again on the next run", "entries", n)
+	}
 }
@@ -1,42 +1,131 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/getpipe-dev/pipe/internal/cache"
 	"github.com/spf13/cobra"
 )
 
-var cacheClearYes bool
+var (
+	cacheClearYes       bool
+	cacheClearPipeline  string
+	cacheClearExpired   bool
+	cacheClearOlderThan string
+)
 
 func init() {
 	cacheClearCmd.Flags().BoolVarP(&cacheClearYes, "yes", "y", false, "skip confirmation prompt")
+	cacheClearCmd.Flags().StringVarP(&cacheClearPipeline, "pipeline", "p", "", "only clear entries of this pipeline")
+	cacheClearCmd.Flags().BoolVar(&cacheClearExpired, "expired", false, "only clear entries that have expired")
+	cacheClearCmd.Flags().StringVar(&cacheClearOlderThan, "older-than", "", "only clear entries cached longer ago than this (e.g. 12h, 7d, 2w)")
 }
 
 var cacheClearCmd = &cobra.Command{
 	Use:   "clear [step-id]",
-	Short: "Clear one or all cache entries",
-	Args:  maxArgs(1, "pipe cache clear [step-id]"),
+	Short: "Clear cache entries: one step's, those matching filters, or all of them",
+	Args:  maxArgs(1, "pipe cache clear [step-id] [--pipeline <name>] [--expired] [--older-than <age>]"),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if len(args) == 1 {
+		var olderThan time.Duration
+		if cacheClearOlderThan != "" {
+			d, err := cache.ParseDuration(cacheClearOlderThan)
+			if err != nil {
+				return err
+			}
+			olderThan = d
+		}
+		store, err := configuredStore(cacheClearPipeline)
+		if err != nil {
+			return err
+		}
+		filtered := cacheClearPipeline != "" || cacheClearExpired || olderThan > 0
+
+		// One step of one pipeline needs no listing, which HTTP stores
+		// do not support.
+		if len(args) == 1 && cacheClearPipeline != "" && !cacheClearExpired && olderThan == 0 {
 			stepID := args[0]
-			if !confirmAction(cacheClearYes, fmt.Sprintf("Clear cache for %q?", stepID)) {
+			if !confirmAction(cacheClearYes, fmt.Sprintf("Clear cache for %q in pipeline %q?", stepID, cacheClearPipeline)) {
 				return nil
 			}
-			if err := cache.Clear(stepID); err != nil {
+			if err := store.Clear(cacheClearPipeline, stepID); err != nil {
 				return err
 			}
+			fmt.Printf("cleared cache for %q in pipeline %q\n", stepID, cacheClearPipeline)
+			return nil
+		}
+
+		if len(args) == 1 && !filtered {
+			stepID := args[0]
+			if !confirmAction(cacheClearYes, fmt.Sprintf("Clear cache for %q in every pipeline?", stepID)) {
+				return nil
+			}
+			entries, err := store.List()
+			if err != nil {
+				return unsupported(store, err)
+			}
+			for _, e := range entries {
+				if e.StepID != stepID {
+					continue
+				}
+				if err := store.Clear(e.Pipeline, e.StepID); err != nil {
+					return err
+				}
+			}
 			fmt.Printf("cleared cache for %q\n", stepID)
 			return nil
 		}
 
+		if filtered {
+			entries, err := store.List()
+			if err != nil {
+				return unsupported(store, err)
+			}
+			now := time.Now()
+			var matches []*cache.Entry
+			for _, e := range entries {
+				switch {
+				case len(args) == 1 && e.StepID != args[0]:
+				case cacheClearPipeline != "" && e.Pipeline != cacheClearPipeline:
+				case cacheClearExpired && cache.IsValid(e, now):
+				case olderThan > 0 && now.Sub(e.CachedAt) <= olderThan:
+				default:
+					matches = append(matches, e)
+				}
+			}
+			if len(matches) == 0 {
+				fmt.Println("no matching cache entries")
+				return nil
+			}
+			if !confirmAction(cacheClearYes, fmt.Sprintf("Clear %d cache entries?", len(matches))) {
+				return nil
+			}
+			for _, e := range matches {
+				if err := store.Clear(e.Pipeline, e.StepID); err != nil {
+					return err
+				}
+			}
+			fmt.Printf("cleared %d cache entries\n", len(matches))
+			return nil
+		}
+
 		if !confirmAction(cacheClearYes, "Clear all cache entries?") {
 			return nil
 		}
-		if err := cache.ClearAll(); err != nil {
-			return err
+		if err := store.ClearAll(); err != nil {
+			return unsupported(store, err)
 		}
 		fmt.Println("cleared all cache entries")
 		return nil
 	},
 }
+
+// unsupported explains that a store cannot list or clear all of its
+// entries, and what it can do instead; other errors are returned as is.
+func unsupported(store cache.Store, err error) error {
+	if errors.Is(err, errors.ErrUnsupported) {
+		return fmt.Errorf("%s can only clear one step at a time — use pipe cache clear <step-id> --pipeline <name>", store)
+	}
+	return err
+}
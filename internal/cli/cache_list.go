@@ -1,43 +1,80 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 
 	"github.com/getpipe-dev/pipe/internal/cache"
 	"github.com/spf13/cobra"
 )
 
 var cacheListCmd = &cobra.Command{
-	Use:   "list",
-	Short: "List cached step results",
-	Args:  noArgs("pipe cache list"),
+	Use:   "list [pipeline]",
+	Short: "List cached step results, optionally of one pipeline",
+	Args:  maxArgs(1, "pipe cache list [pipeline]"),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		entries, err := cache.List()
+		var pipeline string
+		if len(args) == 1 {
+			pipeline = args[0]
+		}
+		store, err := configuredStore(pipeline)
+		if err != nil {
+			return err
+		}
+		entries, err := store.List()
+		if errors.Is(err, errors.ErrUnsupported) {
+			return fmt.Errorf("%s cannot list its entries — inspect one with pipe cache show <pipeline> <step-id>", store)
+		}
 		if err != nil {
 			return err
 		}
+		if len(args) == 1 {
+			entries = slices.DeleteFunc(entries, func(e *cache.Entry) bool { return e.Pipeline != args[0] })
+		}
 		if len(entries) == 0 {
 			fmt.Println("no cached entries")
 			return nil
 		}
 
 		// Find max widths for alignment
+		maxPipeline := len("PIPELINE")
 		maxStep := len("STEP")
 		for _, e := range entries {
-			if len(e.StepID) > maxStep {
-				maxStep = len(e.StepID)
-			}
+			maxPipeline = max(maxPipeline, len(e.Pipeline))
+			maxStep = max(maxStep, len(e.StepID))
 		}
 
-		fmt.Printf("%-*s  %-20s  %-20s  %s\n", maxStep, "STEP", "CACHED AT", "EXPIRES AT", "TYPE")
+		fmt.Printf("%-*s  %-*s  %-20s  %-20s  %-7s  %-8s  %5s  %6s  %s\n", maxPipeline, "PIPELINE", maxStep, "STEP", "CACHED AT", "EXPIRES AT", "TYPE", "SIZE", "HITS", "MISSES", "KEY")
 		for _, e := range entries {
 			cachedAt := e.CachedAt.Local().Format("2006-01-02 15:04:05")
 			expiresAt := "never"
 			if e.ExpiresAt != nil {
 				expiresAt = e.ExpiresAt.Local().Format("2006-01-02 15:04:05")
 			}
-			fmt.Printf("%-*s  %-20s  %-20s  %s\n", maxStep, e.StepID, cachedAt, expiresAt, e.RunType)
+			fmt.Printf("%-*s  %-*s  %-20s  %-20s  %-7s  %-8s  %5d  %6d  %s\n", maxPipeline, e.Pipeline, maxStep, e.StepID, cachedAt, expiresAt, e.RunType, formatSize(e.Size()), e.Hits, e.Misses, keySummary(e))
 		}
 		return nil
 	},
 }
+
+// keySummary describes what an entry's cache key was computed from, e.g.
+// "3f2a9c1d0b7e command, vars: PIPE_VAR_ENV, upstream: build, files: go.sum".
+func keySummary(e *cache.Entry) string {
+	short := e.Key
+	if len(short) > 12 {
+		short = short[:12]
+	}
+	parts := []string{"command"}
+	for _, group := range []struct {
+		name string
+		m    map[string]string
+	}{{"vars", e.KeyParts.Vars}, {"upstream", e.KeyParts.Upstream}, {"files", e.KeyParts.Files}} {
+		if len(group.m) > 0 {
+			parts = append(parts, group.name+": "+strings.Join(slices.Sorted(maps.Keys(group.m)), " "))
+		}
+	}
+	return short + " " + strings.Join(parts, ", ")
+}
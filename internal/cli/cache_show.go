@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/getpipe-dev/pipe/internal/cache"
+	"github.com/spf13/cobra"
+)
+
+var cacheShowCmd = &cobra.Command{
+	Use:   "show <pipeline> <step-id>",
+	Short: "Show a cached step result and what its key was computed from",
+	Args:  exactArgs(2, "pipe cache show <pipeline> <step-id>"),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := configuredStore(args[0])
+		if err != nil {
+			return err
+		}
+		e, err := store.Load(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			return fmt.Errorf("no cache entry for step %q of pipeline %q", args[1], args[0])
+		}
+
+		fmt.Printf("Pipeline:    %s\n", e.Pipeline)
+		fmt.Printf("Step:        %s\n", e.StepID)
+		fmt.Printf("Key:         %s\n", e.Key)
+		fmt.Printf("Type:        %s\n", e.RunType)
+		fmt.Printf("Size:        %s\n", formatSize(e.Size()))
+		fmt.Printf("Cached At:   %s\n", e.CachedAt.Local().Format(time.DateTime))
+		switch {
+		case e.ExpiresAt == nil:
+			fmt.Println("Expires At:  never")
+		case !cache.IsValid(e, time.Now()):
+			fmt.Printf("Expires At:  %s (expired)\n", e.ExpiresAt.Local().Format(time.DateTime))
+		default:
+			fmt.Printf("Expires At:  %s\n", e.ExpiresAt.Local().Format(time.DateTime))
+		}
+		lastHit := ""
+		if e.LastHitAt != nil {
+			lastHit = fmt.Sprintf(" (last %s)", e.LastHitAt.Local().Format(time.DateTime))
+		}
+		fmt.Printf("Hits:        %d%s\n", e.Hits, lastHit)
+		fmt.Printf("Misses:      %d\n", e.Misses)
+
+		if len(e.Artifacts) > 0 {
+			fmt.Printf("Artifacts:   %d path(s), %s\n", len(e.Artifacts), formatSize(e.ArtifactsSize))
+			for _, p := range e.Artifacts {
+				fmt.Printf("  - %s\n", p)
+			}
+		}
+
+		fmt.Println("\nKey inputs:")
+		fmt.Printf("  command                %s\n", short(e.KeyParts.Command, 12))
+		for _, group := range []struct {
+			name string
+			m    map[string]string
+		}{{"var", e.KeyParts.Vars}, {"upstream", e.KeyParts.Upstream}, {"file", e.KeyParts.Files}} {
+			for _, k := range slices.Sorted(maps.Keys(group.m)) {
+				fmt.Printf("  %-22s %s\n", group.name+" "+k, short(group.m[k], 12))
+			}
+		}
+
+		if e.Sensitive {
+			fmt.Println("\nOutput:      (sensitive — not stored)")
+		} else {
+			printCachedOutput("", e.Output, e.Outputs)
+		}
+		for _, sub := range e.SubOutputs {
+			if sub.Sensitive {
+				fmt.Printf("\n[%s] output: (sensitive — not stored)\n", sub.ID)
+				continue
+			}
+			printCachedOutput("["+sub.ID+"] ", sub.Output, sub.Outputs)
+		}
+		return nil
+	},
+}
+
+// printCachedOutput prints a cached stdout and named outputs, indented.
+func printCachedOutput(prefix, output string, outputs map[string]string) {
+	if output != "" {
+		fmt.Printf("\n%sOutput:\n", prefix)
+		for line := range strings.Lines(strings.TrimSuffix(output, "\n")) {
+			fmt.Printf("  %s", line)
+		}
+		fmt.Println()
+	}
+	if len(outputs) > 0 {
+		fmt.Printf("\n%sOutputs:\n", prefix)
+		for _, k := range slices.Sorted(maps.Keys(outputs)) {
+			fmt.Printf("  %s=%s\n", k, outputs[k])
+		}
+	}
+}
@@ -159,6 +159,23 @@ func short(s string, n int) string {
 	return s[:n]
 }
 
+// formatSize formats a byte count with the units model.ParseSize accepts,
+// e.g. 512B, 1.5KB or 12.0MB.
+func formatSize(n int64) string {
+	const unit = 1 << 10
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	v := float64(n) / unit
+	for _, u := range []string{"KB", "MB"} {
+		if v < unit {
+			return fmt.Sprintf("%.1f%s", v, u)
+		}
+		v /= unit
+	}
+	return fmt.Sprintf("%.1fGB", v)
+}
+
 func exactArgs(n int, usage string) cobra.PositionalArgs {
 	return func(cmd *cobra.Command, args []string) error {
 		if len(args) != n {
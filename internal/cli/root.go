@@ -11,6 +11,8 @@ import (
 )
 
 var resumeFlag string
+var dryRunFlag bool
+var selection runner.Selection
 var apiURL string
 var verbosity int
 
@@ -51,6 +53,12 @@ func init() {
 
 	rootCmd.PersistentFlags().CountVarP(&verbosity, "verbose", "v", "increase output verbosity (-v verbose, -vv debug)")
 	rootCmd.Flags().StringVar(&resumeFlag, "resume", "", "resume a previous run by ID")
+	rootCmd.Flags().BoolVar(&dryRunFlag, "dry-run", false, "print the steps and commands a run would execute without running them")
+	rootCmd.Flags().StringSliceVar(&selection.Only, "only", nil, "run only these steps (comma-separated or repeated)")
+	rootCmd.Flags().BoolVar(&selection.WithDeps, "with-deps", false, "with --only, also run the steps they depend on")
+	rootCmd.Flags().StringVar(&selection.From, "from", "", "run this step and every step that depends on it")
+	rootCmd.Flags().StringVar(&selection.Until, "until", "", "run this step and every step it depends on")
+	rootCmd.Flags().StringSliceVar(&selection.Skip, "skip", nil, "leave these steps out (comma-separated or repeated)")
 	rootCmd.SetVersionTemplate("pipe-{{.Version}}\n")
 
 	cobra.EnableCommandSorting = false
@@ -111,6 +119,9 @@ func SetVersion(v string) {
 // Execute runs the root command.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
+		if errors.Is(err, runner.ErrPipelineCancelled) {
+			os.Exit(130)
+		}
 		if !errors.Is(err, runner.ErrPipelineFailed) {
 			log.Error(err)
 		}
@@ -9,6 +9,7 @@ import (
 	"github.com/getpipe-dev/pipe/internal/config"
 	"github.com/getpipe-dev/pipe/internal/hub"
 	"github.com/getpipe-dev/pipe/internal/logging"
+	"github.com/getpipe-dev/pipe/internal/model"
 	"github.com/getpipe-dev/pipe/internal/parser"
 	"github.com/getpipe-dev/pipe/internal/resolve"
 	"github.com/getpipe-dev/pipe/internal/runner"
@@ -103,7 +104,7 @@ func runPipeline(name string, overrides map[string]string) error {
 	log.Debug("parsed pipeline", "name", pipeline.Name, "steps", len(pipeline.Steps), "vars", len(pipeline.Vars))
 
 	// Interactive step requires a TTY
-	if runner.InteractiveStep(pipeline) != nil && !ui.IsTTY(os.Stdin) {
+	if !dryRunFlag && runner.InteractiveStep(pipeline) != nil && !ui.IsTTY(os.Stdin) {
 		return fmt.Errorf("pipeline %q has an interactive step — stdin must be a terminal (not a pipe or redirect)", pipeline.Name)
 	}
 
@@ -111,9 +112,21 @@ func runPipeline(name string, overrides map[string]string) error {
 		log.Warn(w)
 	}
 
+	if selection.WithDeps && len(selection.Only) == 0 {
+		return errors.New("--with-deps requires --only")
+	}
+	if err := selection.Validate(pipeline); err != nil {
+		return err
+	}
+
+	if dryRunFlag {
+		return dryRunPipeline(pipeline, overrides)
+	}
+
 	if err := config.EnsureDirs(pipeline.Name); err != nil {
 		return fmt.Errorf("%s", friendlyError(err))
 	}
+	migrateCache()
 
 	var rs *state.RunState
 	if resumeFlag != "" {
@@ -153,6 +166,12 @@ func runPipeline(name string, overrides map[string]string) error {
 		plog.Log("starting pipeline %q (run %s)", pipeline.Name, rs.RunID)
 	}
 
+	vars := resolvePipelineVars(pipeline, overrides)
+	r := runner.New(pipeline, rs, plog, vars, statusUI, verbosity)
+	if err := r.Select(selection); err != nil {
+		return err
+	}
+
 	if err := state.Save(rs); err != nil {
 		return fmt.Errorf("%s", friendlyError(err))
 	}
@@ -163,9 +182,20 @@ func runPipeline(name string, overrides map[string]string) error {
 		}
 	}
 
+	if resumeFlag != "" {
+		r.RestoreEnvFromState()
+	}
+
+	return r.Run()
+}
+
+// resolvePipelineVars merges a pipeline's vars with its dot_file, PIPE_VAR_*
+// env vars and CLI overrides, logging what could not be applied.
+func resolvePipelineVars(pipeline *model.Pipeline, overrides map[string]string) map[string]string {
 	var dotFileVars map[string]string
 	if pipeline.DotFile != "" {
 		var dotFileWarns []string
+		var err error
 		dotFileVars, dotFileWarns, err = runner.ParseDotFile(pipeline.DotFile)
 		switch {
 		case errors.Is(err, os.ErrNotExist):
@@ -186,10 +216,24 @@ func runPipeline(name string, overrides map[string]string) error {
 		log.Warn(w)
 	}
 	log.Debug("resolved variables", "total", len(vars), "overrides", len(overrides))
-	r := runner.New(pipeline, rs, plog, vars, statusUI, verbosity)
+	return vars
+}
+
+// dryRunPipeline prints what running a pipeline would do without running
+// anything or writing state, logs or cache files. With --resume it predicts
+// which steps the resumed run skips.
+func dryRunPipeline(pipeline *model.Pipeline, overrides map[string]string) error {
+	rs := state.NewRunState(pipeline.Name)
 	if resumeFlag != "" {
-		r.RestoreEnvFromState()
+		var err error
+		rs, err = state.Load(pipeline.Name, resumeFlag)
+		if err != nil {
+			return err
+		}
 	}
-
-	return r.Run()
+	r := runner.New(pipeline, rs, logging.Discard(), resolvePipelineVars(pipeline, overrides), nil, verbosity)
+	if err := r.Select(selection); err != nil {
+		return err
+	}
+	return r.DryRun(os.Stdout)
 }
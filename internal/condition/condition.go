@@ -0,0 +1,310 @@
+// Package condition parses and evaluates the small expression language used
+// by a step's if: field, e.g.
+//
+//	PIPE_VAR_ENV == "prod" && steps.migrate.status == "done"
+//
+// Identifiers are PIPE_* variables (optionally written as $PIPE_X or
+// ${PIPE_X}), steps.<id>.status|exit_code|output, env.<NAME>, os and arch.
+// Every value is a string; comparisons with <, <=, > and >= are numeric.
+package condition
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Expr is a parsed condition.
+type Expr struct {
+	src  string
+	root node
+}
+
+// Parse compiles a condition, rejecting syntax errors and unknown identifiers.
+func Parse(src string) (*Expr, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, fmt.Errorf("if %q: %w", src, err)
+	}
+	p := &parser{toks: toks}
+	root, err := p.parseOr()
+	if err == nil && p.peek().kind != tokEOF {
+		err = fmt.Errorf("unexpected %q", p.peek().text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("if %q: %w", src, err)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// String returns the source text of the expression.
+func (e *Expr) String() string { return e.src }
+
+// Eval evaluates the condition. data maps identifiers (without any leading
+// $) to their values; missing identifiers evaluate to "".
+func (e *Expr) Eval(data map[string]string) (bool, error) {
+	v, err := e.root.eval(data)
+	if err != nil {
+		return false, fmt.Errorf("if %q: %w", e.src, err)
+	}
+	return truthy(v), nil
+}
+
+// Idents returns every identifier referenced by the expression, in order of
+// first appearance.
+func (e *Expr) Idents() []string {
+	var out []string
+	seen := make(map[string]bool)
+	walk(e.root, func(n node) {
+		if id, ok := n.(identNode); ok && !seen[string(id)] {
+			seen[string(id)] = true
+			out = append(out, string(id))
+		}
+	})
+	return out
+}
+
+// StepRef splits a steps.<id>.<field> identifier. ok is false for any other
+// identifier.
+func StepRef(ident string) (id, field string, ok bool) {
+	rest, found := strings.CutPrefix(ident, "steps.")
+	if !found {
+		return "", "", false
+	}
+	i := strings.LastIndexByte(rest, '.')
+	if i <= 0 {
+		return "", "", false
+	}
+	return rest[:i], rest[i+1:], true
+}
+
+// truthy maps a value to a boolean: "", "false" and "0" are false.
+func truthy(v string) bool {
+	return v != "" && v != "false" && v != "0"
+}
+
+func boolString(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+// stepFields are the fields available on steps.<id>.
+var stepFields = map[string]bool{"status": true, "exit_code": true, "output": true}
+
+var (
+	pipeIdent = regexp.MustCompile(`^PIPE_[A-Z0-9_]+$`)
+	envIdent  = regexp.MustCompile(`^env\.[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// checkIdent rejects identifiers the runner cannot provide.
+func checkIdent(name string) error {
+	switch {
+	case name == "os" || name == "arch":
+		return nil
+	case pipeIdent.MatchString(name), envIdent.MatchString(name):
+		return nil
+	}
+	if _, field, ok := StepRef(name); ok {
+		if !stepFields[field] {
+			return fmt.Errorf("unknown step field %q in %q — use status, exit_code or output", field, name)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown identifier %q — use PIPE_* variables, steps.<id>.<field>, env.<NAME>, os or arch", name)
+}
+
+// --- AST ---
+
+type node interface {
+	eval(data map[string]string) (string, error)
+}
+
+type (
+	literalNode string
+	identNode   string
+	notNode     struct{ x node }
+	binaryNode  struct {
+		op   string
+		l, r node
+	}
+)
+
+func (n literalNode) eval(map[string]string) (string, error) { return string(n), nil }
+
+func (n identNode) eval(data map[string]string) (string, error) { return data[string(n)], nil }
+
+func (n notNode) eval(data map[string]string) (string, error) {
+	v, err := n.x.eval(data)
+	if err != nil {
+		return "", err
+	}
+	return boolString(!truthy(v)), nil
+}
+
+func (n binaryNode) eval(data map[string]string) (string, error) {
+	l, err := n.l.eval(data)
+	if err != nil {
+		return "", err
+	}
+	// Short-circuit logical operators.
+	switch n.op {
+	case "&&":
+		if !truthy(l) {
+			return "false", nil
+		}
+	case "||":
+		if truthy(l) {
+			return "true", nil
+		}
+	}
+	r, err := n.r.eval(data)
+	if err != nil {
+		return "", err
+	}
+	switch n.op {
+	case "&&", "||":
+		return boolString(truthy(r)), nil
+	case "==":
+		return boolString(l == r), nil
+	case "!=":
+		return boolString(l != r), nil
+	}
+	lf, lerr := strconv.ParseFloat(strings.TrimSpace(l), 64)
+	rf, rerr := strconv.ParseFloat(strings.TrimSpace(r), 64)
+	if lerr != nil || rerr != nil {
+		return "", fmt.Errorf("%s needs numbers, got %q and %q", n.op, l, r)
+	}
+	switch n.op {
+	case "<":
+		return boolString(lf < rf), nil
+	case "<=":
+		return boolString(lf <= rf), nil
+	case ">":
+		return boolString(lf > rf), nil
+	default: // ">="
+		return boolString(lf >= rf), nil
+	}
+}
+
+func walk(n node, fn func(node)) {
+	fn(n)
+	switch n := n.(type) {
+	case notNode:
+		walk(n.x, fn)
+	case binaryNode:
+		walk(n.l, fn)
+		walk(n.r, fn)
+	}
+}
+
+// --- parser ---
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) parseOr() (node, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "||" {
+		p.next()
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryNode{op: "||", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "&&" {
+		p.next()
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryNode{op: "&&", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.peek().kind == tokOp && p.peek().text == "!" {
+		p.next()
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{x: x}, nil
+	}
+	return p.parseCompare()
+}
+
+var compareOps = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+func (p *parser) parseCompare() (node, error) {
+	l, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tokOp && compareOps[t.text] {
+		p.next()
+		r, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return binaryNode{op: t.text, l: l, r: r}, nil
+	}
+	return l, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString, tokNumber:
+		return literalNode(t.text), nil
+	case tokIdent:
+		switch t.text {
+		case "true", "false":
+			return literalNode(t.text), nil
+		}
+		if err := checkIdent(t.text); err != nil {
+			return nil, err
+		}
+		return identNode(t.text), nil
+	case tokLParen:
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokRParen {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return x, nil
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	default:
+		return nil, fmt.Errorf("unexpected %q", t.text)
+	}
+}
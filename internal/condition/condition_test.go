@@ -0,0 +1,100 @@
+package condition
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	data := map[string]string{
+		"PIPE_VAR_ENV":           "prod",
+		"PIPE_BUILD":             "v1.2.3",
+		"steps.build.status":     "done",
+		"steps.build.exit_code":  "0",
+		"steps.lint.status":      "failed",
+		"steps.lint.exit_code":   "2",
+		"env.CI":                 "true",
+		"os":                     "linux",
+		"PIPE_VAR_REPLICA_COUNT": "3",
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`PIPE_VAR_ENV == "prod"`, true},
+		{`$PIPE_VAR_ENV == 'prod'`, true},
+		{`${PIPE_VAR_ENV} != "prod"`, false},
+		{`PIPE_VAR_ENV == "prod" && steps.build.status == "done"`, true},
+		{`PIPE_VAR_ENV == "staging" || steps.lint.exit_code == 2`, true},
+		{`!(steps.lint.status == "done")`, true},
+		{`PIPE_VAR_REPLICA_COUNT >= 3`, true},
+		{`PIPE_VAR_REPLICA_COUNT < 3`, false},
+		{`env.CI`, true},
+		{`env.MISSING`, false},
+		{`PIPE_BUILD`, true},
+		{`os == "darwin"`, false},
+		{`true && !false`, true},
+	}
+	for _, tt := range tests {
+		e, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.expr, err)
+		}
+		got, err := e.Eval(data)
+		if err != nil {
+			t.Fatalf("Eval(%q): %v", tt.expr, err)
+		}
+		if got != tt.want {
+			t.Errorf("Eval(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{``, "unexpected end"},
+		{`PIPE_VAR_ENV = "prod"`, "use == to compare"},
+		{`(PIPE_VAR_ENV == "prod"`, "missing closing parenthesis"},
+		{`PIPE_VAR_ENV == "prod`, "unterminated string"},
+		{`HOME == "/root"`, "unknown identifier"},
+		{`steps.build.stdout == ""`, "unknown step field"},
+		{`PIPE_VAR_ENV == "prod" "x"`, "unexpected"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.expr)
+		if err == nil {
+			t.Fatalf("Parse(%q): expected error", tt.expr)
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %q, want it to contain %q", tt.expr, err.Error(), tt.want)
+		}
+	}
+}
+
+func TestEval_NumericCompareError(t *testing.T) {
+	e, err := Parse(`PIPE_VAR_ENV > 1`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if _, err := e.Eval(map[string]string{"PIPE_VAR_ENV": "prod"}); err == nil {
+		t.Fatal("expected error comparing non-numeric value")
+	}
+}
+
+func TestIdents(t *testing.T) {
+	e, err := Parse(`$PIPE_VAR_ENV == "prod" && steps.build-api.status == "done" || PIPE_VAR_ENV == "x"`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	got := e.Idents()
+	if len(got) != 2 || got[0] != "PIPE_VAR_ENV" || got[1] != "steps.build-api.status" {
+		t.Fatalf("unexpected idents: %v", got)
+	}
+	id, field, ok := StepRef(got[1])
+	if !ok || id != "build-api" || field != "status" {
+		t.Fatalf("StepRef(%q) = %q, %q, %v", got[1], id, field, ok)
+	}
+}
@@ -0,0 +1,145 @@
+package condition
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// twoCharOps are checked before single-character operators.
+var twoCharOps = []string{"==", "!=", "<=", ">=", "&&", "||"}
+
+func lex(src string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '(':
+			toks = append(toks, token{tokLParen, "("})
+			i++
+		case c == ')':
+			toks = append(toks, token{tokRParen, ")"})
+			i++
+
+		case c == '"' || c == '\'':
+			s, n, err := lexString(src[i:])
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, token{tokString, s})
+			i += n
+
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			j := i + 1
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{tokNumber, src[i:j]})
+			i = j
+
+		case c == '$':
+			// $PIPE_X or ${PIPE_X}: same as the bare identifier.
+			j := i + 1
+			braced := j < len(src) && src[j] == '{'
+			if braced {
+				j++
+			}
+			k := j
+			for k < len(src) && isIdentChar(src[k]) {
+				k++
+			}
+			if k == j {
+				return nil, fmt.Errorf("expected variable name after $")
+			}
+			name := src[j:k]
+			if braced {
+				if k >= len(src) || src[k] != '}' {
+					return nil, fmt.Errorf("missing } after ${%s", name)
+				}
+				k++
+			}
+			toks = append(toks, token{tokIdent, name})
+			i = k
+
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(src) && isIdentChar(src[j]) {
+				j++
+			}
+			toks = append(toks, token{tokIdent, src[i:j]})
+			i = j
+
+		default:
+			op := ""
+			for _, o := range twoCharOps {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" && (c == '!' || c == '<' || c == '>') {
+				op = string(c)
+			}
+			if op == "" {
+				if c == '=' {
+					return nil, fmt.Errorf("unexpected \"=\" — use == to compare")
+				}
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+			toks = append(toks, token{tokOp, op})
+			i += len(op)
+		}
+	}
+	return append(toks, token{tokEOF, ""}), nil
+}
+
+// lexString reads a quoted string starting at s[0]. Backslash escapes the
+// next character. Returns the unquoted value and the number of bytes consumed.
+func lexString(s string) (string, int, error) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case quote:
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// isIdentChar allows dots and hyphens so steps.<id>.<field> and hyphenated
+// step IDs lex as one identifier.
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9' || c == '.' || c == '-'
+}
@@ -13,6 +13,8 @@ var (
 	StateDir        string
 	LogDir          string
 	CacheDir        string
+	OutputDir       string
+	ArtifactDir     string
 	CredentialsPath string
 	AliasesPath     string
 )
@@ -28,6 +30,8 @@ func init() {
 	StateDir = filepath.Join(BaseDir, "state")
 	LogDir = filepath.Join(BaseDir, "logs")
 	CacheDir = filepath.Join(BaseDir, "cache")
+	OutputDir = filepath.Join(BaseDir, "outputs")
+	ArtifactDir = filepath.Join(BaseDir, "artifacts")
 	CredentialsPath = filepath.Join(BaseDir, "credentials.json")
 	AliasesPath = filepath.Join(BaseDir, "aliases.json")
 }
@@ -2,9 +2,12 @@ package graph
 
 import (
 	"fmt"
+	"maps"
 	"regexp"
+	"slices"
 	"strings"
 
+	"github.com/getpipe-dev/pipe/internal/condition"
 	"github.com/getpipe-dev/pipe/internal/model"
 )
 
@@ -20,6 +23,10 @@ type Graph struct {
 // pipeVarPattern matches $PIPE_<NAME> and ${PIPE_<NAME>} references in shell commands.
 var pipeVarPattern = regexp.MustCompile(`\$\{?PIPE_([A-Z0-9_]+)\}?`)
 
+// runnerVars are the PIPE_* vars set by the runner or the user rather than
+// by a step, which never count as a step's output.
+var runnerVars = []string{"PIPE_RUN_STATUS", "PIPE_FAILED_STEPS", "PIPE_MAX_PARALLEL"}
+
 // envKey mirrors runner.EnvKey: joins parts with _, replaces hyphens, uppercases.
 func envKey(parts ...string) string {
 	joined := strings.Join(parts, "_")
@@ -39,21 +46,66 @@ func Build(steps []model.Step) (*Graph, error) {
 
 	// Build lookup maps
 	stepByID := make(map[string]model.Step)
-	envToStep := make(map[string]string) // PIPE_<KEY> → step ID that produces it
+	envToStep := make(map[string]string)   // PIPE_<KEY> → step ID that produces it
+	fieldPrefix := make(map[string]string) // PIPE_<KEY>__ → step whose JSON output fields start with it
+	stepPrefix := make(map[string]string)  // PIPE_<STEP>_ → step, whose named outputs may only be known at run time
 	for _, s := range steps {
 		g.Order = append(g.Order, s.ID)
 		g.InDegree[s.ID] = 0
 		stepByID[s.ID] = s
 
-		// Map env keys to producing step
-		key := envKey(s.ID)
-		envToStep[key] = s.ID
-		if s.Run.IsSubRuns() {
-			for _, sr := range s.Run.SubRuns {
-				subKey := envKey(s.ID, sr.ID)
-				envToStep[subKey] = s.ID
+		// Map the env keys the runner exports to the producing step: the
+		// stdout, its file and JSON fields and the named outputs of the step
+		// and of each sub-run, and the step's artifacts directory.
+		ids := [][]string{{s.ID}}
+		for _, sr := range s.Run.SubRuns {
+			ids = append(ids, []string{s.ID, sr.ID})
+		}
+		for _, id := range ids {
+			key := envKey(id...)
+			envToStep[key] = s.ID
+			envToStep[key+"_FILE"] = s.ID
+			fieldPrefix[key+"__"] = s.ID
+			for _, name := range s.Outputs {
+				envToStep[envKey(slices.Concat(id, []string{name})...)] = s.ID
+			}
+		}
+		envToStep[envKey(s.ID)+"_ARTIFACTS"] = s.ID
+		stepPrefix[envKey(s.ID)+"_"] = s.ID
+	}
+	producerOf := func(ref string) (string, bool) {
+		if id, ok := envToStep[ref]; ok {
+			return id, true
+		}
+		// Longest prefix wins, so PIPE_A_B__X belongs to step a-b, not a.
+		best := ""
+		for prefix := range fieldPrefix {
+			if strings.HasPrefix(ref, prefix) && len(prefix) > len(best) {
+				best = prefix
+			}
+		}
+		id, ok := fieldPrefix[best]
+		return id, ok
+	}
+	// namedOutput returns the step a reference looks like a named output
+	// of, and whether that step may export it: any name unless the step
+	// lists its outputs, in which case it only exports those.
+	namedOutput := func(ref string) (id, name string, ok bool) {
+		if strings.HasPrefix(ref, "PIPE_VAR_") || slices.Contains(runnerVars, ref) {
+			return "", "", false
+		}
+		// Longest prefix wins, so PIPE_A_B_X belongs to step a-b, not a.
+		best := ""
+		for prefix := range stepPrefix {
+			if strings.HasPrefix(ref, prefix) && len(prefix) > len(best) {
+				best = prefix
 			}
 		}
+		if best == "" {
+			return "", "", false
+		}
+		id = stepPrefix[best]
+		return id, strings.ToLower(strings.TrimPrefix(ref, best)), len(stepByID[id].Outputs) == 0
 	}
 
 	// Track edges to avoid duplicates
@@ -83,11 +135,35 @@ func Build(steps []model.Step) (*Graph, error) {
 		}
 
 		// Implicit edges from $PIPE_* variable references
-		for _, ref := range findPipeRefs(s) {
-			if producer, ok := envToStep[ref]; ok && producer != s.ID {
+		for _, ref := range PipeRefs(s) {
+			producer, ok := producerOf(ref)
+			if !ok {
+				id, name, exported := namedOutput(ref)
+				switch {
+				case id == "" || id == s.ID:
+					continue
+				case !exported:
+					g.Warnings = append(g.Warnings, fmt.Sprintf("step %q: $%s is not among the outputs of step %q — add %s to its outputs", s.ID, ref, id, name))
+					continue
+				}
+				producer = id
+			}
+			if producer != s.ID {
 				addEdge(producer, s.ID)
 			}
 		}
+
+		// Implicit edges from steps.<id>.* references in the if: condition
+		for _, ref := range findConditionStepRefs(s) {
+			if ref == s.ID {
+				return nil, fmt.Errorf("step %q: condition references its own status", s.ID)
+			}
+			if _, ok := stepByID[ref]; !ok {
+				g.Warnings = append(g.Warnings, fmt.Sprintf("step %q: condition references unknown step %q", s.ID, ref))
+				continue
+			}
+			addEdge(ref, s.ID)
+		}
 	}
 
 	// Cycle detection using Kahn's algorithm
@@ -98,11 +174,20 @@ func Build(steps []model.Step) (*Graph, error) {
 	return g, nil
 }
 
-// findPipeRefs extracts all PIPE_* variable names referenced in a step's run commands.
-func findPipeRefs(s model.Step) []string {
+// PipeRefs returns all PIPE_* variable names referenced in a step's run
+// commands, foreach source, dir, env and with: values and if: condition. A
+// {{ .steps.<id>.<field> }} accessor counts as a reference to PIPE_<ID>__<FIELD>.
+func PipeRefs(s model.Step) []string {
 	var refs []string
 	seen := make(map[string]bool)
 
+	// $PIPE_OUTPUT is the step's own output file, not a step output.
+	seen["PIPE_OUTPUT"] = true
+	// $PIPE_ITEM is the current item of a foreach step, not a step output.
+	if s.Foreach != "" {
+		seen["PIPE_ITEM"] = true
+	}
+
 	collect := func(cmd string) {
 		matches := pipeVarPattern.FindAllStringSubmatch(cmd, -1)
 		for _, m := range matches {
@@ -114,6 +199,14 @@ func findPipeRefs(s model.Step) []string {
 				refs = append(refs, varName)
 			}
 		}
+		// {{ .steps.<id>.<field> }} reads PIPE_<ID>__<FIELD>.
+		for _, ref := range model.FieldRefs(cmd) {
+			varName := envKey(ref.Step) + "__" + strings.ToUpper(strings.ReplaceAll(strings.Join(ref.Path, "__"), "-", "_"))
+			if !seen[varName] {
+				seen[varName] = true
+				refs = append(refs, varName)
+			}
+		}
 	}
 
 	if s.Run.IsSingle() {
@@ -124,11 +217,52 @@ func findPipeRefs(s model.Step) []string {
 	}
 	for _, sr := range s.Run.SubRuns {
 		collect(sr.Run)
+		collect(sr.Dir)
+		for _, k := range slices.Sorted(maps.Keys(sr.Env)) {
+			collect(sr.Env[k])
+		}
+	}
+	collect(s.Foreach)
+	collect(s.Dir)
+	for _, k := range slices.Sorted(maps.Keys(s.Env)) {
+		collect(s.Env[k])
+	}
+	for _, k := range slices.Sorted(maps.Keys(s.With)) {
+		collect(s.With[k])
+	}
+	if s.If != "" {
+		if expr, err := condition.Parse(s.If); err == nil {
+			for _, id := range expr.Idents() {
+				if strings.HasPrefix(id, "PIPE_") && !seen[id] {
+					seen[id] = true
+					refs = append(refs, id)
+				}
+			}
+		}
 	}
 
 	return refs
 }
 
+// findConditionStepRefs returns the step IDs referenced as steps.<id>.<field>
+// in a step's if: condition. Invalid conditions are reported by the parser.
+func findConditionStepRefs(s model.Step) []string {
+	if s.If == "" {
+		return nil
+	}
+	expr, err := condition.Parse(s.If)
+	if err != nil {
+		return nil
+	}
+	var ids []string
+	for _, ident := range expr.Idents() {
+		if id, _, ok := condition.StepRef(ident); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
 // detectCycle uses Kahn's algorithm to detect cycles.
 func detectCycle(g *Graph) error {
 	inDeg := make(map[string]int)
@@ -1,6 +1,7 @@
 package graph
 
 import (
+	"slices"
 	"strings"
 	"testing"
 
@@ -274,3 +275,174 @@ func TestBuild_VarRefIgnoresSelf(t *testing.T) {
 		t.Fatalf("expected build in-degree 0 (self ref ignored), got %d", g.InDegree["build"])
 	}
 }
+
+func TestBuild_ConditionEdges(t *testing.T) {
+	ss := steps(
+		stepDef{id: "version", run: single("git describe")},
+		stepDef{id: "lint", run: single("golangci-lint run")},
+		stepDef{id: "release", run: single("goreleaser")},
+	)
+	ss[2].If = `PIPE_VERSION != "" && steps.lint.status == "done"`
+	g, err := Build(ss)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if g.InDegree["release"] != 2 {
+		t.Fatalf("expected release in-degree 2, got %d", g.InDegree["release"])
+	}
+}
+
+func TestBuild_ConditionUnknownStep(t *testing.T) {
+	ss := steps(stepDef{id: "a", run: single("echo a")})
+	ss[0].If = `steps.ghost.status == "done"`
+	g, err := Build(ss)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(g.Warnings) != 1 || !strings.Contains(g.Warnings[0], "unknown step \"ghost\"") {
+		t.Fatalf("expected unknown step warning, got %v", g.Warnings)
+	}
+}
+
+func TestBuild_EnvAndDirEdges(t *testing.T) {
+	ss := steps(
+		stepDef{id: "version", run: single("git describe")},
+		stepDef{id: "workspace", run: single("mktemp -d")},
+		stepDef{id: "build", run: single("make")},
+	)
+	ss[2].Env = map[string]string{"VERSION": "$PIPE_VERSION"}
+	ss[2].Dir = "${PIPE_WORKSPACE}/api"
+	g, err := Build(ss)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if g.InDegree["build"] != 2 {
+		t.Fatalf("expected build in-degree 2, got %d", g.InDegree["build"])
+	}
+}
+
+func TestBuild_ForeachEdge(t *testing.T) {
+	ss := steps(
+		stepDef{id: "list", run: single("git diff --name-only")},
+		stepDef{id: "item", run: single("echo unrelated")},
+		stepDef{id: "deploy", run: single("./deploy.sh $PIPE_ITEM")},
+	)
+	ss[2].Foreach = "$PIPE_LIST"
+	g, err := Build(ss)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(g.Deps["deploy"]) != 1 || g.Deps["deploy"][0] != "list" {
+		t.Fatalf("expected deploy to depend only on list, got %v", g.Deps["deploy"])
+	}
+}
+
+func TestBuild_UsesOutputEdges(t *testing.T) {
+	ss := []model.Step{
+		{ID: "version", Run: model.RunField{Single: "git describe"}},
+		{ID: "image", Uses: "acme/docker-build:v1", With: map[string]string{"tag": "$PIPE_VERSION"}},
+		{ID: "push", Run: model.RunField{Single: "docker push $PIPE_IMAGE_REF"}},
+	}
+	g, err := Build(ss)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(g.Deps["image"]) != 1 || g.Deps["image"][0] != "version" {
+		t.Fatalf("expected with: value to add an edge, got %v", g.Deps["image"])
+	}
+	if len(g.Deps["push"]) != 1 || g.Deps["push"][0] != "image" {
+		t.Fatalf("expected output reference to depend on the uses step, got %v", g.Deps["push"])
+	}
+}
+
+func TestBuild_NamedOutputEdges(t *testing.T) {
+	ss := []model.Step{
+		{ID: "describe", Run: model.RunField{Single: `echo "tag=v1" >> $PIPE_OUTPUT`}},
+		{ID: "describe-all", Run: model.RunField{Single: "true"}},
+		{ID: "var", Run: model.RunField{Single: "true"}},
+		{ID: "push", Run: model.RunField{Single: "docker push app:$PIPE_DESCRIBE_TAG $PIPE_DESCRIBE_ALL_LOG $PIPE_VAR_ENV"}},
+	}
+	g, err := Build(ss)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deps := slices.Sorted(slices.Values(g.Deps["push"]))
+	if !slices.Equal(deps, []string{"describe", "describe-all"}) {
+		t.Fatalf("expected edges from named outputs only, got %v", deps)
+	}
+	if len(g.Deps["describe"]) != 0 {
+		t.Fatalf("expected $PIPE_OUTPUT not to add edges, got %v", g.Deps["describe"])
+	}
+}
+
+func TestBuild_JSONFieldEdges(t *testing.T) {
+	ss := []model.Step{
+		{ID: "describe", Run: model.RunField{Single: "kubectl get deploy api -o json"}, OutputFormat: model.OutputJSON},
+		{ID: "list-pods", Run: model.RunField{Single: "kubectl get pods -o json"}, OutputFormat: model.OutputJSON},
+		{ID: "push", Run: model.RunField{Single: "echo $PIPE_DESCRIBE__IMAGE__TAG {{ .steps.list-pods.items.0.name }}"}},
+	}
+	g, err := Build(ss)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deps := slices.Sorted(slices.Values(g.Deps["push"]))
+	if !slices.Equal(deps, []string{"describe", "list-pods"}) {
+		t.Fatalf("expected edges from JSON field references, got %v", deps)
+	}
+}
+
+func TestBuild_OnlyExportedKeysAddEdges(t *testing.T) {
+	ss := []model.Step{
+		{ID: "run", Run: model.RunField{Single: "true"}},
+		{ID: "max", Run: model.RunField{Single: "true"}},
+		{ID: "build", Run: model.RunField{Single: `echo "tag=v1" >> $PIPE_OUTPUT`}, Outputs: []string{"tag"}},
+		{ID: "notify", Run: model.RunField{Single: "echo $PIPE_RUN_STATUS $PIPE_MAX_PARALLEL $PIPE_BUILD_VERSION $PIPE_BUILD_TAG_FILE"}},
+	}
+	g, err := Build(ss)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(g.Deps["notify"]) != 0 {
+		t.Fatalf("expected no edges from keys the steps do not export, got %v", g.Deps["notify"])
+	}
+}
+
+func TestBuild_ExportedKeyEdges(t *testing.T) {
+	ss := []model.Step{
+		{ID: "run", Run: model.RunField{Single: "true"}},
+		{ID: "max", Artifacts: []string{"dist/*"}, Run: model.RunField{Single: "true"}},
+		{ID: "build", Run: model.RunField{SubRuns: []model.SubRun{{ID: "linux", Run: "true"}}}, Outputs: []string{"tag"}},
+		{ID: "a", Run: model.RunField{Single: "echo $PIPE_RUN_FILE"}},
+		{ID: "b", Run: model.RunField{Single: "ls $PIPE_MAX_ARTIFACTS"}},
+		{ID: "c", Run: model.RunField{Single: "echo $PIPE_BUILD_LINUX_TAG"}},
+		{ID: "d", Run: model.RunField{Single: "echo $PIPE_BUILD_LINUX__IMAGE"}},
+	}
+	g, err := Build(ss)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for id, want := range map[string]string{"a": "run", "b": "max", "c": "build", "d": "build"} {
+		if deps := g.Deps[id]; len(deps) != 1 || deps[0] != want {
+			t.Errorf("expected %s to depend on %s, got %v", id, want, deps)
+		}
+	}
+}
+
+func TestBuild_UndeclaredOutputWarning(t *testing.T) {
+	ss := []model.Step{
+		{ID: "run", Run: model.RunField{Single: "true"}},
+		{ID: "build", Run: model.RunField{Single: `echo "tag=v1" >> $PIPE_OUTPUT`}, Outputs: []string{"tag"}},
+		{ID: "notify", Run: model.RunField{Single: "echo $PIPE_RUN_STATUS $PIPE_BUILD_TAG $PIPE_BUILD_VERSION"}},
+	}
+	g, err := Build(ss)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `step "notify": $PIPE_BUILD_VERSION is not among the outputs of step "build" — add version to its outputs`
+	if !slices.Equal(g.Warnings, []string{want}) {
+		t.Fatalf("expected a warning about the undeclared output only, got %q", g.Warnings)
+	}
+	if deps := g.Deps["notify"]; !slices.Equal(deps, []string{"build"}) {
+		t.Fatalf("expected notify to depend on build through its declared output only, got %v", deps)
+	}
+}
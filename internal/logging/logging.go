@@ -74,6 +74,12 @@ func New(pipelineName, runID string, opts ...Option) (*Logger, error) {
 	return l, nil
 }
 
+// Discard returns a Logger that writes nowhere, for runs that must not
+// create a log file, such as a dry run.
+func Discard() *Logger {
+	return &Logger{w: io.Discard}
+}
+
 func (l *Logger) Log(format string, args ...any) {
 	now := time.Now()
 	msg := fmt.Sprintf(format, args...)
@@ -92,6 +98,9 @@ func (l *Logger) Step(id string, sensitive bool) *StepLogger {
 }
 
 func (l *Logger) Close() error {
+	if l.file == nil {
+		return nil
+	}
 	return l.file.Close()
 }
 
@@ -8,10 +8,14 @@ import (
 
 // CacheField supports two YAML forms:
 //   - bool:    cache: true → {Enabled: true, ExpireAfter: ""}
-//   - mapping: cache: {expireAfter: "1h"} → {Enabled: true, ExpireAfter: "1h"}
+//   - mapping: cache: {expireAfter: "1h", key_files: [go.sum]} → {Enabled: true, ExpireAfter: "1h", KeyFiles: [go.sum]}
+//
+// KeyFiles are paths, relative to the step's dir, whose contents are part of
+// the step's cache key.
 type CacheField struct {
 	Enabled     bool
 	ExpireAfter string
+	KeyFiles    []string
 }
 
 func (c *CacheField) UnmarshalYAML(value *yaml.Node) error {
@@ -26,16 +30,18 @@ func (c *CacheField) UnmarshalYAML(value *yaml.Node) error {
 
 	case yaml.MappingNode:
 		var m struct {
-			ExpireAfter string `yaml:"expireAfter"`
+			ExpireAfter string   `yaml:"expireAfter"`
+			KeyFiles    []string `yaml:"key_files"`
 		}
 		if err := value.Decode(&m); err != nil {
 			return fmt.Errorf("cache: decoding mapping: %w", err)
 		}
 		c.Enabled = true
 		c.ExpireAfter = m.ExpireAfter
+		c.KeyFiles = m.KeyFiles
 		return nil
 
 	default:
-		return fmt.Errorf("cache: must be a bool or a mapping with expireAfter")
+		return fmt.Errorf("cache: must be a bool or a mapping with expireAfter and key_files")
 	}
 }
@@ -57,6 +57,20 @@ func TestCacheField_MappingAbsoluteTime(t *testing.T) {
 	}
 }
 
+func TestCacheField_MappingWithKeyFiles(t *testing.T) {
+	input := "key_files: [go.sum, go.mod]"
+	var c CacheField
+	if err := yaml.Unmarshal([]byte(input), &c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !c.Enabled {
+		t.Fatal("expected Enabled == true")
+	}
+	if len(c.KeyFiles) != 2 || c.KeyFiles[0] != "go.sum" || c.KeyFiles[1] != "go.mod" {
+		t.Fatalf("expected KeyFiles [go.sum go.mod], got %v", c.KeyFiles)
+	}
+}
+
 func TestCacheField_InvalidScalar(t *testing.T) {
 	var c CacheField
 	err := yaml.Unmarshal([]byte(`"notabool"`), &c)
@@ -0,0 +1,220 @@
+package model
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MatrixField is a static build matrix. Every key other than include and
+// exclude is an axis with a list of values; axes keep their YAML order.
+//
+//	matrix:
+//	  goos: [linux, darwin]
+//	  goarch: [amd64, arm64]
+//	  exclude:
+//	    - {goos: darwin, goarch: amd64}
+//	  include:
+//	    - {goos: windows, goarch: amd64}
+type MatrixField struct {
+	Axes    []MatrixAxis
+	Include []map[string]string // extra combinations
+	Exclude []map[string]string // partial combinations to drop
+}
+
+type MatrixAxis struct {
+	Name   string
+	Values []string
+}
+
+func (m *MatrixField) IsSet() bool { return len(m.Axes) > 0 || len(m.Include) > 0 }
+
+func (m *MatrixField) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.MappingNode {
+		return fmt.Errorf("matrix: must be a mapping of axis names to value lists")
+	}
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		key, val := value.Content[i].Value, value.Content[i+1]
+		switch key {
+		case "include", "exclude":
+			var combos []map[string]string
+			if err := val.Decode(&combos); err != nil {
+				return fmt.Errorf("matrix: %s must be a list of mappings: %w", key, err)
+			}
+			if key == "include" {
+				m.Include = combos
+			} else {
+				m.Exclude = combos
+			}
+		default:
+			var values []string
+			if err := val.Decode(&values); err != nil {
+				return fmt.Errorf("matrix: axis %q must be a list of values: %w", key, err)
+			}
+			m.Axes = append(m.Axes, MatrixAxis{Name: key, Values: values})
+		}
+	}
+	return nil
+}
+
+// MatrixCombo is one expanded combination: its sub-run ID and axis values.
+type MatrixCombo struct {
+	ID     string
+	Values map[string]string
+}
+
+// Combinations returns the cartesian product of the axes in YAML order,
+// minus every combination matching an exclude entry, plus each include entry
+// not already present.
+func (m *MatrixField) Combinations() []MatrixCombo {
+	var combos []map[string]string
+	if len(m.Axes) > 0 {
+		combos = []map[string]string{{}}
+		for _, axis := range m.Axes {
+			var next []map[string]string
+			for _, c := range combos {
+				for _, v := range axis.Values {
+					n := maps.Clone(c)
+					n[axis.Name] = v
+					next = append(next, n)
+				}
+			}
+			combos = next
+		}
+	}
+
+	combos = slices.DeleteFunc(combos, func(c map[string]string) bool {
+		return slices.ContainsFunc(m.Exclude, func(ex map[string]string) bool {
+			return matches(c, ex)
+		})
+	})
+	for _, inc := range m.Include {
+		if !slices.ContainsFunc(combos, func(c map[string]string) bool { return maps.Equal(c, inc) }) {
+			combos = append(combos, inc)
+		}
+	}
+
+	out := make([]MatrixCombo, 0, len(combos))
+	for _, c := range combos {
+		out = append(out, MatrixCombo{ID: m.comboID(c), Values: c})
+	}
+	return out
+}
+
+// comboID joins a combination's values in axis order, then any include-only
+// keys sorted by name, e.g. "linux-amd64", made safe with SafeID.
+func (m *MatrixField) comboID(c map[string]string) string {
+	var parts []string
+	seen := make(map[string]bool)
+	for _, axis := range m.Axes {
+		if v, ok := c[axis.Name]; ok {
+			parts = append(parts, v)
+			seen[axis.Name] = true
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(c)) {
+		if !seen[k] {
+			parts = append(parts, c[k])
+		}
+	}
+	return SafeID(strings.Join(parts, "-"))
+}
+
+// SafeID turns a value into a sub-run ID usable in an env var name by
+// replacing everything but letters, digits, hyphens and underscores with '-'.
+func SafeID(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '-'
+		}
+	}, s)
+}
+
+// MatrixEnvKey is the env var a matrix value is exposed as: the axis name
+// uppercased with hyphens as underscores, e.g. goos → GOOS.
+func MatrixEnvKey(axis string) string {
+	return strings.ToUpper(strings.ReplaceAll(axis, "-", "_"))
+}
+
+// ExpandMatrix turns a step with a matrix and a single run command into a
+// sub-run step with one sub-run per combination. Each sub-run runs the same
+// command with its values set as env vars. Steps without a matrix are
+// left unchanged.
+func ExpandMatrix(s *Step) error {
+	if !s.Matrix.IsSet() {
+		return nil
+	}
+	if !s.Run.IsSingle() {
+		return fmt.Errorf("matrix: run must be a single command")
+	}
+	axes := make(map[string]string)
+	for _, axis := range s.Matrix.Axes {
+		if !validAxisName(axis.Name) {
+			return fmt.Errorf("matrix: invalid axis name %q — use letters, digits, hyphens and underscores", axis.Name)
+		}
+		if len(axis.Values) == 0 {
+			return fmt.Errorf("matrix: axis %q has no values", axis.Name)
+		}
+		if prev, ok := axes[MatrixEnvKey(axis.Name)]; ok {
+			return fmt.Errorf("matrix: axes %q and %q are both exposed as %s", prev, axis.Name, MatrixEnvKey(axis.Name))
+		}
+		axes[MatrixEnvKey(axis.Name)] = axis.Name
+	}
+	combos := s.Matrix.Combinations()
+	if len(combos) == 0 {
+		return fmt.Errorf("matrix: no combinations left after exclude")
+	}
+
+	subs := make([]SubRun, 0, len(combos))
+	// Sub-run IDs that differ only in case or in '-' vs '_' would share
+	// their PIPE_<STEP>_<ID> output, so they collide too.
+	ids := make(map[string]string)
+	for _, c := range combos {
+		key := MatrixEnvKey(c.ID)
+		if prev, ok := ids[key]; ok {
+			if prev == c.ID {
+				return fmt.Errorf("matrix: combinations collide on sub-run id %q", c.ID)
+			}
+			return fmt.Errorf("matrix: sub-run ids %q and %q collide on their env var name", prev, c.ID)
+		}
+		ids[key] = c.ID
+		env := make(map[string]string, len(c.Values))
+		for k, v := range c.Values {
+			env[MatrixEnvKey(k)] = v
+		}
+		subs = append(subs, SubRun{ID: c.ID, Run: s.Run.Single, Sensitive: s.Sensitive, Env: env})
+	}
+	s.Run = RunField{SubRuns: subs}
+	return nil
+}
+
+// matches reports whether every key of partial has the same value in c.
+func matches(c, partial map[string]string) bool {
+	for k, v := range partial {
+		if c[k] != v {
+			return false
+		}
+	}
+	return len(partial) > 0
+}
+
+// validAxisName checks that an axis name maps to a valid env var name.
+func validAxisName(name string) bool {
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package model
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMatrixField_Combinations(t *testing.T) {
+	input := `
+goos: [linux, darwin]
+goarch: [amd64, arm64]
+exclude:
+  - {goos: darwin, goarch: amd64}
+include:
+  - {goos: windows, goarch: amd64}
+  - {goos: linux, goarch: arm64}
+`
+	var m MatrixField
+	if err := yaml.Unmarshal([]byte(input), &m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.Axes) != 2 || m.Axes[0].Name != "goos" || m.Axes[1].Name != "goarch" {
+		t.Fatalf("expected axes in YAML order, got %+v", m.Axes)
+	}
+	var ids []string
+	for _, c := range m.Combinations() {
+		ids = append(ids, c.ID)
+	}
+	want := []string{"linux-amd64", "linux-arm64", "darwin-arm64", "windows-amd64"}
+	if len(ids) != len(want) {
+		t.Fatalf("expected %v, got %v", want, ids)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, ids)
+		}
+	}
+}
+
+func TestMatrixField_ComboIDSanitized(t *testing.T) {
+	m := MatrixField{Axes: []MatrixAxis{{Name: "go", Values: []string{"1.22"}}, {Name: "image", Values: []string{"alpine/3"}}}}
+	if got := m.Combinations()[0].ID; got != "1-22-alpine-3" {
+		t.Fatalf("unexpected id %q", got)
+	}
+}
+
+func TestExpandMatrix(t *testing.T) {
+	s := Step{
+		ID:     "build",
+		Run:    RunField{Single: "go build"},
+		Matrix: MatrixField{Axes: []MatrixAxis{{Name: "goos", Values: []string{"linux", "darwin"}}}},
+	}
+	if err := ExpandMatrix(&s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Run.IsSingle() || len(s.Run.SubRuns) != 2 {
+		t.Fatalf("expected 2 sub-runs, got %+v", s.Run)
+	}
+	sub := s.Run.SubRuns[1]
+	if sub.ID != "darwin" || sub.Run != "go build" || sub.Env["GOOS"] != "darwin" {
+		t.Fatalf("unexpected sub-run %+v", sub)
+	}
+}
+
+func TestExpandMatrix_Errors(t *testing.T) {
+	tests := []Step{
+		{ID: "a", Run: RunField{Strings: []string{"x"}}, Matrix: MatrixField{Axes: []MatrixAxis{{Name: "os", Values: []string{"linux"}}}}},
+		{ID: "b", Run: RunField{Single: "x"}, Matrix: MatrixField{Axes: []MatrixAxis{{Name: "os", Values: []string{"linux"}}}, Exclude: []map[string]string{{"os": "linux"}}}},
+		{ID: "c", Run: RunField{Single: "x"}, Matrix: MatrixField{Axes: []MatrixAxis{{Name: "v", Values: []string{"a.b", "a/b"}}}}},
+		{ID: "d", Run: RunField{Single: "x"}, Matrix: MatrixField{Axes: []MatrixAxis{{Name: "go version", Values: []string{"1"}}}}},
+		{ID: "e", Run: RunField{Single: "x"}, Matrix: MatrixField{Axes: []MatrixAxis{{Name: "v", Values: []string{"a_b-c", "a-b_c"}}}}},
+		{ID: "f", Run: RunField{Single: "x"}, Matrix: MatrixField{Axes: []MatrixAxis{{Name: "v", Values: []string{"Linux", "linux"}}}}},
+		{ID: "g", Run: RunField{Single: "x"}, Matrix: MatrixField{Axes: []MatrixAxis{{Name: "go-os", Values: []string{"linux"}}, {Name: "go_os", Values: []string{"linux"}}}}},
+	}
+	for _, s := range tests {
+		if err := ExpandMatrix(&s); err == nil {
+			t.Errorf("step %s: expected error", s.ID)
+		}
+	}
+}
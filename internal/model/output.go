@@ -0,0 +1,83 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Output formats a step's stdout can be parsed as.
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// ReservedOutputName reports whether a named output called name would clash
+// with PIPE_<STEP>_FILE or PIPE_<STEP>_ARTIFACTS, which the runner sets itself.
+func ReservedOutputName(name string) bool {
+	switch strings.ToUpper(strings.ReplaceAll(name, "-", "_")) {
+	case "FILE", "ARTIFACTS":
+		return true
+	}
+	return false
+}
+
+// FieldRef is a {{ .steps.<id>.<field>... }} accessor into the JSON output of
+// a step with output_format: json.
+type FieldRef struct {
+	Text string   // the whole {{ ... }} action
+	Step string   // step ID
+	Path []string // object keys and array indexes, outermost first
+}
+
+var fieldRefPattern = regexp.MustCompile(`\{\{\s*\.steps\.([A-Za-z0-9_-]+)((?:\.[A-Za-z0-9_-]+)+)\s*\}\}`)
+
+// FieldRefs returns the field accessors in s, in order of appearance.
+func FieldRefs(s string) []FieldRef {
+	if !strings.Contains(s, "{{") {
+		return nil
+	}
+	var refs []FieldRef
+	for _, m := range fieldRefPattern.FindAllStringSubmatch(s, -1) {
+		refs = append(refs, newFieldRef(m))
+	}
+	return refs
+}
+
+// ReplaceFieldRefs replaces every field accessor in s with the result of
+// value.
+func ReplaceFieldRefs(s string, value func(FieldRef) string) string {
+	if !strings.Contains(s, "{{") {
+		return s
+	}
+	return fieldRefPattern.ReplaceAllStringFunc(s, func(text string) string {
+		return value(newFieldRef(fieldRefPattern.FindStringSubmatch(text)))
+	})
+}
+
+func newFieldRef(m []string) FieldRef {
+	return FieldRef{
+		Text: m[0],
+		Step: m[1],
+		Path: strings.Split(strings.TrimPrefix(m[2], "."), "."),
+	}
+}
+
+var sizeUnits = map[string]int64{"": 1, "B": 1, "KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30}
+
+// ParseSize parses a byte size such as 512, 64KB or 1MB. Units are
+// case-insensitive and powers of 1024.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(c rune) bool { return c < '0' || c > '9' })
+	if i < 0 {
+		i = len(s)
+	}
+	unit, ok := sizeUnits[strings.ToUpper(strings.TrimSpace(s[i:]))]
+	n, err := strconv.ParseInt(s[:i], 10, 64)
+	if !ok || err != nil || n > (1<<62)/unit {
+		return 0, fmt.Errorf("invalid size %q — use bytes or a number with B, KB, MB or GB", s)
+	}
+	return n * unit, nil
+}
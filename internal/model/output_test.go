@@ -0,0 +1,36 @@
+package model
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFieldRefs(t *testing.T) {
+	refs := FieldRefs(`docker push {{ .steps.describe.image.tag }} {{.steps.list-pods.items.0.name}} {{ .Names }} {{ .steps.x }}`)
+	if len(refs) != 2 {
+		t.Fatalf("expected 2 refs, got %+v", refs)
+	}
+	if refs[0].Text != "{{ .steps.describe.image.tag }}" || refs[0].Step != "describe" || !slices.Equal(refs[0].Path, []string{"image", "tag"}) {
+		t.Fatalf("unexpected first ref %+v", refs[0])
+	}
+	if refs[1].Step != "list-pods" || !slices.Equal(refs[1].Path, []string{"items", "0", "name"}) {
+		t.Fatalf("unexpected second ref %+v", refs[1])
+	}
+	if FieldRefs("echo $PIPE_DESCRIBE") != nil {
+		t.Fatal("expected no refs without template delimiters")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]int64{"512": 512, "0": 0, "10B": 10, "64KB": 64 << 10, "1mb": 1 << 20, "2 GB": 2 << 30} {
+		got, err := ParseSize(in)
+		if err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "KB", "1.5MB", "-1", "10TB"} {
+		if _, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q): expected error", in)
+		}
+	}
+}
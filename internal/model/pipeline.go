@@ -2,6 +2,7 @@ package model
 
 import (
 	"fmt"
+	"slices"
 
 	"gopkg.in/yaml.v3"
 )
@@ -11,18 +12,63 @@ type Pipeline struct {
 	Description string            `yaml:"description"`
 	DotFile     string            `yaml:"dot_file"`
 	Vars        map[string]string `yaml:"vars"`
+	Timeout     string            `yaml:"timeout"`
+	Shell       ShellField        `yaml:"shell"`
+	CacheStore  string            `yaml:"cache_store"` // where cache: entries are kept, see cache.Open
 	Steps       []Step            `yaml:"steps"`
+	OnSuccess   []Step            `yaml:"on_success"` // after every step succeeded
+	OnFailure   []Step            `yaml:"on_failure"` // after a failed or cancelled run
+	Finally     []Step            `yaml:"finally"`    // after every run, last
+	Outputs     map[string]string `yaml:"outputs"`    // values exported to a parent pipeline's uses: step
+}
+
+// HookSteps returns the on_success, on_failure and finally steps in that order.
+func (p *Pipeline) HookSteps() []Step {
+	hooks := make([]Step, 0, len(p.OnSuccess)+len(p.OnFailure)+len(p.Finally))
+	hooks = append(hooks, p.OnSuccess...)
+	hooks = append(hooks, p.OnFailure...)
+	return append(hooks, p.Finally...)
 }
 
 type Step struct {
-	ID          string         `yaml:"id"`
-	Run         RunField       `yaml:"run"`
-	DependsOn   DependsOnField `yaml:"depends_on"`
-	Sensitive   bool           `yaml:"sensitive"`
-	Output      bool           `yaml:"output"`
-	Retry       int            `yaml:"retry"`
-	Cached      CacheField     `yaml:"cache"`
-	Interactive bool           `yaml:"interactive"`
+	ID               string            `yaml:"id"`
+	Run              RunField          `yaml:"run"`
+	DependsOn        DependsOnField    `yaml:"depends_on"`
+	Sensitive        bool              `yaml:"sensitive"`
+	Output           bool              `yaml:"output"`
+	OutputFormat     string            `yaml:"output_format"` // text (default) or json
+	InlineLimit      string            `yaml:"inline_limit"`  // largest stdout exported inline as PIPE_<ID>, e.g. 256KB
+	Outputs          []string          `yaml:"outputs"`       // named outputs, so references to PIPE_<ID>_<NAME> add an edge
+	Retry            RetryField        `yaml:"retry"`
+	Cached           CacheField        `yaml:"cache"`
+	Interactive      bool              `yaml:"interactive"`
+	Timeout          string            `yaml:"timeout"`
+	If               string            `yaml:"if"`
+	ContinueOnError  bool              `yaml:"continue_on_error"`
+	Dir              string            `yaml:"dir"`
+	Env              map[string]string `yaml:"env"`
+	Shell            ShellField        `yaml:"shell"`
+	Matrix           MatrixField       `yaml:"matrix"`
+	Foreach          string            `yaml:"foreach"`
+	MaxParallel      int               `yaml:"max_parallel"`
+	ConcurrencyGroup string            `yaml:"concurrency_group"`
+	Locks            []string          `yaml:"locks"`
+	Uses             string            `yaml:"uses"`      // another pipeline to run as this step
+	With             map[string]string `yaml:"with"`      // vars passed to the uses: pipeline
+	Artifacts        []string          `yaml:"artifacts"` // globs of files kept with the run after success
+	Sources          []string          `yaml:"sources"`   // globs of the files the step reads, for up-to-date checks
+	Generates        []string          `yaml:"generates"` // globs of the files the step produces, for up-to-date checks
+}
+
+// LockNames returns the names of the locks the step holds while it runs —
+// its concurrency_group and locks — sorted and without duplicates.
+func (s Step) LockNames() []string {
+	names := slices.Clone(s.Locks)
+	if s.ConcurrencyGroup != "" {
+		names = append(names, s.ConcurrencyGroup)
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
 }
 
 // DependsOnField supports both scalar and sequence YAML forms:
@@ -61,15 +107,19 @@ type RunField struct {
 	SubRuns []SubRun
 }
 
+// SubRun is a named parallel command. Dir is resolved against the step's dir;
+// Env is merged over the step's env.
 type SubRun struct {
-	ID        string `yaml:"id"`
-	Run       string `yaml:"run"`
-	Sensitive bool   `yaml:"sensitive"`
+	ID        string            `yaml:"id"`
+	Run       string            `yaml:"run"`
+	Sensitive bool              `yaml:"sensitive"`
+	Dir       string            `yaml:"dir"`
+	Env       map[string]string `yaml:"env"`
 }
 
-func (r *RunField) IsSingle() bool   { return r.Single != "" }
-func (r *RunField) IsStrings() bool  { return len(r.Strings) > 0 }
-func (r *RunField) IsSubRuns() bool  { return len(r.SubRuns) > 0 }
+func (r *RunField) IsSingle() bool  { return r.Single != "" }
+func (r *RunField) IsStrings() bool { return len(r.Strings) > 0 }
+func (r *RunField) IsSubRuns() bool { return len(r.SubRuns) > 0 }
 
 func (r *RunField) UnmarshalYAML(value *yaml.Node) error {
 	switch value.Kind {
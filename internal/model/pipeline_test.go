@@ -134,8 +134,8 @@ steps:
 	if !p.Steps[2].Run.IsSubRuns() {
 		t.Fatal("step 2: expected IsSubRuns()")
 	}
-	if p.Steps[3].Retry != 3 {
-		t.Fatalf("step 3: expected retry=3, got %d", p.Steps[3].Retry)
+	if p.Steps[3].Retry.Attempts != 3 {
+		t.Fatalf("step 3: expected retry=3, got %d", p.Steps[3].Retry.Attempts)
 	}
 	if !p.Steps[3].Sensitive {
 		t.Fatal("step 3: expected sensitive=true")
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RetryField supports two YAML forms:
+//   - int:     retry: 2 → {Attempts: 2}
+//   - mapping: retry: {attempts: 3, delay: 5s, backoff: exponential, ...}
+//
+// Attempts is the number of retries after the first failure in both forms.
+type RetryField struct {
+	Attempts      int
+	Delay         string // wait before the first retry (default 2s)
+	MaxDelay      string // cap on the computed wait
+	Backoff       string // constant|linear|exponential (default linear)
+	Jitter        bool   // randomize each wait between half and the full delay
+	OnExitCodes   []int  // only retry these exit codes
+	OnOutputMatch string // only retry when stdout/stderr matches this regex
+}
+
+func (r *RetryField) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		var n int
+		if err := value.Decode(&n); err != nil {
+			return fmt.Errorf("retry: expected a number of retries, got %q", value.Value)
+		}
+		r.Attempts = n
+		return nil
+
+	case yaml.MappingNode:
+		var m struct {
+			Attempts      int    `yaml:"attempts"`
+			Delay         string `yaml:"delay"`
+			MaxDelay      string `yaml:"max_delay"`
+			Backoff       string `yaml:"backoff"`
+			Jitter        bool   `yaml:"jitter"`
+			OnExitCodes   []int  `yaml:"on_exit_codes"`
+			OnOutputMatch string `yaml:"on_output_match"`
+		}
+		if err := value.Decode(&m); err != nil {
+			return fmt.Errorf("retry: decoding mapping: %w", err)
+		}
+		*r = RetryField(m)
+		return nil
+
+	default:
+		return fmt.Errorf("retry: must be a number or a mapping with attempts")
+	}
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRetryField_Scalar(t *testing.T) {
+	var r RetryField
+	if err := yaml.Unmarshal([]byte(`3`), &r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.Attempts != 3 {
+		t.Fatalf("expected Attempts 3, got %d", r.Attempts)
+	}
+	if r.Delay != "" || r.Backoff != "" {
+		t.Fatalf("expected defaults, got %+v", r)
+	}
+}
+
+func TestRetryField_Mapping(t *testing.T) {
+	input := `
+attempts: 4
+delay: 500ms
+max_delay: 10s
+backoff: exponential
+jitter: true
+on_exit_codes: [75, 137]
+on_output_match: "connection reset|503"
+`
+	var r RetryField
+	if err := yaml.Unmarshal([]byte(input), &r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := RetryField{
+		Attempts:      4,
+		Delay:         "500ms",
+		MaxDelay:      "10s",
+		Backoff:       "exponential",
+		Jitter:        true,
+		OnExitCodes:   []int{75, 137},
+		OnOutputMatch: "connection reset|503",
+	}
+	if r.Attempts != want.Attempts || r.Delay != want.Delay || r.MaxDelay != want.MaxDelay ||
+		r.Backoff != want.Backoff || r.Jitter != want.Jitter || r.OnOutputMatch != want.OnOutputMatch ||
+		len(r.OnExitCodes) != 2 || r.OnExitCodes[0] != 75 || r.OnExitCodes[1] != 137 {
+		t.Fatalf("got %+v, want %+v", r, want)
+	}
+}
+
+func TestRetryField_InvalidScalar(t *testing.T) {
+	var r RetryField
+	if err := yaml.Unmarshal([]byte(`"lots"`), &r); err == nil {
+		t.Fatal("expected error for non-numeric retry")
+	}
+}
+
+func TestRetryField_InvalidSequence(t *testing.T) {
+	var r RetryField
+	if err := yaml.Unmarshal([]byte(`[1, 2]`), &r); err == nil {
+		t.Fatal("expected error for sequence")
+	}
+}
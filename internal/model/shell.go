@@ -0,0 +1,77 @@
+package model
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ShellField supports two YAML forms:
+//   - scalar:   shell: bash → known interpreter, run as bash -c <command>
+//   - sequence: shell: ["bash", "-euo", "pipefail", "-c"] → argv template,
+//     the command is appended as the last argument
+type ShellField struct {
+	Name string   // interpreter from the scalar form
+	Argv []string // explicit argv template from the sequence form
+}
+
+// DefaultShell is the argv template used when neither the step nor the
+// pipeline sets shell:.
+var DefaultShell = []string{"sh", "-c"}
+
+// inlineFlags maps interpreters whose "run this string" flag is not -c.
+var inlineFlags = map[string]string{
+	"node": "-e",
+	"deno": "eval",
+	"ruby": "-e",
+	"perl": "-e",
+	"pwsh": "-Command",
+}
+
+func (s ShellField) IsSet() bool { return s.Name != "" || len(s.Argv) > 0 }
+
+// Command returns the argv prefix the command string is appended to.
+func (s ShellField) Command() []string {
+	switch {
+	case len(s.Argv) > 0:
+		return s.Argv
+	case s.Name != "":
+		if flag, ok := inlineFlags[filepath.Base(s.Name)]; ok {
+			return []string{s.Name, flag}
+		}
+		return []string{s.Name, "-c"}
+	default:
+		return DefaultShell
+	}
+}
+
+// Interpreter returns the program that runs the command.
+func (s ShellField) Interpreter() string {
+	return s.Command()[0]
+}
+
+func (s *ShellField) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		if value.Value == "" {
+			return fmt.Errorf("shell: must not be empty")
+		}
+		s.Name = value.Value
+		return nil
+
+	case yaml.SequenceNode:
+		var argv []string
+		if err := value.Decode(&argv); err != nil {
+			return fmt.Errorf("shell: decoding argv list: %w", err)
+		}
+		if len(argv) == 0 || argv[0] == "" {
+			return fmt.Errorf("shell: argv list must start with the interpreter")
+		}
+		s.Argv = argv
+		return nil
+
+	default:
+		return fmt.Errorf("shell: must be an interpreter name or an argv list")
+	}
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"slices"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestShellField_Names(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{`bash`, []string{"bash", "-c"}},
+		{`python3`, []string{"python3", "-c"}},
+		{`node`, []string{"node", "-e"}},
+		{`/usr/local/bin/node`, []string{"/usr/local/bin/node", "-e"}},
+		{`["bash", "-euo", "pipefail", "-c"]`, []string{"bash", "-euo", "pipefail", "-c"}},
+	}
+	for _, tt := range tests {
+		var s ShellField
+		if err := yaml.Unmarshal([]byte(tt.input), &s); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.input, err)
+		}
+		if got := s.Command(); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: Command() = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestShellField_Default(t *testing.T) {
+	var s ShellField
+	if s.IsSet() {
+		t.Fatal("expected zero value to be unset")
+	}
+	if got := s.Command(); !slices.Equal(got, []string{"sh", "-c"}) {
+		t.Fatalf("expected sh -c, got %v", got)
+	}
+}
+
+func TestShellField_Invalid(t *testing.T) {
+	for _, input := range []string{`[]`, `{name: bash}`, `""`} {
+		var s ShellField
+		if err := yaml.Unmarshal([]byte(input), &s); err == nil {
+			t.Errorf("%s: expected error", input)
+		}
+	}
+}
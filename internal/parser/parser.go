@@ -3,11 +3,18 @@ package parser
 import (
 	"bytes"
 	"fmt"
+	"maps"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/getpipe-dev/pipe/internal/cache"
+	"github.com/getpipe-dev/pipe/internal/condition"
 	"github.com/getpipe-dev/pipe/internal/config"
 	"github.com/getpipe-dev/pipe/internal/graph"
 	"github.com/getpipe-dev/pipe/internal/hub"
@@ -43,12 +50,29 @@ func LoadPipeline(name string) (*model.Pipeline, error) {
 		p.Name = name
 	}
 
+	if err := expandMatrices(&p); err != nil {
+		return nil, fmt.Errorf("validating pipeline %q: %w", name, err)
+	}
+
 	if err := Validate(&p); err != nil {
 		return nil, fmt.Errorf("validating pipeline %q: %w", name, err)
 	}
 	return &p, nil
 }
 
+// expandMatrices replaces every matrix step's run with one sub-run per
+// combination, so the graph, UI, cache and resume treat it like sub-runs.
+func expandMatrices(p *model.Pipeline) error {
+	for _, steps := range [][]model.Step{p.Steps, p.OnSuccess, p.OnFailure, p.Finally} {
+		for i := range steps {
+			if err := model.ExpandMatrix(&steps[i]); err != nil {
+				return fmt.Errorf("step %q: %w", steps[i].ID, err)
+			}
+		}
+	}
+	return nil
+}
+
 // Validate checks a pipeline for structural errors such as missing or
 // duplicate step IDs and missing run fields.
 func Validate(p *model.Pipeline) error {
@@ -58,18 +82,43 @@ func Validate(p *model.Pipeline) error {
 		}
 	}
 
-	ids := make(map[string]bool)
-	for i, s := range p.Steps {
-		if s.ID == "" {
-			return fmt.Errorf("step %d: missing id", i)
+	if err := validateTimeout(p.Timeout); err != nil {
+		return fmt.Errorf("pipeline: %w", err)
+	}
+
+	if _, err := cache.Open(p.CacheStore); err != nil {
+		return fmt.Errorf("pipeline: cache_store: %w", err)
+	}
+
+	for key := range p.Outputs {
+		if !validVarKey(key) {
+			return fmt.Errorf("invalid output name %q — use only letters, digits, hyphens, and underscores", key)
 		}
-		if ids[s.ID] {
-			return fmt.Errorf("step %d: duplicate id %q", i, s.ID)
+		if model.ReservedOutputName(key) {
+			return fmt.Errorf("invalid output name %q — it would clash with PIPE_<STEP>_%s", key, strings.ToUpper(key))
 		}
-		ids[s.ID] = true
+	}
 
-		if !s.Run.IsSingle() && !s.Run.IsStrings() && !s.Run.IsSubRuns() {
-			return fmt.Errorf("step %q: missing run field", s.ID)
+	ids := make(map[string]bool)
+	for i, s := range p.Steps {
+		if err := validateStep(i, s, ids); err != nil {
+			return err
+		}
+	}
+	for _, hooks := range []struct {
+		name  string
+		steps []model.Step
+	}{{"on_success", p.OnSuccess}, {"on_failure", p.OnFailure}, {"finally", p.Finally}} {
+		for i, s := range hooks.steps {
+			if err := validateStep(i, s, ids); err != nil {
+				return fmt.Errorf("%s: %w", hooks.name, err)
+			}
+			switch {
+			case len(s.DependsOn.Steps) > 0:
+				return fmt.Errorf("%s: step %q: depends_on is not supported — hook steps run in order", hooks.name, s.ID)
+			case s.Interactive:
+				return fmt.Errorf("%s: step %q: hook steps cannot be interactive", hooks.name, s.ID)
+			}
 		}
 	}
 
@@ -124,23 +173,10 @@ func Warnings(p *model.Pipeline) []string {
 		}
 	}
 
-	// Collect env var names produced by sensitive steps
-	sensitiveVars := make(map[string]string) // env var → step ID
-	for _, s := range p.Steps {
-		if !s.Sensitive {
-			continue
-		}
-		sensitiveVars[envKey(s.ID)] = s.ID
-		if s.Run.IsSubRuns() {
-			for _, sr := range s.Run.SubRuns {
-				if sr.Sensitive {
-					sensitiveVars[envKey(s.ID, sr.ID)] = s.ID + "/" + sr.ID
-				}
-			}
-		}
-	}
+	// Env var names produced by sensitive steps
+	sensitiveVars := SensitiveVars(p)
 
-	for _, s := range p.Steps {
+	for _, s := range slices.Concat(p.Steps, p.HookSteps()) {
 		// Warn: cache + sensitive means step won't re-execute and env var won't be available
 		if s.Cached.Enabled && s.Sensitive {
 			warns = append(warns, fmt.Sprintf(
@@ -171,6 +207,12 @@ func Warnings(p *model.Pipeline) []string {
 				s.ID,
 			))
 		}
+		if len(s.Sources) > 0 || len(s.Generates) > 0 {
+			warns = append(warns, fmt.Sprintf(
+				"step %q: interactive + sources/generates — up-to-date checks are ignored for interactive steps",
+				s.ID,
+			))
+		}
 		if s.Output {
 			warns = append(warns, fmt.Sprintf(
 				"step %q: interactive + output — output flag is ignored (terminal is attached directly)",
@@ -183,14 +225,28 @@ func Warnings(p *model.Pipeline) []string {
 				s.ID,
 			))
 		}
-		if s.Retry > 0 {
+		if s.Retry.Attempts > 0 {
 			warns = append(warns, fmt.Sprintf(
 				"step %q: interactive + retry — retry is ignored for interactive steps",
 				s.ID,
 			))
 		}
+		if s.Timeout != "" {
+			warns = append(warns, fmt.Sprintf(
+				"step %q: interactive + timeout — timeout is ignored for interactive steps",
+				s.ID,
+			))
+		}
+		if s.OutputFormat == model.OutputJSON {
+			warns = append(warns, fmt.Sprintf(
+				"step %q: interactive + output_format: json — output is not captured, so no fields are set",
+				s.ID,
+			))
+		}
 	}
 
+	warns = append(warns, fieldWarnings(p)...)
+
 	// Secret detection warnings
 	warns = append(warns, SecretWarnings(p)...)
 
@@ -198,7 +254,7 @@ func Warnings(p *model.Pipeline) []string {
 	for key := range p.Vars {
 		varName := varEnvKey(key)
 		used := false
-		for _, s := range p.Steps {
+		for _, s := range slices.Concat(p.Steps, p.HookSteps()) {
 			if referencesVar(s, varName) {
 				used = true
 				break
@@ -215,6 +271,46 @@ func Warnings(p *model.Pipeline) []string {
 	return warns
 }
 
+// fieldWarnings reports references to JSON output fields that cannot exist:
+// $PIPE_<ID>__<FIELD> or {{ .steps.<id>.<field> }} where no step or sub-run
+// with output_format: json produces PIPE_<ID>.
+func fieldWarnings(p *model.Pipeline) []string {
+	producers := make(map[string]model.Step) // PIPE_<ID> or PIPE_<ID>_<SUBRUN> → step
+	for _, s := range p.Steps {
+		if s.Run.IsSubRuns() {
+			for _, sr := range s.Run.SubRuns {
+				producers[envKey(s.ID, sr.ID)] = s
+			}
+		} else if s.Uses == "" && !s.Run.IsStrings() {
+			producers[envKey(s.ID)] = s
+		}
+	}
+
+	var warns []string
+	for _, s := range slices.Concat(p.Steps, p.HookSteps()) {
+		for _, ref := range graph.PipeRefs(s) {
+			key, _, ok := strings.Cut(ref, "__")
+			if !ok {
+				continue
+			}
+			src, found := producers[key]
+			switch {
+			case !found:
+				warns = append(warns, fmt.Sprintf(
+					"step %q: references $%s, but no step or sub-run produces JSON output as $%s",
+					s.ID, ref, key,
+				))
+			case src.OutputFormat != model.OutputJSON:
+				warns = append(warns, fmt.Sprintf(
+					"step %q: references $%s, but step %q does not set output_format: json",
+					s.ID, ref, src.ID,
+				))
+			}
+		}
+	}
+	return warns
+}
+
 // LintWarnings returns Warnings() plus lint-only advisories that are too
 // noisy for every run but useful when explicitly linting.
 func LintWarnings(p *model.Pipeline) []string {
@@ -224,8 +320,20 @@ func LintWarnings(p *model.Pipeline) []string {
 		warns = append(warns, "pipeline is missing a description")
 	}
 
-	for _, s := range p.Steps {
-		if s.Retry > 0 && s.Sensitive {
+	warns = append(warns, shellWarnings(p)...)
+
+	for _, s := range slices.Concat(p.Steps, p.HookSteps()) {
+		if s.Uses == "" {
+			continue
+		}
+		if _, err := resolve.Resolve(s.Uses); err != nil {
+			msg, _, _ := strings.Cut(err.Error(), "\n")
+			warns = append(warns, fmt.Sprintf("step %q: uses %q cannot be resolved: %s", s.ID, s.Uses, msg))
+		}
+	}
+
+	for _, s := range slices.Concat(p.Steps, p.HookSteps()) {
+		if s.Retry.Attempts > 0 && s.Sensitive {
 			warns = append(warns, fmt.Sprintf(
 				"step %q: retry > 0 with sensitive: true — command re-executes on each retry attempt",
 				s.ID,
@@ -236,6 +344,297 @@ func LintWarnings(p *model.Pipeline) []string {
 	return warns
 }
 
+// shellWarnings reports shell: interpreters that cannot be found on PATH.
+func shellWarnings(p *model.Pipeline) []string {
+	var warns []string
+	check := func(owner string, sh model.ShellField) {
+		if !sh.IsSet() {
+			return
+		}
+		if _, err := exec.LookPath(sh.Interpreter()); err != nil {
+			warns = append(warns, fmt.Sprintf("%s: shell %q not found on PATH", owner, sh.Interpreter()))
+		}
+	}
+	check("pipeline", p.Shell)
+	for _, s := range slices.Concat(p.Steps, p.HookSteps()) {
+		check(fmt.Sprintf("step %q", s.ID), s.Shell)
+	}
+	return warns
+}
+
+// validateTimeout checks that a timeout field is empty or a positive Go duration.
+func validateTimeout(s string) error {
+	if s == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid timeout %q — use a duration such as 30s, 5m or 1h", s)
+	}
+	if d <= 0 {
+		return fmt.Errorf("invalid timeout %q — must be greater than zero", s)
+	}
+	return nil
+}
+
+// validateStep checks one step; ids collects step IDs to catch duplicates
+// across the main steps and the hook lists.
+func validateStep(i int, s model.Step, ids map[string]bool) error {
+	if s.ID == "" {
+		return fmt.Errorf("step %d: missing id", i)
+	}
+	if ids[s.ID] {
+		return fmt.Errorf("step %d: duplicate id %q", i, s.ID)
+	}
+	ids[s.ID] = true
+
+	if s.Uses != "" {
+		if err := validateUses(s); err != nil {
+			return fmt.Errorf("step %q: %w", s.ID, err)
+		}
+	} else if !s.Run.IsSingle() && !s.Run.IsStrings() && !s.Run.IsSubRuns() {
+		return fmt.Errorf("step %q: missing run field", s.ID)
+	} else if len(s.With) > 0 {
+		return fmt.Errorf("step %q: with: requires uses:", s.ID)
+	}
+
+	if err := validateTimeout(s.Timeout); err != nil {
+		return fmt.Errorf("step %q: %w", s.ID, err)
+	}
+
+	if s.If != "" {
+		if _, err := condition.Parse(s.If); err != nil {
+			return fmt.Errorf("step %q: %w", s.ID, err)
+		}
+	}
+
+	if err := validateRetry(s.Retry); err != nil {
+		return fmt.Errorf("step %q: %w", s.ID, err)
+	}
+
+	if err := validateForeach(s); err != nil {
+		return fmt.Errorf("step %q: %w", s.ID, err)
+	}
+
+	if err := validateLocks(s); err != nil {
+		return fmt.Errorf("step %q: %w", s.ID, err)
+	}
+
+	if err := validateOutputFormat(s); err != nil {
+		return fmt.Errorf("step %q: %w", s.ID, err)
+	}
+
+	if err := validateOutputs(s.Outputs); err != nil {
+		return fmt.Errorf("step %q: %w", s.ID, err)
+	}
+
+	if err := validateArtifacts(s.Artifacts); err != nil {
+		return fmt.Errorf("step %q: %w", s.ID, err)
+	}
+
+	if err := cache.ValidateExpiry(s.Cached.ExpireAfter); err != nil {
+		return fmt.Errorf("step %q: cache: %w", s.ID, err)
+	}
+
+	if err := validateKeyFiles(s.Cached.KeyFiles); err != nil {
+		return fmt.Errorf("step %q: %w", s.ID, err)
+	}
+
+	if err := validateGlobs("sources", s.Sources); err != nil {
+		return fmt.Errorf("step %q: %w", s.ID, err)
+	}
+	if err := validateGlobs("generates", s.Generates); err != nil {
+		return fmt.Errorf("step %q: %w", s.ID, err)
+	}
+
+	if err := validateEnv(s.Env); err != nil {
+		return fmt.Errorf("step %q: %w", s.ID, err)
+	}
+	for _, sr := range s.Run.SubRuns {
+		if err := validateEnv(sr.Env); err != nil {
+			return fmt.Errorf("step %q sub-run %q: %w", s.ID, sr.ID, err)
+		}
+	}
+	return nil
+}
+
+// validateRetry checks the durations, backoff and pattern of a retry policy.
+func validateRetry(r model.RetryField) error {
+	if r.Attempts < 0 {
+		return fmt.Errorf("retry: attempts must not be negative, got %d", r.Attempts)
+	}
+	for _, d := range []struct{ name, value string }{{"delay", r.Delay}, {"max_delay", r.MaxDelay}} {
+		if d.value == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d.value); err != nil || v < 0 {
+			return fmt.Errorf("retry: invalid %s %q — use a duration such as 500ms, 5s or 1m", d.name, d.value)
+		}
+	}
+	switch r.Backoff {
+	case "", "constant", "linear", "exponential":
+	default:
+		return fmt.Errorf("retry: invalid backoff %q — use constant, linear or exponential", r.Backoff)
+	}
+	if r.OnOutputMatch != "" {
+		if _, err := regexp.Compile(r.OnOutputMatch); err != nil {
+			return fmt.Errorf("retry: invalid on_output_match: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateUses checks a step that runs another pipeline. Fields that shape
+// the step's own command do not apply to it.
+func validateUses(s model.Step) error {
+	unsupported := []struct {
+		field string
+		set   bool
+	}{
+		{"run", s.Run.IsSingle() || s.Run.IsStrings() || s.Run.IsSubRuns()},
+		{"matrix", s.Matrix.IsSet()},
+		{"foreach", s.Foreach != ""},
+		{"interactive", s.Interactive},
+		{"cache", s.Cached.Enabled},
+		{"retry", s.Retry.Attempts > 0},
+		{"shell", s.Shell.IsSet()},
+		{"dir", s.Dir != ""},
+		{"env", len(s.Env) > 0},
+		{"output_format", s.OutputFormat != ""},
+		{"inline_limit", s.InlineLimit != ""},
+		{"artifacts", len(s.Artifacts) > 0},
+		{"sources", len(s.Sources) > 0},
+		{"generates", len(s.Generates) > 0},
+	}
+	for _, u := range unsupported {
+		if u.set {
+			return fmt.Errorf("uses: cannot be combined with %s", u.field)
+		}
+	}
+	for key := range s.With {
+		if !validVarKey(key) {
+			return fmt.Errorf("with: invalid var key %q — use only letters, digits, hyphens, and underscores", key)
+		}
+	}
+	return nil
+}
+
+// validateLocks checks the locks: names of a step.
+func validateLocks(s model.Step) error {
+	if slices.Contains(s.Locks, "") {
+		return fmt.Errorf("locks: lock names must not be empty")
+	}
+	return nil
+}
+
+// validateOutputFormat checks the output_format: and inline_limit: of a step.
+func validateOutputFormat(s model.Step) error {
+	if s.InlineLimit != "" {
+		if _, err := model.ParseSize(s.InlineLimit); err != nil {
+			return fmt.Errorf("inline_limit: %w", err)
+		}
+		if s.Run.IsStrings() {
+			return fmt.Errorf("inline_limit needs captured output — a list of commands is not captured")
+		}
+	}
+	switch s.OutputFormat {
+	case "", model.OutputText:
+		return nil
+	case model.OutputJSON:
+		if s.Run.IsStrings() {
+			return fmt.Errorf("output_format: json needs captured output — a list of commands is not captured")
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid output_format %q — use text or json", s.OutputFormat)
+}
+
+// validateOutputs checks the output names a step declares under outputs:.
+func validateOutputs(names []string) error {
+	for _, name := range names {
+		if !validVarKey(name) {
+			return fmt.Errorf("outputs: invalid name %q — use only letters, digits, hyphens, and underscores", name)
+		}
+		if model.ReservedOutputName(name) {
+			return fmt.Errorf("outputs: invalid name %q — it would clash with PIPE_<STEP>_%s", name, strings.ToUpper(name))
+		}
+	}
+	return nil
+}
+
+// validateArtifacts checks that artifacts: patterns are valid globs that stay
+// inside the step's working directory.
+func validateArtifacts(patterns []string) error {
+	for _, p := range patterns {
+		clean := filepath.Clean(p)
+		switch {
+		case p == "":
+			return fmt.Errorf("artifacts: patterns must not be empty")
+		case filepath.IsAbs(p), clean == "..", strings.HasPrefix(clean, ".."+string(filepath.Separator)):
+			return fmt.Errorf("artifacts: %q must be relative to the step's working directory", p)
+		}
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("artifacts: invalid pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// validateGlobs checks the patterns of a sources: or generates: list.
+func validateGlobs(field string, patterns []string) error {
+	for _, p := range patterns {
+		if p == "" {
+			return fmt.Errorf("%s: patterns must not be empty", field)
+		}
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("%s: invalid pattern %q: %w", field, p, err)
+		}
+	}
+	return nil
+}
+
+// validateKeyFiles checks the cache: key_files: paths of a step.
+func validateKeyFiles(paths []string) error {
+	for _, p := range paths {
+		if strings.TrimSpace(p) == "" {
+			return fmt.Errorf("cache: key_files: paths must not be empty")
+		}
+	}
+	return nil
+}
+
+// validateForeach checks the foreach: and max_parallel: fields of a step.
+func validateForeach(s model.Step) error {
+	if s.MaxParallel < 0 {
+		return fmt.Errorf("max_parallel must not be negative, got %d", s.MaxParallel)
+	}
+	if s.Foreach == "" {
+		return nil
+	}
+	switch {
+	case s.Matrix.IsSet():
+		return fmt.Errorf("foreach: cannot be combined with matrix")
+	case !s.Run.IsSingle():
+		return fmt.Errorf("foreach: run must be a single command")
+	case s.Interactive:
+		return fmt.Errorf("foreach: interactive steps cannot fan out")
+	}
+	return nil
+}
+
+// envNamePattern matches a portable environment variable name.
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateEnv checks the names in a step-scoped env: map.
+func validateEnv(env map[string]string) error {
+	for _, k := range slices.Sorted(maps.Keys(env)) {
+		if !envNamePattern.MatchString(k) {
+			return fmt.Errorf("env: invalid variable name %q — use letters, digits and underscores", k)
+		}
+	}
+	return nil
+}
+
 // validVarKey checks that a variable key contains only letters, digits, hyphens,
 // and underscores, and is non-empty.
 func validVarKey(key string) bool {
@@ -266,11 +665,15 @@ func envKey(parts ...string) string {
 	return "PIPE_" + strings.ToUpper(joined)
 }
 
-// referencesVar checks if any run command in a step contains the given variable name.
+// referencesVar checks if any run command, foreach source, dir, env value or
+// the if: condition of a step contains the given variable name.
 func referencesVar(s model.Step, varName string) bool {
 	check := func(cmd string) bool {
 		return strings.Contains(cmd, "$"+varName) || strings.Contains(cmd, "${"+varName+"}")
 	}
+	if s.If != "" && strings.Contains(s.If, varName) {
+		return true
+	}
 	if s.Run.IsSingle() && check(s.Run.Single) {
 		return true
 	}
@@ -280,7 +683,18 @@ func referencesVar(s model.Step, varName string) bool {
 		}
 	}
 	for _, sr := range s.Run.SubRuns {
-		if check(sr.Run) {
+		if check(sr.Run) || check(sr.Dir) || envReferences(sr.Env, check) {
+			return true
+		}
+	}
+	return check(s.Foreach) || check(s.Dir) || envReferences(s.Env, check) || envReferences(s.With, check)
+}
+
+// envReferences reports whether check matches any value of a step env: or
+// with: map.
+func envReferences(env map[string]string, check func(string) bool) bool {
+	for _, v := range env {
+		if check(v) {
 			return true
 		}
 	}
@@ -347,6 +761,10 @@ func LoadPipelineFromPath(path, displayName string) (*model.Pipeline, error) {
 		p.Name = displayName
 	}
 
+	if err := expandMatrices(&p); err != nil {
+		return nil, fmt.Errorf("validating pipeline %q: %w", displayName, err)
+	}
+
 	if err := Validate(&p); err != nil {
 		return nil, fmt.Errorf("validating pipeline %q: %w", displayName, err)
 	}
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/getpipe-dev/pipe/internal/config"
+	"github.com/getpipe-dev/pipe/internal/graph"
 )
 
 // overrideFilesDir points config.FilesDir at a temp directory for the test
@@ -808,6 +809,121 @@ steps:
 	}
 }
 
+func TestValidate_InvalidStepTimeout(t *testing.T) {
+	dir := overrideFilesDir(t)
+	writeYAML(t, dir, "bad-timeout", `
+name: bad-timeout
+steps:
+  - id: wait
+    run: "kubectl rollout status deploy/api"
+    timeout: "five minutes"
+`)
+	_, err := LoadPipeline("bad-timeout")
+	if err == nil {
+		t.Fatal("expected error for invalid timeout")
+	}
+	if !strings.Contains(err.Error(), "invalid timeout") {
+		t.Fatalf("expected error about invalid timeout, got %q", err.Error())
+	}
+}
+
+func TestValidate_PipelineTimeout(t *testing.T) {
+	dir := overrideFilesDir(t)
+	writeYAML(t, dir, "timeouts", `
+name: timeouts
+timeout: 10m
+steps:
+  - id: wait
+    run: "kubectl rollout status deploy/api"
+    timeout: 2m
+`)
+	p, err := LoadPipeline("timeouts")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.Timeout != "10m" || p.Steps[0].Timeout != "2m" {
+		t.Fatalf("expected timeouts 10m/2m, got %q/%q", p.Timeout, p.Steps[0].Timeout)
+	}
+
+	writeYAML(t, dir, "zero-timeout", `
+name: zero-timeout
+timeout: 0s
+steps:
+  - id: a
+    run: "echo a"
+`)
+	if _, err := LoadPipeline("zero-timeout"); err == nil || !strings.Contains(err.Error(), "greater than zero") {
+		t.Fatalf("expected error about zero timeout, got %v", err)
+	}
+}
+
+func TestWarnings_InteractiveTimeout(t *testing.T) {
+	dir := overrideFilesDir(t)
+	writeYAML(t, dir, "interactive-timeout", `
+name: interactive-timeout
+steps:
+  - id: shell
+    run: "bash"
+    interactive: true
+    timeout: 1m
+`)
+	p, err := LoadPipeline("interactive-timeout")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	found := false
+	for _, w := range Warnings(p) {
+		if strings.Contains(w, "interactive + timeout") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected warning about interactive + timeout, got: %v", Warnings(p))
+	}
+}
+
+func TestValidate_InvalidCondition(t *testing.T) {
+	dir := overrideFilesDir(t)
+	writeYAML(t, dir, "bad-if", `
+name: bad-if
+vars:
+  env: staging
+steps:
+  - id: deploy
+    run: "echo deploy"
+    if: PIPE_VAR_ENV = "prod"
+`)
+	_, err := LoadPipeline("bad-if")
+	if err == nil {
+		t.Fatal("expected error for invalid condition")
+	}
+	if !strings.Contains(err.Error(), "use == to compare") {
+		t.Fatalf("expected error about ==, got %q", err.Error())
+	}
+}
+
+func TestWarnings_VarUsedOnlyInCondition(t *testing.T) {
+	dir := overrideFilesDir(t)
+	writeYAML(t, dir, "if-var", `
+name: if-var
+vars:
+  env: staging
+steps:
+  - id: deploy
+    run: "echo deploy"
+    if: PIPE_VAR_ENV == "prod"
+`)
+	p, err := LoadPipeline("if-var")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, w := range Warnings(p) {
+		if strings.Contains(w, "never referenced") {
+			t.Fatalf("expected var used in if: to count as referenced, got %q", w)
+		}
+	}
+}
+
 func TestValidatePipeline_Invalid(t *testing.T) {
 	dir := overrideFilesDir(t)
 	writeYAML(t, dir, "bad", `
@@ -823,3 +939,404 @@ steps:
 		t.Fatalf("expected error containing %q, got %q", "missing id", err.Error())
 	}
 }
+
+func TestValidate_InvalidRetryPolicy(t *testing.T) {
+	tests := []struct {
+		retry string
+		want  string
+	}{
+		{"{attempts: 2, delay: soon}", "invalid delay"},
+		{"{attempts: 2, backoff: fibonacci}", "invalid backoff"},
+		{"{attempts: 2, on_output_match: \"(\"}", "invalid on_output_match"},
+		{"-1", "must not be negative"},
+	}
+	for _, tt := range tests {
+		dir := overrideFilesDir(t)
+		writeYAML(t, dir, "bad-retry", `
+name: bad-retry
+steps:
+  - id: fetch
+    run: "curl -f https://example.com"
+    retry: `+tt.retry+`
+`)
+		_, err := LoadPipeline("bad-retry")
+		if err == nil {
+			t.Fatalf("retry %s: expected error", tt.retry)
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("retry %s: expected error containing %q, got %q", tt.retry, tt.want, err.Error())
+		}
+	}
+}
+
+func TestValidate_InvalidEnvName(t *testing.T) {
+	dir := overrideFilesDir(t)
+	writeYAML(t, dir, "bad-env", `
+name: bad-env
+steps:
+  - id: build
+    run: "make"
+    env:
+      GO-FLAGS: "-trimpath"
+`)
+	_, err := LoadPipeline("bad-env")
+	if err == nil {
+		t.Fatal("expected error for invalid env name")
+	}
+	if !strings.Contains(err.Error(), "invalid variable name") {
+		t.Fatalf("expected error about variable name, got %q", err.Error())
+	}
+}
+
+func TestValidate_InvalidOutputName(t *testing.T) {
+	dir := overrideFilesDir(t)
+	writeYAML(t, dir, "bad-outputs", `
+name: bad-outputs
+steps:
+  - id: describe
+    run: 'echo "version=1.2.0" >> $PIPE_OUTPUT'
+    outputs: [version, "release notes"]
+`)
+	_, err := LoadPipeline("bad-outputs")
+	if err == nil {
+		t.Fatal("expected error for invalid output name")
+	}
+	if !strings.Contains(err.Error(), `outputs: invalid name "release notes"`) {
+		t.Fatalf("expected error about the output name, got %q", err.Error())
+	}
+}
+
+func TestValidate_ReservedOutputName(t *testing.T) {
+	dir := overrideFilesDir(t)
+	for name, yaml := range map[string]string{
+		"step-file": `
+name: step-file
+steps:
+  - id: render
+    run: 'echo "file=out.yaml" >> $PIPE_OUTPUT'
+    outputs: [file]
+`,
+		"pipeline-artifacts": `
+name: pipeline-artifacts
+steps:
+  - id: build
+    run: "make"
+outputs:
+  Artifacts: dist
+`,
+	} {
+		writeYAML(t, dir, name, yaml)
+		_, err := LoadPipeline(name)
+		if err == nil || !strings.Contains(err.Error(), "would clash with PIPE_<STEP>_") {
+			t.Errorf("%s: expected error about a reserved output name, got %v", name, err)
+		}
+	}
+}
+
+func TestWarnings_VarUsedOnlyInEnv(t *testing.T) {
+	dir := overrideFilesDir(t)
+	writeYAML(t, dir, "env-var", `
+name: env-var
+vars:
+  region: eu-west-1
+steps:
+  - id: deploy
+    run: "./deploy.sh"
+    env:
+      AWS_REGION: $PIPE_VAR_REGION
+`)
+	p, err := LoadPipeline("env-var")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, w := range Warnings(p) {
+		if strings.Contains(w, "never referenced") {
+			t.Fatalf("unexpected unused var warning: %s", w)
+		}
+	}
+}
+
+func TestLintWarnings_ShellNotOnPath(t *testing.T) {
+	dir := overrideFilesDir(t)
+	writeYAML(t, dir, "missing-shell", `
+name: missing-shell
+description: uses an interpreter that does not exist
+shell: ["no-such-interpreter-xyz", "-c"]
+steps:
+  - id: a
+    run: "echo a"
+  - id: b
+    shell: sh
+    run: "echo b"
+`)
+	p, err := LoadPipeline("missing-shell")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var found int
+	for _, w := range LintWarnings(p) {
+		if strings.Contains(w, "not found on PATH") {
+			found++
+			if !strings.Contains(w, "no-such-interpreter-xyz") {
+				t.Fatalf("unexpected warning: %s", w)
+			}
+		}
+	}
+	if found != 1 {
+		t.Fatalf("expected one shell warning, got %d", found)
+	}
+}
+
+func TestLoadPipeline_MatrixExpandsToSubRuns(t *testing.T) {
+	dir := overrideFilesDir(t)
+	writeYAML(t, dir, "release", `
+name: release
+steps:
+  - id: build
+    run: "go build -o dist/app-$GOOS-$GOARCH ."
+    matrix:
+      goos: [linux, darwin]
+      goarch: [amd64, arm64]
+  - id: checksum
+    run: "sha256sum $PIPE_BUILD_LINUX_AMD64"
+`)
+	p, err := LoadPipeline("release")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	subs := p.Steps[0].Run.SubRuns
+	if len(subs) != 4 || subs[0].ID != "linux-amd64" || subs[0].Env["GOARCH"] != "amd64" {
+		t.Fatalf("unexpected sub-runs: %+v", subs)
+	}
+	g, err := graph.Build(p.Steps)
+	if err != nil {
+		t.Fatalf("graph.Build: %v", err)
+	}
+	if g.InDegree["checksum"] != 1 {
+		t.Fatalf("expected checksum to depend on build, got in-degree %d", g.InDegree["checksum"])
+	}
+}
+
+func TestValidate_ForeachRequiresSingleRun(t *testing.T) {
+	dir := overrideFilesDir(t)
+	writeYAML(t, dir, "bad-foreach", `
+name: bad-foreach
+steps:
+  - id: list
+    run: "ls services"
+  - id: deploy
+    foreach: $PIPE_LIST
+    run:
+      - "echo a"
+      - "echo b"
+`)
+	_, err := LoadPipeline("bad-foreach")
+	if err == nil {
+		t.Fatal("expected error for foreach with a run list")
+	}
+	if !strings.Contains(err.Error(), "foreach: run must be a single command") {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+}
+
+func TestValidate_HookSteps(t *testing.T) {
+	tests := []struct {
+		name, yaml, want string
+	}{
+		{"depends_on", `
+name: hooks
+steps:
+  - id: deploy
+    run: "make deploy"
+finally:
+  - id: teardown
+    run: "make teardown"
+    depends_on: deploy
+`, "finally: step \"teardown\": depends_on is not supported"},
+		{"duplicate id", `
+name: hooks
+steps:
+  - id: deploy
+    run: "make deploy"
+on_failure:
+  - id: deploy
+    run: "make rollback"
+`, "on_failure: step 0: duplicate id \"deploy\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := overrideFilesDir(t)
+			writeYAML(t, dir, "hooks", tt.yaml)
+			_, err := LoadPipeline("hooks")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestValidate_EmptyLockName(t *testing.T) {
+	dir := overrideFilesDir(t)
+	writeYAML(t, dir, "bad-locks", `
+name: bad-locks
+steps:
+  - id: migrate
+    run: "make migrate"
+    locks: [db, ""]
+`)
+	_, err := LoadPipeline("bad-locks")
+	if err == nil || !strings.Contains(err.Error(), "lock names must not be empty") {
+		t.Fatalf("expected empty lock name error, got %v", err)
+	}
+}
+
+func TestValidate_UsesStep(t *testing.T) {
+	tests := []struct {
+		name, step, want string
+	}{
+		{"with run", "uses: acme/notify\n    run: \"echo hi\"", "uses: cannot be combined with run"},
+		{"with env", "uses: notify\n    env:\n      A: b", "uses: cannot be combined with env"},
+		{"bad with key", "uses: notify\n    with:\n      \"bad key\": x", "with: invalid var key"},
+		{"with without uses", "run: \"echo hi\"\n    with:\n      a: b", "with: requires uses:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := overrideFilesDir(t)
+			writeYAML(t, dir, "parent", "name: parent\nsteps:\n  - id: notify\n    "+tt.step+"\n")
+			_, err := LoadPipeline("parent")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestValidate_OutputFormat(t *testing.T) {
+	tests := []struct {
+		name, step, want string
+	}{
+		{"unknown format", "run: \"echo {}\"\n    output_format: yaml", `invalid output_format "yaml"`},
+		{"command list", "run: [\"echo {}\", \"echo []\"]\n    output_format: json", "a list of commands is not captured"},
+		{"uses", "uses: notify\n    output_format: json", "uses: cannot be combined with output_format"},
+		{"bad inline limit", "run: \"cat big.yaml\"\n    inline_limit: 1.5MB", `inline_limit: invalid size "1.5MB"`},
+		{"inline limit on command list", "run: [\"a\", \"b\"]\n    inline_limit: 1KB", "inline_limit needs captured output"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := overrideFilesDir(t)
+			writeYAML(t, dir, "bad-format", "name: bad-format\nsteps:\n  - id: s\n    "+tt.step+"\n")
+			_, err := LoadPipeline("bad-format")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestValidate_Artifacts(t *testing.T) {
+	tests := []struct {
+		name, artifacts, want string
+	}{
+		{"absolute", `["/tmp/app"]`, "must be relative to the step's working directory"},
+		{"parent", `["../dist/*"]`, "must be relative to the step's working directory"},
+		{"bad glob", `["dist/[a"]`, "invalid pattern"},
+		{"empty", `[""]`, "patterns must not be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := overrideFilesDir(t)
+			writeYAML(t, dir, "bad-artifacts", "name: bad-artifacts\nsteps:\n  - id: build\n    run: \"make\"\n    artifacts: "+tt.artifacts+"\n")
+			_, err := LoadPipeline("bad-artifacts")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestValidate_SourcesGenerates(t *testing.T) {
+	tests := []struct {
+		name, field, patterns, want string
+	}{
+		{"empty source", "sources", `[""]`, "sources: patterns must not be empty"},
+		{"bad source glob", "sources", `["src/[a"]`, "sources: invalid pattern"},
+		{"bad generates glob", "generates", `["dist/[a"]`, "generates: invalid pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := overrideFilesDir(t)
+			writeYAML(t, dir, "bad-globs", "name: bad-globs\nsteps:\n  - id: build\n    run: \"make\"\n    "+tt.field+": "+tt.patterns+"\n")
+			_, err := LoadPipeline("bad-globs")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestValidate_CacheStore(t *testing.T) {
+	dir := overrideFilesDir(t)
+	writeYAML(t, dir, "bad-store", "name: bad-store\ncache_store: \"https://\"\nsteps:\n  - id: build\n    run: \"make\"\n")
+	_, err := LoadPipeline("bad-store")
+	if err == nil || !strings.Contains(err.Error(), "cache_store") {
+		t.Fatalf("expected cache_store error, got %v", err)
+	}
+}
+
+func TestValidate_KeyFiles(t *testing.T) {
+	dir := overrideFilesDir(t)
+	writeYAML(t, dir, "bad-key-files", "name: bad-key-files\nsteps:\n  - id: build\n    run: \"make\"\n    cache:\n      key_files: [go.sum, \"\"]\n")
+	_, err := LoadPipeline("bad-key-files")
+	if err == nil || !strings.Contains(err.Error(), "key_files: paths must not be empty") {
+		t.Fatalf("expected key_files error, got %v", err)
+	}
+}
+
+func TestWarnings_JSONFieldRefs(t *testing.T) {
+	dir := overrideFilesDir(t)
+	writeYAML(t, dir, "warn-fields", `
+name: warn-fields
+steps:
+  - id: describe
+    run: "kubectl get deploy api -o json"
+    output_format: json
+  - id: version
+    run: "git describe"
+  - id: build
+    output_format: json
+    run:
+      - id: linux
+        run: "echo {}"
+  - id: deploy
+    run: "echo $PIPE_DESCRIBE__SPEC__REPLICAS {{ .steps.version.major }} $PIPE_BUILD_LINUX__SHA {{ .steps.missing.x }}"
+`)
+	p, err := LoadPipeline("warn-fields")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var fieldWarns []string
+	for _, w := range Warnings(p) {
+		if strings.Contains(w, "__") {
+			fieldWarns = append(fieldWarns, w)
+		}
+	}
+	if len(fieldWarns) != 2 ||
+		!strings.Contains(fieldWarns[0], `$PIPE_VERSION__MAJOR, but step "version" does not set output_format: json`) ||
+		!strings.Contains(fieldWarns[1], "$PIPE_MISSING__X, but no step or sub-run produces JSON output") {
+		t.Fatalf("expected warnings for the version and missing refs only, got: %v", fieldWarns)
+	}
+}
+
+func TestValidate_ExpireAfter(t *testing.T) {
+	dir := overrideFilesDir(t)
+	writeYAML(t, dir, "bad-expiry", "name: bad-expiry\nsteps:\n  - id: build\n    run: \"make\"\n    cache:\n      expireAfter: \"monday 6am\"\n")
+	_, err := LoadPipeline("bad-expiry")
+	if err == nil || !strings.Contains(err.Error(), `step "build": cache: invalid expiry "monday 6am"`) {
+		t.Fatalf("expected expiry error, got %v", err)
+	}
+
+	writeYAML(t, dir, "good-expiry", "name: good-expiry\nsteps:\n  - id: build\n    run: \"make\"\n    cache:\n      expireAfter: \"0 6 * * 1-5\"\n")
+	if _, err := LoadPipeline("good-expiry"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -2,27 +2,76 @@ package parser
 
 import (
 	"fmt"
+	"maps"
 	"regexp"
+	"slices"
 
 	"github.com/getpipe-dev/pipe/internal/model"
 )
 
 // secretPatterns maps a human-readable description to a regex that matches
 // common secrets or credentials accidentally embedded in shell commands.
+// Where a match carries more than the secret, the "secret" group marks the
+// part MaskSecrets hides.
 var secretPatterns = []struct {
 	name    string
 	pattern *regexp.Regexp
 }{
 	{"AWS access key", regexp.MustCompile(`AKIA[0-9A-Z]{16}`)},
-	{"secret assignment", regexp.MustCompile(`(?i)(api_key|secret|token|password)\s*=\s*"?[A-Za-z0-9_/+=\-]{8,}`)},
-	{"URL with credentials", regexp.MustCompile(`://[^:]+:[^@]+@`)},
+	{"secret assignment", regexp.MustCompile(`(?i)(api_key|secret|token|password)\s*=\s*"?(?P<secret>[A-Za-z0-9_/+=\-]{8,})`)},
+	{"URL with credentials", regexp.MustCompile(`://[^:]+:(?P<secret>[^@]+)@`)},
 	{"private key header", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)},
 	{"GitHub token", regexp.MustCompile(`ghp_[A-Za-z0-9]{36}`)},
 	{"GitLab token", regexp.MustCompile(`glpat-[A-Za-z0-9\-]{20,}`)},
-	{"Bearer token", regexp.MustCompile(`Bearer\s+[A-Za-z0-9\-._~+/]+=*`)},
+	{"Bearer token", regexp.MustCompile(`Bearer\s+(?P<secret>[A-Za-z0-9\-._~+/]+=*)`)},
 }
 
-// detectSecrets scans all run commands in a step for embedded secrets.
+// secretName matches var names whose values are likely credentials, such as
+// API_TOKEN or DB_PASS.
+var secretName = regexp.MustCompile(`(?i)(SECRET|TOKEN|PASS|PRIVATE|CREDENTIAL|API_?KEY|AUTH)`)
+
+// IsSecretName reports whether a var named name likely holds a credential.
+func IsSecretName(name string) bool {
+	return secretName.MatchString(name)
+}
+
+// MaskSecrets replaces the secrets detectSecrets would flag in s with mask.
+func MaskSecrets(s, mask string) string {
+	for _, sp := range secretPatterns {
+		i := sp.pattern.SubexpIndex("secret")
+		s = sp.pattern.ReplaceAllStringFunc(s, func(m string) string {
+			if i < 0 {
+				return mask
+			}
+			loc := sp.pattern.FindStringSubmatchIndex(m)
+			return m[:loc[2*i]] + mask + m[loc[2*i+1]:]
+		})
+	}
+	return s
+}
+
+// SensitiveVars returns the env vars set from the output of sensitive steps
+// and sub-runs, mapped to the step that produces them.
+func SensitiveVars(p *model.Pipeline) map[string]string {
+	vars := make(map[string]string)
+	for _, s := range p.Steps {
+		if !s.Sensitive {
+			continue
+		}
+		vars[envKey(s.ID)] = s.ID
+		if s.Run.IsSubRuns() {
+			for _, sr := range s.Run.SubRuns {
+				if sr.Sensitive {
+					vars[envKey(s.ID, sr.ID)] = s.ID + "/" + sr.ID
+				}
+			}
+		}
+	}
+	return vars
+}
+
+// detectSecrets scans all run commands and env and with: entries in a step for
+// embedded secrets.
 func detectSecrets(s model.Step) []string {
 	var findings []string
 	check := func(cmd string) {
@@ -40,6 +89,15 @@ func detectSecrets(s model.Step) []string {
 	}
 	for _, sr := range s.Run.SubRuns {
 		check(sr.Run)
+		for _, k := range slices.Sorted(maps.Keys(sr.Env)) {
+			check(k + "=" + sr.Env[k])
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(s.Env)) {
+		check(k + "=" + s.Env[k])
+	}
+	for _, k := range slices.Sorted(maps.Keys(s.With)) {
+		check(k + "=" + s.With[k])
 	}
 	return findings
 }
@@ -48,7 +106,7 @@ func detectSecrets(s model.Step) []string {
 // secrets but do not have sensitive: true set.
 func SecretWarnings(p *model.Pipeline) []string {
 	var warns []string
-	for _, s := range p.Steps {
+	for _, s := range slices.Concat(p.Steps, p.HookSteps()) {
 		if s.Sensitive {
 			continue
 		}
@@ -0,0 +1,193 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/getpipe-dev/pipe/internal/cache"
+	"github.com/getpipe-dev/pipe/internal/config"
+	"github.com/getpipe-dev/pipe/internal/model"
+	"github.com/getpipe-dev/pipe/internal/state"
+)
+
+// artifactDir returns where this run keeps a step's artifacts:
+// <ArtifactDir>/<pipeline>/<run-id>/<step>.
+func (r *Runner) artifactDir(stepID string) string {
+	return filepath.Join(config.ArtifactDir, r.pipeline.Name, r.state.RunID, stepID)
+}
+
+// workDir returns the absolute directory a step's commands run in, which its
+// artifacts: patterns are relative to.
+func (r *Runner) workDir(step model.Step) (string, error) {
+	dir := "."
+	if step.Dir != "" {
+		dir = r.expandEnv(step.Dir)
+	}
+	return filepath.Abs(dir)
+}
+
+// collectArtifacts copies the files matching a finished step's artifacts:
+// patterns into the run's artifact directory, records them in the step's
+// state and exports the directory as PIPE_<ID>_ARTIFACTS. Patterns that
+// match nothing and files that cannot be copied are logged, not fatal.
+func (r *Runner) collectArtifacts(step model.Step) {
+	if len(step.Artifacts) == 0 {
+		return
+	}
+	base, err := r.workDir(step)
+	if err != nil {
+		r.log.Log("[%s] artifacts: %v", step.ID, err)
+		return
+	}
+	dest := r.artifactDir(step.ID)
+	// A re-run replaces what an earlier attempt of this run kept.
+	if err := os.RemoveAll(dest); err != nil {
+		r.log.Log("[%s] artifacts: %v", step.ID, err)
+		return
+	}
+	var paths []string
+	for _, pattern := range step.Artifacts {
+		// Patterns are checked by the parser, so Glob cannot fail here.
+		matches, _ := filepath.Glob(filepath.Join(base, pattern))
+		if len(matches) == 0 {
+			r.log.Log("[%s] artifacts: no files match %q", step.ID, pattern)
+		}
+		for _, m := range matches {
+			rel, err := filepath.Rel(base, m)
+			if err != nil {
+				continue
+			}
+			if err := copyPath(m, filepath.Join(dest, rel)); err != nil {
+				r.log.Log("[%s] artifacts: %v", step.ID, err)
+				continue
+			}
+			paths = append(paths, rel)
+		}
+	}
+	slices.Sort(paths)
+	paths = slices.Compact(paths)
+	r.log.Log("[%s] artifacts: kept %d path(s) in %s", step.ID, len(paths), dest)
+
+	ss := r.getStepState(step.ID)
+	ss.Artifacts = &state.Artifacts{Dir: base, Paths: paths}
+	r.setStepState(step.ID, ss)
+	r.setEnv(EnvKey(step.ID)+"_ARTIFACTS", dest)
+}
+
+// restoreArtifacts copies a step's artifacts from dir (the run's or the
+// cache's copy) back into its working directory base, overwriting what is
+// there, so dependents find the files the step produced. When dir is not
+// the run's artifact directory they are copied there too. paths may come
+// from a shared cache store, so any that would land outside base or dir are
+// rejected.
+func (r *Runner) restoreArtifacts(step model.Step, dir, base string, paths []string) error {
+	for _, rel := range paths {
+		if !filepath.IsLocal(rel) {
+			return fmt.Errorf("restoring artifacts: invalid path %q", rel)
+		}
+	}
+	dest := r.artifactDir(step.ID)
+	for _, rel := range paths {
+		src := filepath.Join(dir, rel)
+		if err := copyPath(src, filepath.Join(base, rel)); err != nil {
+			return err
+		}
+		if dir != dest {
+			if err := copyPath(src, filepath.Join(dest, rel)); err != nil {
+				return err
+			}
+		}
+	}
+	r.setEnv(EnvKey(step.ID)+"_ARTIFACTS", dest)
+	return nil
+}
+
+// fetchArtifacts replaces the run's artifact directory for a cached step
+// with the artifacts stored with its cache entry.
+func (r *Runner) fetchArtifacts(store cache.Store, entry *cache.Entry) error {
+	dest := r.artifactDir(entry.StepID)
+	if err := os.RemoveAll(dest); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return err
+	}
+	return store.Artifacts(entry, dest)
+}
+
+// copyPath copies a file, symlink or directory tree from src to dst,
+// replacing dst and creating its parent directories.
+func copyPath(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return fmt.Errorf("copying artifact: %w", err)
+	}
+	if !info.IsDir() {
+		return copyEntry(src, dst, info)
+	}
+	if err := os.RemoveAll(dst); err != nil {
+		return fmt.Errorf("copying artifact: %w", err)
+	}
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("copying artifact: %w", err)
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("copying artifact: %w", err)
+		}
+		if d.IsDir() {
+			if err := os.MkdirAll(target, info.Mode().Perm()|0o700); err != nil {
+				return fmt.Errorf("copying artifact: %w", err)
+			}
+			return nil
+		}
+		return copyEntry(path, target, info)
+	})
+}
+
+// copyEntry copies one regular file or symlink, keeping its permissions.
+func copyEntry(src, dst string, info fs.FileInfo) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("copying artifact: %w", err)
+	}
+	if err := os.RemoveAll(dst); err != nil {
+		return fmt.Errorf("copying artifact: %w", err)
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return fmt.Errorf("copying artifact: %w", err)
+		}
+		if err := os.Symlink(target, dst); err != nil {
+			return fmt.Errorf("copying artifact: %w", err)
+		}
+		return nil
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("copying artifact %s: not a regular file", src)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("copying artifact: %w", err)
+	}
+	defer func() { _ = in.Close() }()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("copying artifact: %w", err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("copying artifact: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("copying artifact: %w", err)
+	}
+	return nil
+}
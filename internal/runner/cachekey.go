@@ -0,0 +1,161 @@
+package runner
+
+import (
+	"encoding/json"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/getpipe-dev/pipe/internal/cache"
+	"github.com/getpipe-dev/pipe/internal/graph"
+	"github.com/getpipe-dev/pipe/internal/model"
+	"github.com/getpipe-dev/pipe/internal/state"
+)
+
+// missingFile is recorded as the digest of a key_files path that does not
+// exist, so creating the file later changes the key.
+const missingFile = "missing"
+
+// cacheKey is a step's cache key and the parts it was computed from.
+type cacheKey struct {
+	key   string
+	parts cache.KeyParts
+}
+
+// cacheKey computes the key of a step's cache entry from the pipeline name,
+// the step ID, its command, the PIPE_VAR_* values it references, the outputs
+// of the steps it depends on and the contents of its cache: key_files:. It
+// is computed before the step runs and kept for saveCache, so files the step
+// itself changes do not affect the key it is stored under.
+func (r *Runner) cacheKey(step model.Step) cacheKey {
+	parts := cache.KeyParts{Command: commandDigest(r.pipeline, step)}
+
+	r.envMu.Lock()
+	for _, ref := range graph.PipeRefs(step) {
+		if !strings.HasPrefix(ref, "PIPE_VAR_") {
+			continue
+		}
+		if parts.Vars == nil {
+			parts.Vars = make(map[string]string)
+		}
+		parts.Vars[ref] = cache.Digest(r.lookupEnvLocked(ref))
+	}
+	r.envMu.Unlock()
+
+	if r.graph != nil {
+		for _, dep := range r.graph.Deps[step.ID] {
+			if parts.Upstream == nil {
+				parts.Upstream = make(map[string]string)
+			}
+			parts.Upstream[dep] = r.outputDigest(dep)
+		}
+	}
+
+	if len(step.Cached.KeyFiles) > 0 {
+		base, err := r.workDir(step)
+		if err != nil {
+			r.log.Log("[%s] cache warning: %v", step.ID, err)
+		}
+		parts.Files = make(map[string]string, len(step.Cached.KeyFiles))
+		for _, p := range step.Cached.KeyFiles {
+			path := r.expandEnv(p)
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(base, path)
+			}
+			parts.Files[p] = fileDigest(path)
+		}
+	}
+
+	k := cacheKey{parts: parts}
+	k.key = cache.Key(r.pipeline.Name, step.ID, parts)
+	r.keysMu.Lock()
+	if r.keys == nil {
+		r.keys = make(map[string]cacheKey)
+	}
+	r.keys[step.ID] = k
+	r.keysMu.Unlock()
+	return k
+}
+
+// storedKey returns the key cacheKey computed for a step in this run,
+// computing it now if the step never looked up the cache.
+func (r *Runner) storedKey(step model.Step) cacheKey {
+	r.keysMu.Lock()
+	k, ok := r.keys[step.ID]
+	r.keysMu.Unlock()
+	if ok {
+		return k
+	}
+	return r.cacheKey(step)
+}
+
+// commandDigest hashes everything that decides what a step runs: its run
+// field (matrix steps are already expanded into sub-runs), the shell, dir,
+// env and foreach source, unexpanded.
+func commandDigest(p *model.Pipeline, step model.Step) string {
+	data, _ := json.Marshal(struct {
+		Run           model.RunField
+		Shell         model.ShellField
+		PipelineShell model.ShellField
+		Dir           string
+		Env           map[string]string
+		Foreach       string
+	}{step.Run, step.Shell, p.Shell, step.Dir, step.Env, step.Foreach})
+	return cache.Digest(string(data))
+}
+
+// outputDigest hashes what a finished step hands its dependents: its
+// status, captured stdout and named outputs, those of its sub-runs, and the
+// contents of its artifacts. Sensitive outputs are not stored, so they do
+// not contribute.
+func (r *Runner) outputDigest(stepID string) string {
+	ss := r.getStepState(stepID)
+	var parts []string
+	add := func(ss state.StepState) {
+		parts = append(parts, ss.Status)
+		if ss.Sensitive {
+			return
+		}
+		out, err := storedOutput(ss)
+		if err != nil {
+			out = ss.Output
+		}
+		parts = append(parts, out)
+		for _, k := range slices.Sorted(maps.Keys(ss.Outputs)) {
+			parts = append(parts, k, ss.Outputs[k])
+		}
+	}
+	add(ss)
+	for _, id := range slices.Sorted(maps.Keys(ss.SubSteps)) {
+		parts = append(parts, id)
+		add(ss.SubSteps[id])
+	}
+	if ss.Artifacts != nil {
+		dir := r.artifactDir(stepID)
+		var files []string
+		_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				files = append(files, path)
+			}
+			return nil
+		})
+		digest, err := sourcesDigest(dir, files)
+		if err != nil {
+			digest = err.Error()
+		}
+		parts = append(parts, "artifacts", digest)
+	}
+	return cache.Digest(parts...)
+}
+
+// fileDigest hashes a key_files path's contents.
+func fileDigest(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return missingFile
+	}
+	return cache.Digest(string(data))
+}
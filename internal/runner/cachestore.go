@@ -0,0 +1,66 @@
+package runner
+
+import (
+	"time"
+
+	"github.com/getpipe-dev/pipe/internal/cache"
+)
+
+// cacheStore returns the store cache: steps use: the pipeline's cache_store,
+// else PIPE_CACHE_STORE, else the local cache. A store that cannot be opened,
+// or whose first failing operation is logged, is replaced by the local cache
+// for the rest of the run, the same way a broken entry is just a miss.
+func (r *Runner) cacheStore() cache.Store {
+	r.storeOnce.Do(func() {
+		local := cache.Local()
+		st, err := cache.ForPipeline(r.pipeline)
+		if err != nil {
+			r.log.Log("cache warning: %v — using the local cache", err)
+			r.store = local
+			return
+		}
+		if ds, ok := st.(*cache.DirStore); ok && !ds.Shared {
+			r.store = st
+			return
+		}
+		r.log.Log("using %s", st)
+		r.store = cache.Fallback(st, local, func(err error) {
+			r.log.Log("cache warning: %v — using the local cache", err)
+		})
+	})
+	return r.store
+}
+
+// recordHit counts a cache hit in the entry's stats. Failing to update them
+// is logged, never fatal.
+func (r *Runner) recordHit(store cache.Store, entry *cache.Entry) {
+	now := time.Now()
+	entry.Hits++
+	entry.LastHitAt = &now
+	if err := store.Update(entry); err != nil {
+		r.log.Log("[%s] cache warning: %v", entry.StepID, err)
+	}
+}
+
+// recordMiss keeps the stats of the entry a step could not use, or of none,
+// with the miss counted, for saveCache to carry over to the new entry.
+func (r *Runner) recordMiss(stepID string, entry *cache.Entry) {
+	var s cache.Stats
+	if entry != nil {
+		s = entry.Stats
+	}
+	s.Misses++
+	r.keysMu.Lock()
+	if r.stats == nil {
+		r.stats = make(map[string]cache.Stats)
+	}
+	r.stats[stepID] = s
+	r.keysMu.Unlock()
+}
+
+// missStats returns the stats recordMiss kept for a step.
+func (r *Runner) missStats(stepID string) cache.Stats {
+	r.keysMu.Lock()
+	defer r.keysMu.Unlock()
+	return r.stats[stepID]
+}
@@ -0,0 +1,106 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// ErrPipelineCancelled is returned when the run was interrupted by SIGINT or
+// SIGTERM. Like ErrPipelineFailed, the UI has already reported it.
+var ErrPipelineCancelled = fmt.Errorf("pipeline cancelled")
+
+// errCancelled is returned by steps that were killed or never started
+// because the run was interrupted.
+var errCancelled = errors.New("cancelled")
+
+// cancelledExitCode is the conventional exit status for SIGINT (128 + 2).
+const cancelledExitCode = 130
+
+// handleSignals cancels the run context on SIGINT/SIGTERM, which stops
+// dispatching and kills the process group of every running command.
+// The returned function stops signal handling and is safe to call twice.
+func (r *Runner) handleSignals() func() {
+	ctx, cancel := context.WithCancel(r.ctx)
+	r.ctx = ctx
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigCh:
+			r.interrupt(sig)
+			cancel()
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(sigCh)
+			close(done)
+		})
+	}
+}
+
+// interrupt records that the run was interrupted by sig.
+func (r *Runner) interrupt(sig os.Signal) {
+	if r.interrupted.Swap(true) {
+		return
+	}
+	r.log.Log("received %s, cancelling pipeline %q", sig, r.pipeline.Name)
+}
+
+// stopErr reports why no new command may start: errCancelled after Ctrl-C,
+// a pipeline timeout once the run's deadline has passed, or nil.
+func (r *Runner) stopErr() error {
+	switch {
+	case r.interrupted.Load():
+		return noRetry(errCancelled)
+	case errors.Is(r.ctx.Err(), context.DeadlineExceeded):
+		return noRetry(&timeoutError{pipeline: true})
+	default:
+		return nil
+	}
+}
+
+// resumeHint tells the user how to resume a run that ended with outcome.
+// A nested run is resumed through its parent, so it prints nothing.
+func (r *Runner) resumeHint(outcome string) {
+	if r.nested() {
+		return
+	}
+	fmt.Fprintf(os.Stderr,
+		"\n\033[2mPipeline %s. Resume with:\n  pipe %s --resume %s\033[0m\n\n",
+		outcome, r.pipeline.Name, r.state.RunID,
+	)
+}
+
+// markCancelled records the run as cancelled and prints the resume hint.
+func (r *Runner) markCancelled(cancelledSteps []string) error {
+	r.stateMu.Lock()
+	r.state.Status = "cancelled"
+	now := time.Now()
+	r.state.FinishedAt = &now
+	r.saveState()
+	r.stateMu.Unlock()
+
+	r.log.Log("pipeline %q cancelled (run %s)", r.pipeline.Name, r.state.RunID)
+	if r.ui != nil {
+		r.ui.Finish()
+	}
+	if len(cancelledSteps) > 0 && r.ui == nil {
+		log.Warn(fmt.Sprintf("pipeline %q cancelled steps: %s", r.pipeline.Name, strings.Join(cancelledSteps, ", ")))
+	}
+	r.resumeHint("cancelled")
+	return ErrPipelineCancelled
+}
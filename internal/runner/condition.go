@@ -0,0 +1,100 @@
+package runner
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getpipe-dev/pipe/internal/condition"
+	"github.com/getpipe-dev/pipe/internal/model"
+	"github.com/getpipe-dev/pipe/internal/ui"
+)
+
+// conditionData builds the values visible to an if: condition: all PIPE_*
+// vars and outputs, steps.<id>.status|exit_code|output, env.<NAME>, os and arch.
+func (r *Runner) conditionData() map[string]string {
+	data := make(map[string]string)
+	for k, v := range sysEnvMap() {
+		data["env."+k] = v
+	}
+	data["os"] = runtime.GOOS
+	data["arch"] = runtime.GOARCH
+
+	r.envMu.Lock()
+	for k, v := range r.envVars {
+		data[k] = v
+	}
+	r.envMu.Unlock()
+
+	r.stateMu.Lock()
+	for _, s := range r.pipeline.Steps {
+		ss, ok := r.state.Steps[s.ID]
+		status := "pending"
+		if ok && ss.Status != "" {
+			status = ss.Status
+		}
+		prefix := "steps." + s.ID + "."
+		data[prefix+"status"] = status
+		data[prefix+"exit_code"] = strconv.Itoa(ss.ExitCode)
+		data[prefix+"output"] = data[EnvKey(s.ID)]
+		if _, inline := data[EnvKey(s.ID)]; !inline && ss.OutputFile != "" {
+			// Stdout over the step's inline_limit is only in its file.
+			if out, err := storedOutput(ss); err == nil {
+				data[prefix+"output"] = strings.TrimRight(out, "\n")
+			}
+		}
+	}
+	r.stateMu.Unlock()
+	return data
+}
+
+// conditionMet evaluates a step's if: condition. Steps without one always run.
+func (r *Runner) conditionMet(step model.Step) (bool, error) {
+	if step.If == "" {
+		return true, nil
+	}
+	expr, err := condition.Parse(step.If)
+	if err != nil {
+		return false, err
+	}
+	return expr.Eval(r.conditionData())
+}
+
+// checksStatus reports whether step's if: reads the status or exit code of
+// step id, i.e. whether the condition is meant to handle id failing.
+func checksStatus(step model.Step, id string) bool {
+	if step.If == "" {
+		return false
+	}
+	expr, err := condition.Parse(step.If)
+	if err != nil {
+		return false
+	}
+	for _, ident := range expr.Idents() {
+		if ref, field, ok := condition.StepRef(ident); ok && ref == id && (field == "status" || field == "exit_code") {
+			return true
+		}
+	}
+	return false
+}
+
+// skipStep records a step whose if: condition was false. Skipped steps count
+// as satisfied for their dependents but set no PIPE_* output.
+func (r *Runner) skipStep(step model.Step) {
+	r.log.Log("[%s] skipped (condition %q is false)", step.ID, step.If)
+
+	r.stateMu.Lock()
+	ss := r.state.Steps[step.ID]
+	ss.Status = "skipped"
+	ss.ExitCode = 0
+	ss.Reason = ""
+	ss.Output = ""
+	now := time.Now()
+	ss.At = &now
+	r.state.Steps[step.ID] = ss
+	r.saveState()
+	r.stateMu.Unlock()
+
+	r.uiStatusStep(step, ui.Skipped)
+}
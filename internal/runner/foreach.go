@@ -0,0 +1,109 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/getpipe-dev/pipe/internal/logging"
+	"github.com/getpipe-dev/pipe/internal/model"
+	"github.com/getpipe-dev/pipe/internal/ui"
+)
+
+// splitItems turns a foreach source into items: a JSON array when the value
+// starts with '[', otherwise one item per non-empty line. JSON strings are
+// unquoted; other elements keep their compact JSON text.
+func splitItems(src string) ([]string, error) {
+	src = strings.TrimSpace(src)
+	if src == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(src, "[") {
+		var raw []json.RawMessage
+		if err := json.Unmarshal([]byte(src), &raw); err != nil {
+			return nil, fmt.Errorf("foreach: invalid JSON array: %w", err)
+		}
+		items := make([]string, 0, len(raw))
+		for _, m := range raw {
+			var s string
+			if err := json.Unmarshal(m, &s); err == nil {
+				items = append(items, s)
+				continue
+			}
+			items = append(items, string(m))
+		}
+		return items, nil
+	}
+	var items []string
+	for _, line := range strings.Split(src, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			items = append(items, line)
+		}
+	}
+	return items, nil
+}
+
+// foreachSubRuns builds one sub-run per item. IDs come from the item text so
+// resume matches items even if the list is reordered; duplicates get the
+// first free numeric suffix, skipping IDs other items have of their own.
+// IDs that differ only in case or in '-' vs '_' count as duplicates, since
+// they would share a PIPE_<STEP>_<ID> output.
+func foreachSubRuns(step model.Step, items []string) []model.SubRun {
+	ids := make([]string, len(items))
+	taken := make(map[string]bool, len(items))
+	for i, item := range items {
+		id := model.SafeID(item)
+		if len(id) > 40 {
+			id = id[:40]
+		}
+		ids[i] = id
+		taken[EnvKey(id)] = true
+	}
+	subs := make([]model.SubRun, 0, len(items))
+	seen := make(map[string]bool, len(items))
+	for i, item := range items {
+		id := ids[i]
+		if seen[EnvKey(id)] {
+			for n := 2; taken[EnvKey(id)]; n++ {
+				id = ids[i] + "-" + strconv.Itoa(n)
+			}
+			taken[EnvKey(id)] = true
+		}
+		seen[EnvKey(ids[i])] = true
+		subs = append(subs, model.SubRun{
+			ID:        id,
+			Run:       step.Run.Single,
+			Sensitive: step.Sensitive,
+			Env:       map[string]string{"PIPE_ITEM": item},
+		})
+	}
+	return subs
+}
+
+// runForeach expands the step's foreach source into items and runs the
+// step's command once per item with $PIPE_ITEM set, at most max_parallel
+// at a time when set.
+func (r *Runner) runForeach(step model.Step, sl *logging.StepLogger) error {
+	items, err := splitItems(r.expandStdout(step.Foreach))
+	if err != nil {
+		r.failNotStarted(step, err)
+		return fmt.Errorf("step %q: %w", step.ID, err)
+	}
+	sl.Log("foreach: %d item(s)", len(items))
+
+	subs := foreachSubRuns(step, items)
+	if r.ui != nil {
+		ids := make([]string, len(subs))
+		for i, sub := range subs {
+			ids[i] = sub.ID
+		}
+		r.ui.Expand(step.ID, ids)
+	}
+
+	if len(subs) == 0 {
+		r.log.Log("[%s] foreach: no items", step.ID)
+		r.uiStatus(step.ID, ui.Done)
+	}
+	return r.runSubRuns(step, subs)
+}
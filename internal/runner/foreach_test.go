@@ -0,0 +1,122 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/getpipe-dev/pipe/internal/model"
+)
+
+func TestSplitItems(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{"api\nweb\n\n  worker  \n", []string{"api", "web", "worker"}},
+		{`["api", "web"]`, []string{"api", "web"}},
+		{`[1, {"name": "api"}]`, []string{"1", `{"name": "api"}`}},
+		{"  ", nil},
+	}
+	for _, tt := range tests {
+		got, err := splitItems(tt.src)
+		if err != nil {
+			t.Fatalf("splitItems(%q): %v", tt.src, err)
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("splitItems(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+	if _, err := splitItems(`["api",`); err == nil {
+		t.Fatal("expected error for invalid JSON")
+	}
+}
+
+func TestForeachSubRunIDs(t *testing.T) {
+	subs := foreachSubRuns(model.Step{ID: "deploy", Run: model.RunField{Single: "x"}}, []string{"svc/api", "svc/api", "web"})
+	var ids []string
+	for _, s := range subs {
+		ids = append(ids, s.ID)
+	}
+	if strings.Join(ids, ",") != "svc-api,svc-api-2,web" {
+		t.Fatalf("unexpected ids %v", ids)
+	}
+	if subs[0].Env["PIPE_ITEM"] != "svc/api" {
+		t.Fatalf("expected PIPE_ITEM to hold the raw item, got %v", subs[0].Env)
+	}
+}
+
+func TestForeachSubRuns_SuffixSkipsItemIDs(t *testing.T) {
+	long := strings.Repeat("a", 40)
+	items := []string{"api", "api", "api-2", "api", long + "-x", long + "-y"}
+	subs := foreachSubRuns(model.Step{ID: "deploy", Run: model.RunField{Single: "x"}}, items)
+	var ids []string
+	for _, s := range subs {
+		ids = append(ids, s.ID)
+	}
+	want := []string{"api", "api-3", "api-2", "api-4", long, long + "-2"}
+	if !slices.Equal(ids, want) {
+		t.Fatalf("ids = %v, want %v", ids, want)
+	}
+}
+
+func TestForeachSubRuns_SuffixesEnvKeyClashes(t *testing.T) {
+	items := []string{"a_b-c", "a-b_c", "A_B_C", "a-b-c-2"}
+	subs := foreachSubRuns(model.Step{ID: "deploy", Run: model.RunField{Single: "x"}}, items)
+	var ids []string
+	for _, s := range subs {
+		ids = append(ids, s.ID)
+	}
+	want := []string{"a_b-c", "a-b_c-3", "A_B_C-4", "a-b-c-2"}
+	if !slices.Equal(ids, want) {
+		t.Fatalf("ids = %v, want %v", ids, want)
+	}
+}
+
+func TestRun_ForeachResumeOnlyFailedItems(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "ran")
+	p := &model.Pipeline{
+		Name: "test-foreach",
+		Steps: []model.Step{
+			{ID: "list-services", Run: model.RunField{Single: "printf 'api\\nweb\\nworker\\n'"}},
+			{
+				ID:          "deploy",
+				Foreach:     "$PIPE_LIST_SERVICES",
+				MaxParallel: 1,
+				Run:         model.RunField{Single: "echo $PIPE_ITEM >> " + log + "; [ $PIPE_ITEM != web ] || [ -f " + filepath.Join(dir, "fixed") + " ] && echo deployed $PIPE_ITEM"},
+			},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+	if err := r.Run(); err == nil {
+		t.Fatal("expected first run to fail")
+	}
+	subs := rs.Steps["deploy"].SubSteps
+	if subs["api"].Status != "done" || subs["web"].Status != "failed" || subs["worker"].Status != "done" {
+		t.Fatalf("unexpected item states: %+v", subs)
+	}
+	if got := r.envVars["PIPE_DEPLOY_WORKER"]; got != "deployed worker" {
+		t.Fatalf("expected per-item output, got %q", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "fixed"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r = New(p, rs, r.log, nil, nil, 0)
+	r.RestoreEnvFromState()
+	if err := r.Run(); err != nil {
+		t.Fatalf("resume error: %v", err)
+	}
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for item, want := range map[string]int{"api": 1, "web": 2, "worker": 1} {
+		if got := strings.Count(string(data), item+"\n"); got != want {
+			t.Fatalf("expected %s to run %d time(s), ran %d: %q", item, want, got, data)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/getpipe-dev/pipe/internal/model"
+)
+
+// hookSteps returns the hooks that apply to a run that ended with status:
+// on_success for "done", on_failure otherwise, then finally.
+func (r *Runner) hookSteps(status string) []model.Step {
+	if status == "done" {
+		return slices.Concat(r.pipeline.OnSuccess, r.pipeline.Finally)
+	}
+	return slices.Concat(r.pipeline.OnFailure, r.pipeline.Finally)
+}
+
+// runHooks runs the pipeline's hook steps once the main steps have settled.
+// Hooks run one at a time in declaration order, with PIPE_RUN_STATUS
+// (done, failed or cancelled) and PIPE_FAILED_STEPS (space-separated IDs) in
+// their env. They run under base, the context from before the pipeline
+// timeout, and with a fresh Ctrl-C handler so an interrupted run still cleans
+// up. Every hook runs even if an earlier one fails; the returned error lists
+// the hooks that failed.
+func (r *Runner) runHooks(base context.Context, status string, failedSteps []string) error {
+	hooks := r.hookSteps(status)
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	r.ctx = base
+	r.interrupted.Store(false)
+	stopSignals := r.handleSignals()
+	defer stopSignals()
+
+	r.setEnv("PIPE_RUN_STATUS", status)
+	r.setEnv("PIPE_FAILED_STEPS", strings.Join(failedSteps, " "))
+	r.log.Log("running %d hook step(s) for %s run", len(hooks), status)
+	if r.ui != nil {
+		r.ui.AddSteps(hooks)
+	}
+
+	var hookFailed []string
+	for _, step := range hooks {
+		// Hooks report on this attempt, so a resumed run runs them again.
+		r.stateMu.Lock()
+		delete(r.state.Steps, step.ID)
+		r.stateMu.Unlock()
+
+		if err := r.runStep(step); err != nil {
+			r.log.Log("[%s] hook failed: %v", step.ID, err)
+			if !step.ContinueOnError {
+				hookFailed = append(hookFailed, step.ID)
+			}
+		}
+	}
+	if len(hookFailed) > 0 {
+		return fmt.Errorf("hook steps failed: %s", strings.Join(hookFailed, ", "))
+	}
+	return nil
+}
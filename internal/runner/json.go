@@ -0,0 +1,110 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/getpipe-dev/pipe/internal/model"
+)
+
+// JSONKey builds the PIPE_<ID>__<FIELD>__... name of a field of a step's JSON
+// output. Characters that cannot appear in a variable name become
+// underscores, everything uppercased.
+func JSONKey(prefix string, path ...string) string {
+	var b strings.Builder
+	b.WriteString(prefix)
+	for _, p := range path {
+		b.WriteString("__")
+		for _, c := range strings.ToUpper(p) {
+			if (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' {
+				b.WriteRune(c)
+			} else {
+				b.WriteByte('_')
+			}
+		}
+	}
+	return b.String()
+}
+
+// jsonFields parses the stdout of an output_format: json command and
+// flattens it into entries keyed by JSONKey(prefix, path...). Top-level
+// fields are exported whatever their type, objects and arrays as compact
+// JSON; below that only strings, numbers, booleans and null are, so the
+// same bytes are not exported once per nesting level. Strings are exported
+// unquoted and null as empty.
+func jsonFields(output, prefix string) (map[string]string, error) {
+	dec := json.NewDecoder(strings.NewReader(output))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("output_format: json: stdout is empty")
+		}
+		var syn *json.SyntaxError
+		if errors.As(err, &syn) {
+			return nil, fmt.Errorf("output_format: json: stdout is not valid JSON (byte %d): %w", syn.Offset, err)
+		}
+		return nil, fmt.Errorf("output_format: json: stdout is not valid JSON: %w", err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("output_format: json: stdout has data after the JSON value (byte %d)", dec.InputOffset())
+	}
+	fields := make(map[string]string)
+	flattenJSON(fields, prefix, v, true)
+	return fields, nil
+}
+
+func flattenJSON(fields map[string]string, key string, v any, top bool) {
+	set := func(child string, e any) {
+		switch e.(type) {
+		case map[string]any, []any:
+			if top {
+				fields[child] = jsonValue(e)
+			}
+			flattenJSON(fields, child, e, false)
+		default:
+			fields[child] = jsonValue(e)
+		}
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		// Sorted so that keys normalizing to the same name resolve the same
+		// way every run.
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			set(JSONKey(key, k), v[k])
+		}
+	case []any:
+		for i, e := range v {
+			set(JSONKey(key, strconv.Itoa(i)), e)
+		}
+	}
+}
+
+func jsonValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// renderFieldsLocked replaces {{ .steps.<id>.<field> }} accessors in s with
+// the matching PIPE_<ID>__<FIELD> values; callers hold envMu.
+func (r *Runner) renderFieldsLocked(s string) string {
+	return model.ReplaceFieldRefs(s, func(ref model.FieldRef) string {
+		return r.envVars[JSONKey(EnvKey(ref.Step), ref.Path...)]
+	})
+}
@@ -0,0 +1,62 @@
+package runner
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestJSONFields(t *testing.T) {
+	out := `{"image": {"tag": "v1", "app.kubernetes.io/name": "api"}, "ready": true, "replicas": 3, "ports": [80, 443], "note": null}` + "\n"
+	got, err := jsonFields(out, "PIPE_DESCRIBE")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{
+		"PIPE_DESCRIBE__IMAGE":                         `{"app.kubernetes.io/name":"api","tag":"v1"}`,
+		"PIPE_DESCRIBE__IMAGE__TAG":                    "v1",
+		"PIPE_DESCRIBE__IMAGE__APP_KUBERNETES_IO_NAME": "api",
+		"PIPE_DESCRIBE__READY":                         "true",
+		"PIPE_DESCRIBE__REPLICAS":                      "3",
+		"PIPE_DESCRIBE__PORTS":                         "[80,443]",
+		"PIPE_DESCRIBE__PORTS__0":                      "80",
+		"PIPE_DESCRIBE__PORTS__1":                      "443",
+		"PIPE_DESCRIBE__NOTE":                          "",
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("got %q\nwant %q", got, want)
+	}
+}
+
+func TestJSONFields_NestedOnlyLeaves(t *testing.T) {
+	out := `{"spec": {"template": {"containers": [{"image": "api:v1", "ports": []}]}}}`
+	got, err := jsonFields(out, "PIPE_D")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{
+		"PIPE_D__SPEC": `{"template":{"containers":[{"image":"api:v1","ports":[]}]}}`,
+		"PIPE_D__SPEC__TEMPLATE__CONTAINERS__0__IMAGE": "api:v1",
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("got %q\nwant %q", got, want)
+	}
+}
+
+func TestJSONFields_Invalid(t *testing.T) {
+	tests := []struct {
+		name, out, want string
+	}{
+		{"empty", "\n", "stdout is empty"},
+		{"syntax", `{"tag": v1}`, "stdout is not valid JSON (byte 9)"},
+		{"trailing data", `{"tag": "v1"} done`, "stdout has data after the JSON value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := jsonFields(tt.out, "PIPE_X")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
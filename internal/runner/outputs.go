@@ -0,0 +1,125 @@
+package runner
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/getpipe-dev/pipe/internal/model"
+	"github.com/getpipe-dev/pipe/internal/state"
+)
+
+// newOutputFile creates the empty file a command's $PIPE_OUTPUT points to.
+func newOutputFile() (string, error) {
+	f, err := os.CreateTemp("", "pipe-output-*")
+	if err != nil {
+		return "", fmt.Errorf("creating $PIPE_OUTPUT file: %w", err)
+	}
+	path := f.Name()
+	if err := f.Close(); err != nil {
+		_ = os.Remove(path)
+		return "", fmt.Errorf("creating $PIPE_OUTPUT file: %w", err)
+	}
+	return path, nil
+}
+
+// readOutputFile reads and parses the named outputs a command wrote to its
+// $PIPE_OUTPUT file.
+func readOutputFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading $PIPE_OUTPUT: %w", err)
+	}
+	outputs, err := parseOutputs(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("$PIPE_OUTPUT: %w", err)
+	}
+	return outputs, nil
+}
+
+// parseOutputs parses the $PIPE_OUTPUT format: one key=value per line, or
+// key<<DELIM followed by lines up to a line holding only DELIM for multi-line
+// values. Blank lines are ignored and a later key overrides an earlier one.
+func parseOutputs(data string) (map[string]string, error) {
+	outputs := make(map[string]string)
+	lines := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		eq := strings.Index(line, "=")
+		heredoc := strings.Index(line, "<<")
+		switch {
+		case heredoc > 0 && (eq < 0 || heredoc < eq):
+			key, delim := line[:heredoc], line[heredoc+2:]
+			if !validOutputKey(key) {
+				return nil, fmt.Errorf("line %d: invalid key %q", i+1, key)
+			}
+			if delim == "" {
+				return nil, fmt.Errorf("line %d: missing heredoc delimiter for %q", i+1, key)
+			}
+			start := i + 1
+			end := start
+			for end < len(lines) && lines[end] != delim {
+				end++
+			}
+			if end == len(lines) {
+				return nil, fmt.Errorf("line %d: heredoc for %q is not closed by %q", i+1, key, delim)
+			}
+			outputs[key] = strings.Join(lines[start:end], "\n")
+			i = end
+		case eq > 0:
+			key := line[:eq]
+			if !validOutputKey(key) {
+				return nil, fmt.Errorf("line %d: invalid key %q", i+1, key)
+			}
+			outputs[key] = line[eq+1:]
+		default:
+			return nil, fmt.Errorf("line %d: expected key=value or key<<DELIMITER", i+1)
+		}
+	}
+	return outputs, nil
+}
+
+// validOutputKey checks that an output name maps to a valid env var suffix
+// that does not clash with one the runner sets.
+func validOutputKey(key string) bool {
+	if key == "" || model.ReservedOutputName(key) {
+		return false
+	}
+	for _, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// exportOutputs sets PIPE_<ID...>_<KEY> for every named output.
+func (r *Runner) exportOutputs(outputs map[string]string, id ...string) {
+	for key, value := range outputs {
+		r.setEnv(EnvKey(append(slices.Clone(id), key)...), value)
+	}
+}
+
+// itemOutputs merges the named outputs of a parallel run: list's done items
+// in list order, so a later item wins on a shared key.
+func itemOutputs(step model.Step, ss state.StepState) map[string]string {
+	var outputs map[string]string
+	for i := range step.Run.Strings {
+		item := ss.SubSteps[fmt.Sprintf("run_%d", i)]
+		if item.Status != "done" || len(item.Outputs) == 0 {
+			continue
+		}
+		if outputs == nil {
+			outputs = make(map[string]string)
+		}
+		maps.Copy(outputs, item.Outputs)
+	}
+	return outputs
+}
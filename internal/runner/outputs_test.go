@@ -0,0 +1,43 @@
+package runner
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestParseOutputs(t *testing.T) {
+	data := "version=1.2.0\n\nurl=https://x/?a=b\nnotes<<EOF\nline one\nline=two\nEOF\nversion=1.3.0\n"
+	got, err := parseOutputs(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{
+		"version": "1.3.0",
+		"url":     "https://x/?a=b",
+		"notes":   "line one\nline=two",
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseOutputs_Errors(t *testing.T) {
+	tests := []struct {
+		name, data, want string
+	}{
+		{"invalid key", "bad key=1\n", `line 1: invalid key "bad key"`},
+		{"reserved key", "ok=1\nFILE=out.txt\n", `line 2: invalid key "FILE"`},
+		{"no separator", "ok=1\njust text\n", "line 2: expected key=value"},
+		{"missing delimiter", "notes<<\n", `missing heredoc delimiter for "notes"`},
+		{"unclosed heredoc", "notes<<EOF\ntext\n", `heredoc for "notes" is not closed by "EOF"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseOutputs(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,453 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/getpipe-dev/pipe/internal/graph"
+	"github.com/getpipe-dev/pipe/internal/logging"
+	"github.com/getpipe-dev/pipe/internal/model"
+	"github.com/getpipe-dev/pipe/internal/parser"
+	"github.com/getpipe-dev/pipe/internal/state"
+)
+
+// What a dry run predicts a step will do.
+const (
+	planRun        = "run"
+	planDone       = "skip (already done)"
+	planSkipped    = "skip (condition is false)"
+	planUpToDate   = "up to date"
+	planCached     = "cache hit"
+	planUnselected = "skip (not selected)"
+)
+
+// masked replaces values a dry run must not print.
+const masked = "***"
+
+// planRefPattern matches $PIPE_<NAME> and ${PIPE_<NAME>} references.
+var planRefPattern = regexp.MustCompile(`\$\{?(PIPE_[A-Z0-9_]+)\}?`)
+
+// plannedStep is a dry run's prediction for one step.
+type plannedStep struct {
+	id       string
+	label    string // hook kind, e.g. "finally"
+	action   string
+	notes    []string
+	commands []string
+	uses     []wave // the waves of a uses: step's pipeline
+	usesName string
+}
+
+type wave []plannedStep
+
+// dryRun tracks what a dry run has predicted so far.
+type dryRun struct {
+	runs map[string]bool // steps predicted to run, whose outputs are unknown
+	// Steps whose outputs are known but whose output digest is not, because
+	// their cached artifacts are only restored at run time.
+	opaque  map[string]bool
+	maskAll bool // a sensitive uses: step's pipeline
+}
+
+// DryRun prints the steps a run would execute, wave by wave, with their
+// commands rendered from the resolved vars and predicted cache hits,
+// up-to-date checks, false conditions, steps a Selection leaves out and, for
+// a loaded run state, resume skips. Nothing is executed and no state, log,
+// output or cache file is written. Values of vars that look like secrets and
+// everything substituted into sensitive steps are masked.
+func (r *Runner) DryRun(w io.Writer) error {
+	// A new run has no step states yet; plan adds the ones it predicts.
+	resumed := len(r.state.Steps) > 0
+	if resumed {
+		// Outputs of done steps, without copying their artifacts back.
+		r.restoreEnvFromState(false)
+	}
+	waves, interactive, hooks, err := r.plan(&dryRun{})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Dry run of pipeline %q — nothing is executed\n", r.pipeline.Name)
+	if resumed {
+		fmt.Fprintf(w, "Resuming run %s\n", r.state.RunID)
+	}
+	counts := make(map[string]int)
+	for i, wv := range waves {
+		fmt.Fprintf(w, "\nWave %d\n", i+1)
+		printPlanned(w, wv, "  ", counts)
+	}
+	if interactive != nil {
+		fmt.Fprintln(w, "\nInteractive")
+		printPlanned(w, []plannedStep{*interactive}, "  ", counts)
+	}
+	if len(hooks) > 0 {
+		fmt.Fprintln(w, "\nHooks")
+		printPlanned(w, hooks, "  ", nil)
+	}
+	fmt.Fprintf(w, "\n%d to run, %d cached, %d up to date, %d skipped\n",
+		counts[planRun], counts[planCached], counts[planUpToDate], counts[planDone]+counts[planSkipped]+counts[planUnselected])
+	return nil
+}
+
+func printPlanned(w io.Writer, steps []plannedStep, indent string, counts map[string]int) {
+	width := 0
+	for _, s := range steps {
+		width = max(width, len(s.id))
+	}
+	for _, s := range steps {
+		action := s.action
+		if s.label != "" {
+			action = s.label + ": " + action
+		}
+		fmt.Fprintf(w, "%s%-*s  %s\n", indent, width, s.id, action)
+		if counts != nil {
+			counts[s.action]++
+		}
+		for _, n := range s.notes {
+			fmt.Fprintf(w, "%s    %s\n", indent, n)
+		}
+		for _, c := range s.commands {
+			fmt.Fprintf(w, "%s    $ %s\n", indent, strings.ReplaceAll(c, "\n", "\n"+indent+"      "))
+		}
+		if s.usesName != "" {
+			for i, wv := range s.uses {
+				fmt.Fprintf(w, "%s    %s wave %d\n", indent, s.usesName, i+1)
+				// Steps of a nested pipeline are not counted; its step is.
+				printPlanned(w, wv, indent+"      ", nil)
+			}
+		}
+	}
+}
+
+// plan predicts every step in dependency order and groups them in waves:
+// each wave's steps depend only on steps in earlier waves.
+func (r *Runner) plan(d *dryRun) (waves []wave, interactive *plannedStep, hooks []plannedStep, err error) {
+	g, err := graph.Build(r.pipeline.Steps)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("building dependency graph: %w", err)
+	}
+	r.graph = g
+	d.runs = make(map[string]bool)
+	d.opaque = make(map[string]bool)
+
+	iStep := InteractiveStep(r.pipeline)
+	stepByID := make(map[string]model.Step)
+	for _, s := range r.pipeline.Steps {
+		stepByID[s.ID] = s
+	}
+	inDeg := make(map[string]int)
+	for id, n := range g.InDegree {
+		if iStep == nil || id != iStep.ID {
+			inDeg[id] = n
+		}
+	}
+	var ready []string
+	for _, id := range g.Order {
+		if _, ok := inDeg[id]; ok && inDeg[id] == 0 {
+			ready = append(ready, id)
+		}
+	}
+	for len(ready) > 0 {
+		var wv wave
+		var next []string
+		for _, id := range ready {
+			wv = append(wv, r.planStep(d, stepByID[id]))
+			for _, dep := range g.Dependents[id] {
+				if _, ok := inDeg[dep]; !ok {
+					continue
+				}
+				if inDeg[dep]--; inDeg[dep] == 0 {
+					next = append(next, dep)
+				}
+			}
+		}
+		waves = append(waves, wv)
+		slices.SortFunc(next, func(a, b string) int {
+			return slices.Index(g.Order, a) - slices.Index(g.Order, b)
+		})
+		ready = next
+	}
+
+	if iStep != nil {
+		p := r.planStep(d, *iStep)
+		interactive = &p
+	}
+	for _, kind := range []struct {
+		label string
+		steps []model.Step
+	}{{"on_success", r.pipeline.OnSuccess}, {"on_failure", r.pipeline.OnFailure}, {"finally", r.pipeline.Finally}} {
+		for _, step := range kind.steps {
+			// Hooks run again on every attempt, so resume skips none.
+			p := plannedStep{id: step.ID, label: kind.label, action: planRun}
+			if step.If != "" {
+				p.notes = append(p.notes, fmt.Sprintf("if: %s (evaluated when the run ends)", step.If))
+			}
+			r.planCommands(d, step, &p)
+			hooks = append(hooks, p)
+		}
+	}
+	return waves, interactive, hooks, nil
+}
+
+// planStep predicts what runStep will do with a step, in the same order:
+// resume skip, partial run selection, if: condition, up-to-date check, cache lookup, run. What a
+// step that was not run would have exported is filled in from the resumed
+// state, the cache entry or, for unselected steps, an earlier run, so later
+// steps render with it.
+func (r *Runner) planStep(d *dryRun, step model.Step) plannedStep {
+	p := plannedStep{id: step.ID, action: planRun}
+	ss := r.state.Steps[step.ID]
+	if ss.Status == "done" && !step.Sensitive {
+		p.action = planDone
+		return p
+	}
+	if !r.isSelected(step.ID) {
+		p.action = planUnselected
+		ss, ok := r.reusedState(step)
+		switch {
+		case ok:
+			p.notes = append(p.notes, "outputs from "+ss.ReusedFrom)
+			r.exportState(step, ss)
+		case r.reuse[step.ID]:
+			p.notes = append(p.notes, "no earlier run or cache entry has its outputs")
+			ss = state.StepState{Status: "skipped"}
+		default:
+			ss = state.StepState{Status: "skipped"}
+		}
+		r.state.Steps[step.ID] = ss
+		return p
+	}
+
+	// Steps that run before this one decide its condition, its cache key
+	// and possibly its sources.
+	var pending []string
+	for _, dep := range r.graph.Deps[step.ID] {
+		if d.runs[dep] {
+			pending = append(pending, dep)
+		}
+	}
+	after := ""
+	if len(pending) > 0 {
+		after = fmt.Sprintf(" after %s run", strings.Join(pending, ", "))
+	}
+
+	if step.If != "" {
+		var decides []string
+		for _, dep := range pending {
+			if strings.Contains(step.If, "steps."+dep+".") || strings.Contains(step.If, EnvKey(dep)) {
+				decides = append(decides, dep)
+			}
+		}
+		if len(decides) > 0 {
+			p.notes = append(p.notes, fmt.Sprintf("if: %s (evaluated after %s run)", step.If, strings.Join(decides, ", ")))
+		} else if ok, err := r.conditionMet(step); err != nil {
+			p.notes = append(p.notes, fmt.Sprintf("if: %s: %v", step.If, err))
+		} else if !ok {
+			p.action = planSkipped
+			r.state.Steps[step.ID] = state.StepState{Status: "skipped"}
+			return p
+		}
+	}
+
+	if len(step.Sources) > 0 || len(step.Generates) > 0 {
+		ok, reason, _, prev := r.upToDate(step)
+		switch {
+		case ok && len(pending) > 0:
+			p.notes = append(p.notes, fmt.Sprintf("up to date now (%s); checked again%s", reason, after))
+		case ok:
+			p.action = planUpToDate
+			p.notes = append(p.notes, reason)
+			// Dependents see the outputs the step takes over.
+			ss := prev.Steps[step.ID]
+			r.exportState(step, ss)
+			r.state.Steps[step.ID] = state.StepState{Status: "done", UpToDate: true, Output: ss.Output, Outputs: ss.Outputs}
+			return p
+		default:
+			p.notes = append(p.notes, "not up to date: "+reason)
+		}
+	}
+
+	if step.Cached.Enabled {
+		if hit := r.planCache(d, step, pending, &p); hit {
+			return p
+		}
+	}
+
+	d.runs[step.ID] = true
+	r.planCommands(d, step, &p)
+	return p
+}
+
+// planCache predicts a cache lookup. On a hit it exports the entry's outputs
+// the way tryCache does and records the step as done in the dry run's state.
+func (r *Runner) planCache(d *dryRun, step model.Step, pending []string, p *plannedStep) bool {
+	var opaque []string
+	for _, dep := range r.graph.Deps[step.ID] {
+		if d.opaque[dep] {
+			opaque = append(opaque, dep)
+		}
+	}
+	switch {
+	case len(pending) > 0:
+		p.notes = append(p.notes, fmt.Sprintf("cache: checked after %s run", strings.Join(pending, ", ")))
+		return false
+	case len(opaque) > 0:
+		p.notes = append(p.notes, fmt.Sprintf("cache: checked after the cached artifacts of %s are restored", strings.Join(opaque, ", ")))
+		return false
+	}
+
+	entry, misses, err := r.lookupCache(r.cacheStore(), step)
+	switch {
+	case err != nil:
+		p.notes = append(p.notes, fmt.Sprintf("cache warning: %v", err))
+		return false
+	case entry == nil:
+		p.notes = append(p.notes, "cache miss: no entry")
+		return false
+	case len(misses) > 0:
+		for _, m := range misses {
+			p.notes = append(p.notes, "cache miss: "+m)
+		}
+		return false
+	}
+
+	p.action = planCached
+	p.notes = append(p.notes, "cached "+entry.CachedAt.Local().Format(time.DateTime))
+	if len(entry.Artifacts) > 0 {
+		d.opaque[step.ID] = true
+	}
+	if !entry.Sensitive {
+		r.exportStdout(step, entry.Output, "", step.ID)
+		r.exportOutputs(entry.Outputs, step.ID)
+		for _, sub := range entry.SubOutputs {
+			if !sub.Sensitive {
+				r.exportStdout(step, sub.Output, "", step.ID, sub.ID)
+				r.exportOutputs(sub.Outputs, step.ID, sub.ID)
+			}
+		}
+	}
+	ss := state.StepState{Status: "done", Sensitive: step.Sensitive}
+	if !step.Sensitive {
+		ss.Output = entry.Output
+		ss.Outputs = entry.Outputs
+	}
+	r.state.Steps[step.ID] = ss
+	return true
+}
+
+// planCommands renders the commands a step would run into p.
+func (r *Runner) planCommands(d *dryRun, step model.Step, p *plannedStep) {
+	mask := d.maskAll || step.Sensitive
+	if step.Dir != "" {
+		p.notes = append(p.notes, "dir: "+r.renderPlan(step.Dir, mask))
+	}
+	if env := r.renderPlanEnv(step.Env, mask); env != "" {
+		p.notes = append(p.notes, "env: "+env)
+	}
+	switch {
+	case step.Uses != "":
+		r.planUses(d, step, p)
+	case step.Foreach != "":
+		items, err := splitItems(r.expandStdout(step.Foreach))
+		src := r.renderPlan(step.Foreach, mask)
+		if err != nil || strings.Contains(src, "$PIPE_") || strings.Contains(src, "{{") {
+			p.notes = append(p.notes, fmt.Sprintf("foreach: %s (items known at run time)", src))
+		} else {
+			p.notes = append(p.notes, fmt.Sprintf("foreach: %d item(s) from %s", len(items), src))
+		}
+		p.commands = append(p.commands, r.renderPlan(step.Run.Single, mask))
+	case step.Run.IsSingle():
+		p.commands = append(p.commands, r.renderPlan(step.Run.Single, mask))
+	case step.Run.IsStrings():
+		for _, c := range step.Run.Strings {
+			p.commands = append(p.commands, r.renderPlan(c, mask))
+		}
+	case step.Run.IsSubRuns():
+		for _, sr := range step.Run.SubRuns {
+			subMask := mask || sr.Sensitive
+			line := "[" + sr.ID + "] " + r.renderPlan(sr.Run, subMask)
+			if sr.Dir != "" {
+				line += "  (dir: " + r.renderPlan(sr.Dir, subMask) + ")"
+			}
+			if env := r.renderPlanEnv(sr.Env, subMask); env != "" {
+				line += "  (env: " + env + ")"
+			}
+			p.commands = append(p.commands, line)
+		}
+	}
+}
+
+// planUses plans the pipeline a uses: step runs, with its with: values
+// rendered from this run's vars.
+func (r *Runner) planUses(d *dryRun, step model.Step, p *plannedStep) {
+	child, err := r.loadUses(step)
+	if err != nil {
+		p.notes = append(p.notes, err.Error())
+		return
+	}
+	for _, k := range slices.Sorted(maps.Keys(step.With)) {
+		v := r.renderPlan(step.With[k], d.maskAll || step.Sensitive)
+		if parser.IsSecretName(k) {
+			v = masked
+		}
+		p.notes = append(p.notes, fmt.Sprintf("with: %s=%s", k, v))
+	}
+	// Outputs of steps that run first stay references in the child's vars.
+	vars := r.childVars(step, child, r.log.Step(step.ID, true), func(s string) string { return r.renderPlan(s, false) })
+	cr := New(child, state.NewRunState(child.Name), logging.Discard(), vars, nil, r.verbosity)
+	cr.parents = append(slices.Clone(r.parents), r.pipeline.Name)
+	waves, _, _, err := cr.plan(&dryRun{maskAll: d.maskAll || step.Sensitive})
+	if err != nil {
+		p.notes = append(p.notes, fmt.Sprintf("uses %q: %v", step.Uses, err))
+		return
+	}
+	p.usesName = child.Name
+	p.uses = waves
+}
+
+// renderPlan substitutes the PIPE_* references and {{ .steps }} accessors
+// in s whose values are known before the run, masking them when mask is set,
+// the var looks like a secret or a sensitive step sets it. Literal secrets
+// the parser detects are masked too. References to outputs of steps that
+// run first, and all other $VARs, are left for the shell.
+func (r *Runner) renderPlan(s string, mask bool) string {
+	sensitive := parser.SensitiveVars(r.pipeline)
+	value := func(key, step, text string) string {
+		v, ok := r.envVars[key]
+		switch {
+		case !ok:
+			return text
+		case mask || parser.IsSecretName(key) || sensitive[key] != "" || sensitive[step] != "":
+			return masked
+		}
+		return v
+	}
+	r.envMu.Lock()
+	defer r.envMu.Unlock()
+	s = model.ReplaceFieldRefs(s, func(ref model.FieldRef) string {
+		return value(JSONKey(EnvKey(ref.Step), ref.Path...), EnvKey(ref.Step), ref.Text)
+	})
+	s = planRefPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		key := planRefPattern.FindStringSubmatch(ref)[1]
+		return value(key, key, ref)
+	})
+	return parser.MaskSecrets(s, masked)
+}
+
+// renderPlanEnv renders an env: map as K=V pairs in key order.
+func (r *Runner) renderPlanEnv(env map[string]string, mask bool) string {
+	var pairs []string
+	for _, k := range slices.Sorted(maps.Keys(env)) {
+		v := r.renderPlan(env[k], mask)
+		if parser.IsSecretName(k) {
+			v = masked
+		}
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, " ")
+}
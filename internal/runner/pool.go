@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/getpipe-dev/pipe/internal/model"
+)
+
+// acquireSlot blocks until one of the run's PIPE_MAX_PARALLEL process slots
+// is free and returns the function that frees it. Slots are taken per
+// command, not per step, so a step with more items than slots works through
+// them as slots free up. Without a pool (e.g. hooks) it returns immediately.
+func (r *Runner) acquireSlot() (release func()) {
+	if r.slots == nil {
+		return func() {}
+	}
+	r.slots <- struct{}{}
+	return func() { <-r.slots }
+}
+
+// stepLimiter returns a semaphore capping how many of the step's items run
+// at once, or nil when max_parallel is unset.
+func stepLimiter(step model.Step) chan struct{} {
+	if step.MaxParallel <= 0 {
+		return nil
+	}
+	return make(chan struct{}, step.MaxParallel)
+}
+
+// acquireItem takes the step's max_parallel slot, if any, then a process
+// slot. It never holds a process slot while waiting, so items queued behind
+// a step limit do not starve other steps.
+func (r *Runner) acquireItem(limit chan struct{}) (release func()) {
+	if limit != nil {
+		limit <- struct{}{}
+	}
+	releaseSlot := r.acquireSlot()
+	return func() {
+		releaseSlot()
+		if limit != nil {
+			<-limit
+		}
+	}
+}
+
+// lock returns the mutex for a named lock, creating it on first use.
+func (r *Runner) lock(name string) *sync.Mutex {
+	r.locksMu.Lock()
+	defer r.locksMu.Unlock()
+	if r.locks == nil {
+		r.locks = make(map[string]*sync.Mutex)
+	}
+	mu, ok := r.locks[name]
+	if !ok {
+		mu = &sync.Mutex{}
+		r.locks[name] = mu
+	}
+	return mu
+}
+
+// acquireLocks takes every lock the step names, in sorted order so two steps
+// sharing several locks cannot deadlock, and returns the function that
+// releases them.
+func (r *Runner) acquireLocks(step model.Step) (release func()) {
+	names := step.LockNames()
+	held := make([]*sync.Mutex, 0, len(names))
+	for _, name := range names {
+		mu := r.lock(name)
+		if !mu.TryLock() {
+			r.log.Log("[%s] waiting for lock %q", step.ID, name)
+			mu.Lock()
+		}
+		held = append(held, mu)
+	}
+	return func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			held[i].Unlock()
+		}
+	}
+}
+
+// notStarted reports a step that was about to run when the run stopped.
+// Cancelled steps stay pending for --resume; timed-out ones are failed.
+func (r *Runner) notStarted(step model.Step, err error) error {
+	if !errors.Is(err, errCancelled) {
+		r.failNotStarted(step, err)
+	}
+	return fmt.Errorf("step %q: %w", step.ID, err)
+}
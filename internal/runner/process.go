@@ -0,0 +1,69 @@
+package runner
+
+import (
+	"context"
+	"maps"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/getpipe-dev/pipe/internal/model"
+)
+
+// killGrace is how long a command gets between SIGTERM and SIGKILL when it
+// is stopped by a timeout or Ctrl-C. It is a variable so tests can shorten it.
+var killGrace = 5 * time.Second
+
+// command builds a command from argv bound to ctx. The interpreter runs in its
+// own process group so that, when ctx is done, SIGTERM reaches every process
+// the command spawned; anything still alive after killGrace gets SIGKILL.
+func command(ctx context.Context, argv []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		pgid := cmd.Process.Pid
+		time.AfterFunc(killGrace, func() {
+			_ = syscall.Kill(-pgid, syscall.SIGKILL)
+		})
+		return syscall.Kill(-pgid, syscall.SIGTERM)
+	}
+	// Backstop: stop waiting on stdout/stderr pipes held open by stray
+	// descendants shortly after the SIGKILL.
+	cmd.WaitDelay = killGrace + time.Second
+	return cmd
+}
+
+// cmdSpec is a command together with the step-scoped settings it runs with.
+type cmdSpec struct {
+	run   string
+	shell model.ShellField  // unset means the pipeline's shell
+	dir   string            // working directory; empty means the current one
+	env   map[string]string // visible to this command only, never exported as PIPE_*
+
+	outputFile string // exported to the command as $PIPE_OUTPUT
+}
+
+func stepSpec(step model.Step, run string) cmdSpec {
+	return cmdSpec{run: run, shell: step.Shell, dir: step.Dir, env: step.Env}
+}
+
+// subRunSpec resolves a sub-run's dir against its step's dir and merges its
+// env over the step's env.
+func subRunSpec(step model.Step, sub model.SubRun) cmdSpec {
+	spec := stepSpec(step, sub.Run)
+	if sub.Dir != "" {
+		spec.dir = sub.Dir
+		if step.Dir != "" && !filepath.IsAbs(sub.Dir) {
+			spec.dir = filepath.Join(step.Dir, sub.Dir)
+		}
+	}
+	if len(sub.Env) > 0 {
+		spec.env = maps.Clone(step.Env)
+		if spec.env == nil {
+			spec.env = make(map[string]string, len(sub.Env))
+		}
+		maps.Copy(spec.env, sub.Env)
+	}
+	return spec
+}
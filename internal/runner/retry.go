@@ -1,24 +1,221 @@
 package runner
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"regexp"
+	"slices"
 	"time"
+
+	"github.com/getpipe-dev/pipe/internal/logging"
+	"github.com/getpipe-dev/pipe/internal/model"
+	"github.com/getpipe-dev/pipe/internal/state"
 )
 
-// Retry calls fn up to maxAttempts times. On failure it waits attempt*2 seconds
-// before retrying. Returns the last error and the number of attempts made.
-func Retry(maxAttempts int, fn func() error) (int, error) {
-	if maxAttempts < 1 {
-		maxAttempts = 1
+// defaultRetryDelay is the wait before the first retry when none is set.
+const defaultRetryDelay = 2 * time.Second
+
+// stopError wraps an error that must not be retried, such as a command
+// killed because the pipeline-wide deadline passed.
+type stopError struct{ err error }
+
+func (e *stopError) Error() string { return e.err.Error() }
+func (e *stopError) Unwrap() error { return e.err }
+
+// noRetry marks err so RetryPolicy.Do returns it immediately.
+func noRetry(err error) error {
+	return &stopError{err: err}
+}
+
+// RetryPolicy decides how many attempts a command gets, how long to wait
+// between them, and which failures are worth retrying.
+type RetryPolicy struct {
+	MaxAttempts   int
+	Delay         time.Duration
+	MaxDelay      time.Duration
+	Backoff       string // constant|linear|exponential
+	Jitter        bool
+	OnExitCodes   []int
+	OnOutputMatch *regexp.Regexp
+}
+
+// NewRetryPolicy builds a policy from a step's retry field. Invalid durations
+// and patterns fall back to defaults; the parser rejects them at load time.
+func NewRetryPolicy(f model.RetryField) RetryPolicy {
+	p := RetryPolicy{
+		MaxAttempts: f.Attempts + 1,
+		Delay:       defaultRetryDelay,
+		Backoff:     f.Backoff,
+		Jitter:      f.Jitter,
+		OnExitCodes: f.OnExitCodes,
+	}
+	if d, err := time.ParseDuration(f.Delay); err == nil {
+		p.Delay = d
+	}
+	if d, err := time.ParseDuration(f.MaxDelay); err == nil {
+		p.MaxDelay = d
+	}
+	if f.OnOutputMatch != "" {
+		if re, err := regexp.Compile(f.OnOutputMatch); err == nil {
+			p.OnOutputMatch = re
+		}
+	}
+	return p
+}
+
+// wait returns the delay before retrying after the given (1-based) attempt.
+func (p RetryPolicy) wait(attempt int) time.Duration {
+	var d time.Duration
+	switch p.Backoff {
+	case "constant":
+		d = p.Delay
+	case "exponential":
+		// Double per attempt, keeping the last delay that does not overflow.
+		d = p.Delay
+		for i := 1; i < attempt && d > 0 && d <= math.MaxInt64/2; i++ {
+			d *= 2
+		}
+	default: // linear
+		d = p.Delay * time.Duration(attempt)
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter && d > 0 {
+		d = d/2 + rand.N(d/2+1)
+	}
+	return d
+}
+
+// retryable reports whether a failed attempt should be retried given its
+// error and combined stdout/stderr. With no on_* filters every failure is.
+func (p RetryPolicy) retryable(err error, output string) bool {
+	var stop *stopError
+	if errors.As(err, &stop) {
+		return false
 	}
-	var err error
-	for i := 1; i <= maxAttempts; i++ {
-		err = fn()
+	if len(p.OnExitCodes) > 0 && !slices.Contains(p.OnExitCodes, exitCode(err)) {
+		return false
+	}
+	if p.OnOutputMatch != nil && !p.OnOutputMatch.MatchString(output) {
+		return false
+	}
+	return true
+}
+
+// Do calls fn until it succeeds, the attempts run out, a failure is not
+// retryable, or ctx is done while waiting. fn returns the attempt's combined
+// output, used for on_output_match. Every attempt is recorded.
+func (p RetryPolicy) Do(ctx context.Context, fn func() (string, error)) ([]state.Attempt, error) {
+	maxAttempts := max(p.MaxAttempts, 1)
+	var attempts []state.Attempt
+	for i := 1; ; i++ {
+		start := time.Now()
+		output, err := fn()
+		attempts = append(attempts, state.Attempt{
+			ExitCode:   exitCode(err),
+			DurationMS: time.Since(start).Milliseconds(),
+			Reason:     failureReason(err),
+		})
 		if err == nil {
-			return i, nil
+			attempts[len(attempts)-1].ExitCode = 0
+			return attempts, nil
+		}
+		if i >= maxAttempts || !p.retryable(err, output) {
+			return attempts, err
+		}
+		select {
+		case <-time.After(p.wait(i)):
+		case <-ctx.Done():
+			return attempts, err
+		}
+	}
+}
+
+// logAttempts writes one line per attempt to the step log when a step was
+// retried, so the run log shows how each attempt ended.
+func (r *Runner) logAttempts(stepID string, sl *logging.StepLogger, attempts []state.Attempt) {
+	if len(attempts) < 2 {
+		return
+	}
+	for i, a := range attempts {
+		d := time.Duration(a.DurationMS) * time.Millisecond
+		sl.Log("attempt %d/%d: exit %d after %s", i+1, len(attempts), a.ExitCode, d)
+	}
+	r.log.Log("[%s] ran %d attempts", stepID, len(attempts))
+}
+
+// cmdResult is what runAttempts reports about a command.
+type cmdResult struct {
+	output   string            // captured stdout; empty unless captured
+	outputs  map[string]string // named outputs written to $PIPE_OUTPUT
+	attempts []state.Attempt
+	stderr   *bytes.Buffer // last attempt's stderr when it should be shown in the UI
+}
+
+// runAttempts runs one command of step (the step itself, a parallel item or a
+// sub-run) under the step's retry policy, giving every attempt a fresh step
+// timeout and an empty $PIPE_OUTPUT file. With capture false stdout goes to
+// the log only and output is empty. A $PIPE_OUTPUT file that cannot be parsed,
+// or stdout that is not valid JSON for output_format: json, fails the command.
+func (r *Runner) runAttempts(step model.Step, spec cmdSpec, sensitive bool, sl *logging.StepLogger, rowID string, capture bool) (cmdResult, error) {
+	var res cmdResult
+	show := shouldShowOutput(step, sensitive, r.verbosity)
+	policy := NewRetryPolicy(step.Retry)
+	timeout := parseTimeout(step.Timeout)
+
+	outputFile, err := newOutputFile()
+	if err != nil {
+		return res, noRetry(err)
+	}
+	defer func() { _ = os.Remove(outputFile) }()
+	spec.outputFile = outputFile
+
+	// stderr is kept for the UI on failure and for on_output_match.
+	var stderrBuf *bytes.Buffer
+	showStderr := r.ui != nil && !sensitive
+	if showStderr || policy.OnOutputMatch != nil {
+		stderrBuf = new(bytes.Buffer)
+	}
+
+	res.attempts, err = policy.Do(r.ctx, func() (string, error) {
+		if stderrBuf != nil {
+			stderrBuf.Reset()
 		}
-		if i < maxAttempts {
-			time.Sleep(time.Duration(i*2) * time.Second)
+		if err := os.Truncate(outputFile, 0); err != nil {
+			return "", noRetry(fmt.Errorf("resetting $PIPE_OUTPUT file: %w", err))
 		}
+		ctx, cancel := r.stepContext(timeout)
+		defer cancel()
+		var execErr error
+		if capture {
+			res.output, execErr = r.execCapture(ctx, spec, sl, show, rowID, stderrBuf)
+		} else {
+			res.output, execErr = "", r.execNoCapture(ctx, spec, sl, show, rowID, stderrBuf)
+		}
+		if execErr != nil && stderrBuf != nil {
+			return res.output + stderrBuf.String(), r.ctxErr(ctx, timeout, execErr)
+		}
+		return res.output, r.ctxErr(ctx, timeout, execErr)
+	})
+	r.logAttempts(rowID, sl, res.attempts)
+	if err == nil {
+		res.outputs, err = readOutputFile(outputFile)
+		if err == nil && capture && step.OutputFormat == model.OutputJSON {
+			_, err = jsonFields(res.output, "")
+		}
+		if err != nil {
+			sl.Log("%v", err)
+		}
+	}
+
+	if showStderr {
+		res.stderr = stderrBuf
 	}
-	return maxAttempts, err
+	return res, err
 }
@@ -1,12 +1,28 @@
 package runner
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"os/exec"
 	"testing"
+	"time"
+
+	"github.com/getpipe-dev/pipe/internal/model"
 )
 
-func TestRetry_ImmediateSuccess(t *testing.T) {
-	attempts, err := Retry(3, func() error { return nil })
+// retryNow is a policy that retries without waiting.
+func retryNow(maxAttempts int) RetryPolicy {
+	return RetryPolicy{MaxAttempts: maxAttempts, Delay: time.Nanosecond}
+}
+
+func do(p RetryPolicy, fn func() error) (int, error) {
+	attempts, err := p.Do(context.Background(), func() (string, error) { return "", fn() })
+	return len(attempts), err
+}
+
+func TestRetryPolicy_ImmediateSuccess(t *testing.T) {
+	attempts, err := do(retryNow(3), func() error { return nil })
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -15,9 +31,9 @@ func TestRetry_ImmediateSuccess(t *testing.T) {
 	}
 }
 
-func TestRetry_FailThenSucceed(t *testing.T) {
+func TestRetryPolicy_FailThenSucceed(t *testing.T) {
 	call := 0
-	attempts, err := Retry(2, func() error {
+	attempts, err := do(retryNow(2), func() error {
 		call++
 		if call == 1 {
 			return errors.New("fail once")
@@ -32,20 +48,20 @@ func TestRetry_FailThenSucceed(t *testing.T) {
 	}
 }
 
-func TestRetry_AllExhausted(t *testing.T) {
-	attempts, err := Retry(1, func() error {
+func TestRetryPolicy_AllExhausted(t *testing.T) {
+	attempts, err := do(retryNow(3), func() error {
 		return errors.New("always fail")
 	})
 	if err == nil {
 		t.Fatal("expected error")
 	}
-	if attempts != 1 {
-		t.Fatalf("expected 1 attempt, got %d", attempts)
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
 	}
 }
 
-func TestRetry_ClampsToOne(t *testing.T) {
-	attempts, err := Retry(0, func() error {
+func TestRetryPolicy_ClampsToOne(t *testing.T) {
+	attempts, err := do(retryNow(0), func() error {
 		return errors.New("fail")
 	})
 	if err == nil {
@@ -56,16 +72,131 @@ func TestRetry_ClampsToOne(t *testing.T) {
 	}
 }
 
-func TestRetry_ReturnsLastError(t *testing.T) {
+func TestRetryPolicy_ReturnsLastError(t *testing.T) {
 	call := 0
-	_, err := Retry(1, func() error {
+	_, err := do(retryNow(2), func() error {
 		call++
-		return errors.New("error from final attempt")
+		return fmt.Errorf("error from attempt %d", call)
 	})
 	if err == nil {
 		t.Fatal("expected error")
 	}
-	if err.Error() != "error from final attempt" {
+	if err.Error() != "error from attempt 2" {
 		t.Fatalf("expected last error, got %q", err.Error())
 	}
 }
+
+func TestRetryPolicy_NoRetry(t *testing.T) {
+	attempts, err := do(retryNow(3), func() error {
+		return noRetry(errCancelled)
+	})
+	if !errors.Is(err, errCancelled) {
+		t.Fatalf("expected errCancelled, got %v", err)
+	}
+	if attempts != 1 {
+		t.Fatalf("expected 1 attempt, got %d", attempts)
+	}
+}
+
+func TestRetryPolicy_Wait(t *testing.T) {
+	tests := []struct {
+		backoff string
+		max     time.Duration
+		want    []time.Duration
+	}{
+		{"constant", 0, []time.Duration{time.Second, time.Second, time.Second}},
+		{"linear", 0, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}},
+		{"", 0, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}},
+		{"exponential", 0, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}},
+		{"exponential", 3 * time.Second, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}},
+	}
+	for _, tt := range tests {
+		p := RetryPolicy{Delay: time.Second, MaxDelay: tt.max, Backoff: tt.backoff}
+		for i, want := range tt.want {
+			if got := p.wait(i + 1); got != want {
+				t.Errorf("%s (max %s): wait(%d) = %s, want %s", tt.backoff, tt.max, i+1, got, want)
+			}
+		}
+	}
+}
+
+func TestRetryPolicy_WaitDoesNotOverflow(t *testing.T) {
+	for _, delay := range []time.Duration{time.Second, time.Hour} {
+		p := RetryPolicy{Delay: delay, Backoff: "exponential"}
+		prev := p.wait(1)
+		for attempt := 2; attempt <= 100; attempt++ {
+			got := p.wait(attempt)
+			if got < prev {
+				t.Fatalf("delay %s: wait(%d) = %s, less than wait(%d) = %s", delay, attempt, got, attempt-1, prev)
+			}
+			prev = got
+		}
+		if prev <= 0 {
+			t.Fatalf("delay %s: wait(100) = %s, want a positive delay", delay, prev)
+		}
+	}
+}
+
+func TestRetryPolicy_Jitter(t *testing.T) {
+	p := RetryPolicy{Delay: time.Second, Backoff: "constant", Jitter: true}
+	for range 50 {
+		if d := p.wait(1); d < 500*time.Millisecond || d > time.Second {
+			t.Fatalf("jittered wait %s outside [500ms, 1s]", d)
+		}
+	}
+}
+
+func TestRetryPolicy_OnExitCodes(t *testing.T) {
+	p := NewRetryPolicy(model.RetryField{Attempts: 3, Delay: "1ms", OnExitCodes: []int{75}})
+	calls := 0
+	attempts, err := p.Do(context.Background(), func() (string, error) {
+		calls++
+		return "", exec.Command("sh", "-c", "exit 2").Run()
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if calls != 1 || len(attempts) != 1 {
+		t.Fatalf("expected a single attempt for non-matching exit code, got %d", calls)
+	}
+	if attempts[0].ExitCode != 2 {
+		t.Fatalf("expected recorded exit code 2, got %d", attempts[0].ExitCode)
+	}
+}
+
+func TestRetryPolicy_OnOutputMatch(t *testing.T) {
+	p := NewRetryPolicy(model.RetryField{Attempts: 3, Delay: "1ms", OnOutputMatch: "connection reset"})
+	calls := 0
+	attempts, err := p.Do(context.Background(), func() (string, error) {
+		calls++
+		if calls < 3 {
+			return "read: connection reset by peer", errors.New("fail")
+		}
+		return "", nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(attempts) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(attempts))
+	}
+
+	calls = 0
+	attempts, _ = p.Do(context.Background(), func() (string, error) {
+		calls++
+		return "permission denied", errors.New("fail")
+	})
+	if len(attempts) != 1 {
+		t.Fatalf("expected no retry when output does not match, got %d attempts", len(attempts))
+	}
+}
+
+func TestRetryPolicy_StopsWhenContextDone(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, Delay: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts, err := p.Do(ctx, func() (string, error) { return "", errors.New("fail") })
+	if err == nil || len(attempts) != 1 {
+		t.Fatalf("expected one attempt and an error, got %d, %v", len(attempts), err)
+	}
+}
@@ -2,15 +2,20 @@ package runner
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"os/signal"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -29,6 +34,7 @@ import (
 var ErrPipelineFailed = fmt.Errorf("pipeline failed")
 
 type Runner struct {
+	ctx       context.Context // bounded by the pipeline timeout and Ctrl-C during Run
 	pipeline  *model.Pipeline
 	state     *state.RunState
 	log       *logging.Logger
@@ -38,6 +44,24 @@ type Runner struct {
 	envMu     sync.Mutex // protects envVars
 	stateMu   sync.Mutex // protects state.Steps and saveState
 	emitMu    sync.Mutex // protects verbose-mode stderr output
+
+	interrupted atomic.Bool // set on SIGINT/SIGTERM
+
+	slots   chan struct{}          // PIPE_MAX_PARALLEL process slots, one per running command
+	parents []string               // pipelines running this one through uses:, outermost first
+	locksMu sync.Mutex             // protects locks
+	locks   map[string]*sync.Mutex // named locks from concurrency_group: and locks:
+
+	graph  *graph.Graph           // set by Run
+	keysMu sync.Mutex             // protects keys and stats
+	keys   map[string]cacheKey    // cache keys computed before each cached step ran
+	stats  map[string]cache.Stats // see recordMiss
+
+	selected map[string]bool // steps a partial run executes, nil for all; see Select
+	reuse    map[string]bool // unselected steps that selected steps depend on
+
+	storeOnce sync.Once
+	store     cache.Store // see cacheStore
 }
 
 func New(p *model.Pipeline, rs *state.RunState, log *logging.Logger, vars map[string]string, statusUI *ui.StatusUI, verbosity int) *Runner {
@@ -46,6 +70,7 @@ func New(p *model.Pipeline, rs *state.RunState, log *logging.Logger, vars map[st
 		env[k] = v
 	}
 	return &Runner{
+		ctx:       context.Background(),
 		pipeline:  p,
 		state:     rs,
 		log:       log,
@@ -126,6 +151,36 @@ func (r *Runner) emitStderrOnError(stepID string, buf *bytes.Buffer) {
 	}
 }
 
+// failureStatus picks the UI status for a failed command of step.
+func failureStatus(step model.Step, err error) ui.Status {
+	switch {
+	case errors.Is(err, errCancelled):
+		return ui.Cancelled
+	case step.ContinueOnError:
+		return ui.AllowedFailure
+	case isTimeout(err):
+		return ui.TimedOut
+	default:
+		return ui.Failed
+	}
+}
+
+// failureState returns the StepState.Status for a failed command.
+func failureState(err error) string {
+	if errors.Is(err, errCancelled) {
+		return "cancelled"
+	}
+	return "failed"
+}
+
+// failureReason returns the StepState.Reason for a failed command.
+func failureReason(err error) string {
+	if isTimeout(err) {
+		return "timeout"
+	}
+	return ""
+}
+
 func (r *Runner) uiStatus(id string, s ui.Status) {
 	if r.ui != nil {
 		r.ui.SetStatus(id, s)
@@ -176,22 +231,71 @@ func (r *Runner) setEnv(key, value string) {
 	r.envVars[key] = value
 }
 
-func (r *Runner) buildEnv() []string {
+// buildEnv returns the environment for one command: the system env, every
+// PIPE_* var, then stepEnv with $VAR references expanded against the former.
+func (r *Runner) buildEnv(stepEnv map[string]string) []string {
 	r.envMu.Lock()
 	defer r.envMu.Unlock()
-	return BuildEnv(r.envVars)
+	env := BuildEnv(r.envVars)
+	for _, k := range slices.Sorted(maps.Keys(stepEnv)) {
+		env = append(env, k+"="+os.Expand(r.renderFieldsLocked(stepEnv[k]), r.lookupEnvLocked))
+	}
+	return env
 }
 
-// stepProcessCount returns the number of concurrent processes a step will spawn.
-func stepProcessCount(step model.Step) int {
-	switch {
-	case step.Run.IsStrings():
-		return len(step.Run.Strings)
-	case step.Run.IsSubRuns():
-		return len(step.Run.SubRuns)
-	default:
-		return 1
+// expandEnv replaces $VAR and ${VAR} in s with PIPE_* or system env values,
+// and {{ .steps.<id>.<field> }} accessors with JSON output fields.
+func (r *Runner) expandEnv(s string) string {
+	r.envMu.Lock()
+	defer r.envMu.Unlock()
+	return os.Expand(r.renderFieldsLocked(s), r.lookupEnvLocked)
+}
+
+// renderFields replaces {{ .steps.<id>.<field> }} accessors in s.
+func (r *Runner) renderFields(s string) string {
+	r.envMu.Lock()
+	defer r.envMu.Unlock()
+	return r.renderFieldsLocked(s)
+}
+
+// lookupEnvLocked resolves a variable name; callers hold envMu.
+func (r *Runner) lookupEnvLocked(key string) string {
+	if v, ok := r.envVars[key]; ok {
+		return v
+	}
+	return os.Getenv(key)
+}
+
+// argv returns the interpreter argv for spec: the step's shell, else the
+// pipeline's, else sh -c, followed by the command itself.
+func (r *Runner) argv(spec cmdSpec) []string {
+	shell := spec.shell
+	if !shell.IsSet() {
+		shell = r.pipeline.Shell
+	}
+	return append(slices.Clone(shell.Command()), r.renderFields(spec.run))
+}
+
+// prepare points cmd at spec's working directory and environment.
+func (r *Runner) prepare(cmd *exec.Cmd, spec cmdSpec) {
+	if spec.dir != "" {
+		cmd.Dir = r.expandEnv(spec.dir)
+	}
+	cmd.Env = r.buildEnv(spec.env)
+	if spec.outputFile != "" {
+		cmd.Env = append(cmd.Env, "PIPE_OUTPUT="+spec.outputFile)
+	}
+}
+
+// maxParallel returns PIPE_MAX_PARALLEL, or the number of CPUs when it is
+// unset or invalid.
+func maxParallel() int {
+	if v := os.Getenv("PIPE_MAX_PARALLEL"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
 	}
+	return runtime.NumCPU()
 }
 
 type stepResult struct {
@@ -214,13 +318,16 @@ func (r *Runner) Run() error {
 	if err != nil {
 		return fmt.Errorf("building dependency graph: %w", err)
 	}
+	r.graph = g
 
-	maxParallel := runtime.NumCPU()
-	if v := os.Getenv("PIPE_MAX_PARALLEL"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil && n > 0 {
-			maxParallel = n
-		}
+	base := r.ctx // hooks run outside the pipeline timeout
+	if d := parseTimeout(r.pipeline.Timeout); d > 0 {
+		var cancel context.CancelFunc
+		r.ctx, cancel = context.WithTimeout(r.ctx, d)
+		defer cancel()
 	}
+	stopSignals := r.handleSignals()
+	defer stopSignals()
 
 	// Identify the interactive step (if any) and exclude it from the DAG dispatch
 	iStep := InteractiveStep(r.pipeline)
@@ -246,52 +353,104 @@ func (r *Runner) Run() error {
 
 	total := len(inDeg)
 	results := make(chan stepResult, total)
-	sem := make(chan struct{}, maxParallel)
-	completed := 0
+	if r.slots == nil { // a nested run shares its parent's slots
+		r.slots = make(chan struct{}, maxParallel())
+	}
+	inFlight := 0
+	dispatched := make(map[string]bool)
 	failed := make(map[string]bool)
-	var failedSteps []string
+	var failedSteps, cancelledSteps, allowedSteps []string
 	var firstErr error
 
+	dispatch := func(id string) {
+		inFlight++
+		dispatched[id] = true
+		go r.workerRun(stepByID[id], results)
+	}
+	// release counts a failed dependency as settled for a step whose if:
+	// checks that dependency's status, so the condition decides instead.
+	release := func(id string) {
+		inDeg[id]--
+		if inDeg[id] == 0 && r.stopErr() == nil {
+			dispatch(id)
+		}
+	}
+
 	// Seed ready steps (in-degree == 0)
 	for _, id := range g.Order {
 		if id == interactiveID {
 			continue
 		}
 		if inDeg[id] == 0 {
-			step := stepByID[id]
-			go r.workerRun(step, sem, results)
+			dispatch(id)
 		}
 	}
 
-	// Dispatch loop
-	for completed < total {
+	// Dispatch loop: runs until no step is in flight. Once the run is
+	// cancelled or times out, no new steps are dispatched.
+	for inFlight > 0 {
 		res := <-results
-		completed++
+		inFlight--
 
-		if res.Err != nil {
+		switch {
+		case errors.Is(res.Err, errCancelled):
+			cancelledSteps = append(cancelledSteps, res.ID)
+			continue
+		case res.Err != nil && !stepByID[res.ID].ContinueOnError:
 			failed[res.ID] = true
 			failedSteps = append(failedSteps, res.ID)
 			if firstErr == nil {
 				firstErr = res.Err
 			}
 			// Cascade-fail all transitive dependents (excluding interactive)
-			r.cascadeFail(res.ID, g, failed, &completed, interactiveID)
-		} else {
-			// Decrement in-degree of dependents, enqueue newly-ready
-			for _, dep := range g.Dependents[res.ID] {
-				if dep == interactiveID || failed[dep] {
-					continue
-				}
-				inDeg[dep]--
-				if inDeg[dep] == 0 {
-					step := stepByID[dep]
-					go r.workerRun(step, sem, results)
-				}
+			r.cascadeFail(res.ID, g, failed, interactiveID, release)
+			continue
+		case res.Err != nil:
+			// continue_on_error: the failure does not cascade
+			allowedSteps = append(allowedSteps, res.ID)
+			r.log.Log("[%s] failed with continue_on_error, dependents still run: %v", res.ID, res.Err)
+		}
+
+		if r.stopErr() != nil {
+			// Run is stopping: leave dependents pending for --resume.
+			continue
+		}
+		// Decrement in-degree of dependents, enqueue newly-ready
+		for _, dep := range g.Dependents[res.ID] {
+			if dep == interactiveID || failed[dep] {
+				continue
 			}
+			inDeg[dep]--
+			if inDeg[dep] == 0 {
+				dispatch(dep)
+			}
+		}
+	}
+	stopSignals()
+
+	if r.interrupted.Load() {
+		if err := r.runHooks(base, "cancelled", failedSteps); err != nil {
+			r.log.Log("%v", err)
+		}
+		return r.markCancelled(cancelledSteps)
+	}
+	if firstErr == nil && len(dispatched)+len(failed) < total {
+		// The pipeline deadline passed between steps; the rest never started.
+		firstErr = r.stopErr()
+	}
+	if len(allowedSteps) > 0 {
+		r.log.Log("pipeline %q allowed failures (continue_on_error): %s", r.pipeline.Name, strings.Join(allowedSteps, ", "))
+		if r.ui == nil {
+			log.Warn(fmt.Sprintf("pipeline %q allowed failures (continue_on_error): %s", r.pipeline.Name, strings.Join(allowedSteps, ", ")))
 		}
 	}
 
 	if firstErr != nil {
+		timedOut := errors.Is(r.ctx.Err(), context.DeadlineExceeded)
+		if err := r.runHooks(base, "failed", failedSteps); err != nil {
+			r.log.Log("%v", err)
+		}
+
 		r.stateMu.Lock()
 		r.state.Status = "failed"
 		now := time.Now()
@@ -299,16 +458,16 @@ func (r *Runner) Run() error {
 		r.saveState()
 		r.stateMu.Unlock()
 
+		if timedOut {
+			r.log.Log("pipeline %q timed out after %s", r.pipeline.Name, r.pipeline.Timeout)
+		}
 		if r.ui == nil {
 			log.Error(fmt.Sprintf("pipeline %q failed steps: %s", r.pipeline.Name, strings.Join(failedSteps, ", ")))
 		}
 		if r.ui != nil {
 			r.ui.Finish()
 		}
-		fmt.Fprintf(os.Stderr,
-			"\n\033[2mPipeline failed. Resume with:\n  pipe %s --resume %s\033[0m\n\n",
-			r.pipeline.Name, r.state.RunID,
-		)
+		r.resumeHint("failed")
 		return ErrPipelineFailed
 	}
 
@@ -317,7 +476,16 @@ func (r *Runner) Run() error {
 		if r.ui != nil {
 			r.ui.Finish()
 		}
-		if err := r.runInteractive(*iStep); err != nil {
+		if err := r.runInteractive(*iStep); err != nil && !iStep.ContinueOnError {
+			if r.ui != nil {
+				r.ui.Detach()
+			}
+			if herr := r.runHooks(base, "failed", []string{iStep.ID}); herr != nil {
+				r.log.Log("%v", herr)
+			}
+			if r.ui != nil {
+				r.ui.Finish()
+			}
 			r.stateMu.Lock()
 			r.state.Status = "failed"
 			now := time.Now()
@@ -328,6 +496,26 @@ func (r *Runner) Run() error {
 		}
 	}
 
+	if iStep != nil && r.ui != nil {
+		r.ui.Detach()
+	}
+	if err := r.runHooks(base, "done", nil); err != nil {
+		r.stateMu.Lock()
+		r.state.Status = "failed"
+		now := time.Now()
+		r.state.FinishedAt = &now
+		r.saveState()
+		r.stateMu.Unlock()
+
+		if r.ui == nil {
+			log.Error(fmt.Sprintf("pipeline %q: %v", r.pipeline.Name, err))
+		} else {
+			r.ui.Finish()
+		}
+		r.resumeHint("failed")
+		return ErrPipelineFailed
+	}
+
 	r.stateMu.Lock()
 	r.state.Status = "done"
 	now := time.Now()
@@ -336,7 +524,7 @@ func (r *Runner) Run() error {
 	r.stateMu.Unlock()
 
 	r.log.Log("pipeline %q completed (run %s)", r.pipeline.Name, r.state.RunID)
-	if r.ui != nil && iStep == nil {
+	if r.ui != nil {
 		r.ui.Finish()
 	}
 	return nil
@@ -351,6 +539,16 @@ func (r *Runner) runInteractive(step model.Step) error {
 		r.log.Log("[%s] skipping interactive (already done)", step.ID)
 		return nil
 	}
+	if !r.isSelected(step.ID) {
+		return r.skipUnselected(step)
+	}
+
+	if ok, err := r.conditionMet(step); err != nil {
+		return fmt.Errorf("step %q: %w", step.ID, err)
+	} else if !ok {
+		r.skipStep(step)
+		return nil
+	}
 
 	r.log.Log("[%s] starting interactive", step.ID)
 	ss.Status = "running"
@@ -361,8 +559,10 @@ func (r *Runner) runInteractive(step model.Step) error {
 	fmt.Fprintf(os.Stderr, "\033[33m●\033[0m %s  \033[33minteractive...\033[0m\n", step.ID)
 	startedAt := time.Now()
 
-	cmd := exec.Command("sh", "-c", step.Run.Single)
-	cmd.Env = r.buildEnv()
+	spec := stepSpec(step, step.Run.Single)
+	argv := r.argv(spec)
+	cmd := exec.Command(argv[0], argv[1:]...)
+	r.prepare(cmd, spec)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -410,6 +610,7 @@ func (r *Runner) runInteractive(step model.Step) error {
 	ss.Status = "done"
 	ss.ExitCode = 0
 	r.setStepState(step.ID, ss)
+	r.collectArtifacts(step)
 	printInteractiveResult(os.Stderr, step.ID, dur, true, startRow, startErr)
 	return nil
 }
@@ -450,9 +651,11 @@ func printInteractiveResult(w io.Writer, id, dur string, success bool, startRow
 }
 
 // cascadeFail marks all transitive dependents of a failed step as failed.
+// A dependent whose if: checks the status or exit code of the failed step is
+// handed to release instead, and the cascade stops there.
 // When excludeID is non-empty, that step is skipped (used to exclude the
 // interactive step from the dispatch-loop cascade counting).
-func (r *Runner) cascadeFail(failedID string, g *graph.Graph, failedSet map[string]bool, completed *int, excludeID string) {
+func (r *Runner) cascadeFail(failedID string, g *graph.Graph, failedSet map[string]bool, excludeID string, release func(id string)) {
 	// BFS through dependents
 	queue := []string{failedID}
 	for len(queue) > 0 {
@@ -462,6 +665,11 @@ func (r *Runner) cascadeFail(failedID string, g *graph.Graph, failedSet map[stri
 			if dep == excludeID || failedSet[dep] {
 				continue
 			}
+			if checksStatus(findStep(r.pipeline.Steps, dep), curr) {
+				r.log.Log("[%s] dependency %q failed, evaluating its condition", dep, curr)
+				release(dep)
+				continue
+			}
 			failedSet[dep] = true
 			r.log.Log("[%s] skipped (dependency %q failed)", dep, failedID)
 			r.uiStatusStep(findStep(r.pipeline.Steps, dep), ui.Failed)
@@ -476,12 +684,28 @@ func (r *Runner) cascadeFail(failedID string, g *graph.Graph, failedSet map[stri
 			r.saveState()
 			r.stateMu.Unlock()
 
-			*completed++
 			queue = append(queue, dep)
 		}
 	}
 }
 
+// failNotStarted records a step that failed before any of its commands
+// started (pipeline timeout, condition error).
+func (r *Runner) failNotStarted(step model.Step, err error) {
+	r.stateMu.Lock()
+	ss := r.state.Steps[step.ID]
+	ss.Status = "failed"
+	ss.ExitCode = exitCode(err)
+	ss.Reason = failureReason(err)
+	ss.AllowedFailure = step.ContinueOnError
+	now := time.Now()
+	ss.At = &now
+	r.state.Steps[step.ID] = ss
+	r.saveState()
+	r.stateMu.Unlock()
+	r.uiStatusStep(step, failureStatus(step, err))
+}
+
 func findStep(steps []model.Step, id string) model.Step {
 	for _, s := range steps {
 		if s.ID == id {
@@ -491,39 +715,66 @@ func findStep(steps []model.Step, id string) model.Step {
 	return model.Step{ID: id}
 }
 
-// workerRun acquires semaphore slots, runs the step, and sends the result.
-func (r *Runner) workerRun(step model.Step, sem chan struct{}, results chan<- stepResult) {
-	slots := stepProcessCount(step)
-	for i := 0; i < slots; i++ {
-		sem <- struct{}{}
-	}
+// workerRun takes the step's named locks, runs the step, and sends the
+// result. Process slots are taken per command inside runStep.
+func (r *Runner) workerRun(step model.Step, results chan<- stepResult) {
+	release := r.acquireLocks(step)
 	err := r.runStep(step)
-	for i := 0; i < slots; i++ {
-		<-sem
-	}
+	release()
 	results <- stepResult{ID: step.ID, Err: err}
 }
 
 // RestoreEnvFromState rebuilds the env map from a previous run's completed steps.
 func (r *Runner) RestoreEnvFromState() {
+	r.restoreEnvFromState(true)
+}
+
+// restoreEnvFromState rebuilds the env map, and with artifacts true copies
+// done steps' artifacts back into their working directories.
+func (r *Runner) restoreEnvFromState(artifacts bool) {
 	for _, step := range r.pipeline.Steps {
 		ss, ok := r.state.Steps[step.ID]
 		if !ok {
 			continue
 		}
-		if ss.Status == "done" && !ss.Sensitive {
-			if ss.Output != "" {
-				r.envVars[EnvKey(step.ID)] = strings.TrimRight(ss.Output, "\n")
-			}
-			for subID, sub := range ss.SubSteps {
-				if sub.Status == "done" && !sub.Sensitive && sub.Output != "" {
-					r.envVars[EnvKey(step.ID, subID)] = strings.TrimRight(sub.Output, "\n")
-				}
+		r.exportState(step, ss)
+		if artifacts && ss.Status == "done" && ss.Artifacts != nil {
+			// Put back what the step produced, in case it was deleted or
+			// overwritten since; re-run the step if that fails.
+			if err := r.restoreArtifacts(step, r.artifactDir(step.ID), ss.Artifacts.Dir, ss.Artifacts.Paths); err != nil {
+				r.log.Log("[%s] cannot restore artifacts, re-running: %v", step.ID, err)
+				ss.Status = "pending"
+				r.state.Steps[step.ID] = ss
 			}
 		}
 	}
 }
 
+// exportState sets the PIPE_* vars a step recorded in ss exports: the stdout
+// and named outputs of the step and of its done items or sub-runs.
+func (r *Runner) exportState(step model.Step, ss state.StepState) {
+	if ss.Status == "done" && !ss.Sensitive && (ss.Output != "" || ss.OutputFile != "") {
+		r.restoreStdout(step, ss, step.ID)
+	}
+	if ss.Status == "done" && !ss.Sensitive {
+		r.exportOutputs(ss.Outputs, step.ID)
+	}
+	if step.Run.IsStrings() {
+		r.exportOutputs(itemOutputs(step, ss), step.ID)
+		return
+	}
+	// Done sub-runs of a failed step are skipped on resume too, so
+	// their outputs are needed whatever the step's status.
+	for subID, sub := range ss.SubSteps {
+		if sub.Status == "done" && !sub.Sensitive && (sub.Output != "" || sub.OutputFile != "") {
+			r.restoreStdout(step, sub, step.ID, subID)
+		}
+		if sub.Status == "done" && !sub.Sensitive {
+			r.exportOutputs(sub.Outputs, step.ID, subID)
+		}
+	}
+}
+
 // tryCache checks if a valid cache entry exists for the step.
 // On hit: restores env vars, updates run state, returns true.
 // On error: logs warning and returns false (graceful degradation).
@@ -532,25 +783,56 @@ func (r *Runner) tryCache(step model.Step) (bool, error) {
 		return false, nil
 	}
 
-	entry, err := cache.Load(step.ID)
+	store := r.cacheStore()
+	entry, misses, err := r.lookupCache(store, step)
 	if err != nil {
 		r.log.Log("[%s] cache warning: %v", step.ID, err)
+		r.recordMiss(step.ID, nil)
 		return false, nil
 	}
-	if !cache.IsValid(entry, time.Now()) {
+	if entry == nil || len(misses) > 0 {
+		for _, m := range misses {
+			r.log.Log("[%s] cache miss: %s", step.ID, m)
+		}
+		r.recordMiss(step.ID, entry)
 		return false, nil
 	}
 
+	var arts *state.Artifacts
+	if len(entry.Artifacts) > 0 {
+		base, err := r.workDir(step)
+		if err == nil {
+			err = r.fetchArtifacts(store, entry)
+		}
+		if err == nil {
+			err = r.restoreArtifacts(step, r.artifactDir(step.ID), base, entry.Artifacts)
+		}
+		if err != nil {
+			r.log.Log("[%s] cache warning: cannot restore artifacts: %v", step.ID, err)
+			r.recordMiss(step.ID, entry)
+			return false, nil
+		}
+		arts = &state.Artifacts{Dir: base, Paths: entry.Artifacts}
+	}
+
 	r.log.Log("[%s] cache hit", step.ID)
+	r.recordHit(store, entry)
 
 	// Restore env vars from cache
+	var file string
 	if !entry.Sensitive {
 		if entry.Output != "" {
-			r.setEnv(EnvKey(step.ID), strings.TrimRight(entry.Output, "\n"))
+			file = r.saveStdout(entry.Output, false, step.ID)
+			r.exportStdout(step, entry.Output, file, step.ID)
 		}
+		r.exportOutputs(entry.Outputs, step.ID)
 		for _, sub := range entry.SubOutputs {
 			if !sub.Sensitive && sub.Output != "" {
-				r.setEnv(EnvKey(step.ID, sub.ID), strings.TrimRight(sub.Output, "\n"))
+				subFile := r.saveStdout(sub.Output, false, step.ID, sub.ID)
+				r.exportStdout(step, sub.Output, subFile, step.ID, sub.ID)
+			}
+			if !sub.Sensitive {
+				r.exportOutputs(sub.Outputs, step.ID, sub.ID)
 			}
 		}
 	}
@@ -562,8 +844,11 @@ func (r *Runner) tryCache(step model.Step) (bool, error) {
 	ss.ExitCode = 0
 	ss.Sensitive = step.Sensitive
 	if !step.Sensitive {
-		ss.Output = entry.Output
+		ss.Output = stateOutput(step, entry.Output, file)
+		ss.OutputFile = file
+		ss.Outputs = entry.Outputs
 	}
+	ss.Artifacts = arts
 	now := time.Now()
 	ss.At = &now
 	r.state.Steps[step.ID] = ss
@@ -573,6 +858,29 @@ func (r *Runner) tryCache(step model.Step) (bool, error) {
 	return true, nil
 }
 
+// lookupCache loads a step's cache entry and checks it against the step's
+// key. misses says why an entry that exists cannot be used, e.g. which
+// upstream step produced a different output; it is empty on a hit. A step
+// without an entry gets nil, nil, nil.
+func (r *Runner) lookupCache(store cache.Store, step model.Step) (entry *cache.Entry, misses []string, err error) {
+	key := r.cacheKey(step)
+	entry, err = store.Load(r.pipeline.Name, step.ID)
+	if err != nil || entry == nil {
+		return nil, nil, err
+	}
+	if !cache.IsValid(entry, time.Now()) {
+		return entry, []string{"entry expired at " + entry.ExpiresAt.Local().Format(time.DateTime)}, nil
+	}
+	if entry.Key != key.key {
+		// Report what changed since the entry was stored.
+		misses = key.parts.Changes(entry.KeyParts)
+		if len(misses) == 0 {
+			misses = []string{"key changed"}
+		}
+	}
+	return entry, misses, nil
+}
+
 // saveCache stores a cache entry for a successfully completed step.
 func (r *Runner) saveCache(step model.Step, entry *cache.Entry) {
 	if !step.Cached.Enabled {
@@ -580,23 +888,38 @@ func (r *Runner) saveCache(step model.Step, entry *cache.Entry) {
 	}
 
 	now := time.Now()
+	key := r.storedKey(step)
+	entry.Pipeline = r.pipeline.Name
+	entry.Key = key.key
+	entry.KeyParts = key.parts
 	entry.CachedAt = now
+	entry.Stats = r.missStats(step.ID)
 
 	expiresAt, err := cache.ParseExpiry(step.Cached.ExpireAfter, now)
 	if err != nil {
-		r.log.Log("[%s] cache warning: invalid expiry %q: %v", step.ID, step.Cached.ExpireAfter, err)
+		r.log.Log("[%s] cache warning: %v", step.ID, err)
 		return
 	}
 	if !expiresAt.IsZero() {
 		entry.ExpiresAt = &expiresAt
 	}
 
-	if err := cache.Save(entry); err != nil {
+	if arts := r.getStepState(step.ID).Artifacts; arts != nil {
+		entry.Artifacts = arts.Paths
+	}
+
+	if err := r.cacheStore().Save(entry, r.artifactDir(step.ID)); err != nil {
 		r.log.Log("[%s] cache warning: %v", step.ID, err)
 	}
 }
 
 func (r *Runner) runStep(step model.Step) error {
+	// Dispatched before the run stopped but never started (e.g. queued on
+	// a lock): cancelled steps stay pending for --resume.
+	if err := r.stopErr(); err != nil {
+		return r.notStarted(step, err)
+	}
+
 	ss := r.getStepState(step.ID)
 
 	// Resume logic: skip done non-sensitive steps
@@ -606,6 +929,25 @@ func (r *Runner) runStep(step model.Step) error {
 		return nil
 	}
 
+	// Partial run: steps left out export earlier outputs instead
+	if !r.isSelected(step.ID) {
+		return r.skipUnselected(step)
+	}
+
+	// Condition check: evaluated just before execution
+	if ok, err := r.conditionMet(step); err != nil {
+		r.failNotStarted(step, err)
+		return fmt.Errorf("step %q: %w", step.ID, err)
+	} else if !ok {
+		r.skipStep(step)
+		return nil
+	}
+
+	// Up-to-date check: sources: and generates:
+	if r.checkUpToDate(step) {
+		return nil
+	}
+
 	// Cache check: before execution
 	if hit, err := r.tryCache(step); err != nil {
 		return err
@@ -620,6 +962,10 @@ func (r *Runner) runStep(step model.Step) error {
 	}
 
 	switch {
+	case step.Uses != "":
+		return r.runUses(step, sl)
+	case step.Foreach != "":
+		return r.runForeach(step, sl)
 	case step.Run.IsSingle():
 		return r.runSingle(step, sl)
 	case step.Run.IsStrings():
@@ -632,66 +978,70 @@ func (r *Runner) runStep(step model.Step) error {
 }
 
 func (r *Runner) runSingle(step model.Step, sl *logging.StepLogger) error {
+	release := r.acquireSlot()
+	defer release()
+	// Queued for a process slot while the run stopped.
+	if err := r.stopErr(); err != nil {
+		return r.notStarted(step, err)
+	}
+
 	ss := r.getStepState(step.ID)
 	ss.Status = "running"
+	ss.Reason = ""
+	ss.AllowedFailure = false
 	r.setStepState(step.ID, ss)
 	r.uiStatus(step.ID, ui.Running)
 
 	sl.Log("%s", step.Run.Single)
 
-	show := shouldShowOutput(step, step.Sensitive, r.verbosity)
-	maxAttempts := step.Retry + 1
-	var output string
-
-	var stderrBuf *bytes.Buffer
-	if r.ui != nil && !step.Sensitive {
-		stderrBuf = new(bytes.Buffer)
-	}
-
-	attempts, err := Retry(maxAttempts, func() error {
-		if stderrBuf != nil {
-			stderrBuf.Reset()
-		}
-		var execErr error
-		output, execErr = r.execCapture(step.Run.Single, sl, show, step.ID, stderrBuf)
-		return execErr
-	})
+	res, err := r.runAttempts(step, stepSpec(step, step.Run.Single), step.Sensitive, sl, step.ID, true)
+	output := res.output
 
 	now := time.Now()
 	ss.At = &now
-	ss.Attempts = attempts
+	ss.Attempts = len(res.attempts)
+	ss.AttemptLog = res.attempts
 
 	if err != nil {
 		code := exitCode(err)
-		ss.Status = "failed"
+		ss.Status = failureState(err)
 		ss.ExitCode = code
+		ss.Reason = failureReason(err)
+		ss.AllowedFailure = step.ContinueOnError
 		r.setStepState(step.ID, ss)
 		sl.Exit(code)
-		r.emitStderrOnError(step.ID, stderrBuf)
-		r.uiStatus(step.ID, ui.Failed)
+		r.emitStderrOnError(step.ID, res.stderr)
+		r.uiStatus(step.ID, failureStatus(step, err))
 		return fmt.Errorf("step %q failed: %w", step.ID, err)
 	}
 
 	ss.Status = "done"
 	ss.ExitCode = 0
 	ss.Sensitive = step.Sensitive
+	ss.Output, ss.OutputFile, ss.Outputs = "", "", nil
+	file := r.saveStdout(output, step.Sensitive, step.ID)
 	if !step.Sensitive {
-		ss.Output = output
+		ss.Output = stateOutput(step, output, file)
+		ss.OutputFile = file
+		ss.Outputs = res.outputs
 	}
 	r.setStepState(step.ID, ss)
 	sl.Exit(0)
 	r.uiStatus(step.ID, ui.Done)
 
-	r.setEnv(EnvKey(step.ID), strings.TrimRight(output, "\n"))
+	r.exportStdout(step, output, file, step.ID)
+	r.exportOutputs(res.outputs, step.ID)
 
-	cacheOutput := output
-	if step.Sensitive {
-		cacheOutput = ""
+	cached := ""
+	if !step.Sensitive {
+		cached = output
 	}
+	r.collectArtifacts(step)
 	r.saveCache(step, &cache.Entry{
 		StepID:    step.ID,
 		ExitCode:  0,
-		Output:    cacheOutput,
+		Output:    cached,
+		Outputs:   ss.Outputs,
 		Sensitive: step.Sensitive,
 		RunType:   "single",
 	})
@@ -699,50 +1049,106 @@ func (r *Runner) runSingle(step model.Step, sl *logging.StepLogger) error {
 	return nil
 }
 
+// runParallelStrings runs each command of a string list concurrently. Items
+// are tracked as sub-steps run_0, run_1, … so --resume only re-runs the ones
+// that did not finish.
 func (r *Runner) runParallelStrings(step model.Step, sl *logging.StepLogger) error {
-	ss := r.getStepState(step.ID)
+	r.stateMu.Lock()
+	ss := r.state.Steps[step.ID]
 	ss.Status = "running"
-	r.setStepState(step.ID, ss)
+	ss.Reason = ""
+	ss.AllowedFailure = false
+	if ss.SubSteps == nil {
+		ss.SubSteps = make(map[string]state.StepState)
+	}
+	r.state.Steps[step.ID] = ss
+	r.saveState()
+	r.stateMu.Unlock()
 
 	var (
-		mu   sync.Mutex
-		errs []string
-		wg   sync.WaitGroup
+		mu        sync.Mutex
+		errs      []string
+		cancelled bool
+		wg        sync.WaitGroup
+		limit     = stepLimiter(step)
 	)
 
-	show := shouldShowOutput(step, step.Sensitive, r.verbosity)
-
 	for i, cmd := range step.Run.Strings {
+		itemID := fmt.Sprintf("run_%d", i)
+		rowID := step.ID + "/" + itemID
+		// Resume: skip done items of non-sensitive steps
+		mu.Lock()
+		existing := ss.SubSteps[itemID]
+		mu.Unlock()
+		if existing.Status == "done" && !step.Sensitive {
+			r.log.Log("[%s] skipping (already done)", rowID)
+			r.uiStatus(rowID, ui.Done)
+			continue
+		}
+
 		wg.Add(1)
-		go func(idx int, c string) {
+		go func(itemID, rowID, c string) {
 			defer wg.Done()
-			rowID := fmt.Sprintf("%s/run_%d", step.ID, idx)
+			defer r.acquireItem(limit)()
+			// Queued for a slot while the run stopped: leave it pending.
+			if err := r.stopErr(); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("%s: %v", c, err))
+				cancelled = cancelled || errors.Is(err, errCancelled)
+				mu.Unlock()
+				return
+			}
 			r.uiStatus(rowID, ui.Running)
 			sl.Log("parallel: %s", c)
 
-			var stderrBuf *bytes.Buffer
-			if r.ui != nil && !step.Sensitive {
-				stderrBuf = new(bytes.Buffer)
-			}
+			res, err := r.runAttempts(step, stepSpec(step, c), step.Sensitive, sl, rowID, false)
 
-			if err := r.execNoCapture(c, sl, show, rowID, stderrBuf); err != nil {
-				mu.Lock()
+			mu.Lock()
+			defer mu.Unlock()
+
+			now := time.Now()
+			itemState := state.StepState{At: &now, Attempts: len(res.attempts), AttemptLog: res.attempts}
+
+			if err != nil {
+				itemState.Status = failureState(err)
+				itemState.ExitCode = exitCode(err)
+				itemState.Reason = failureReason(err)
+				ss.SubSteps[itemID] = itemState
 				errs = append(errs, fmt.Sprintf("%s: %v", c, err))
-				mu.Unlock()
-				r.emitStderrOnError(rowID, stderrBuf)
-				r.uiStatus(rowID, ui.Failed)
+				if isTimeout(err) {
+					ss.Reason = "timeout"
+				}
+				if errors.Is(err, errCancelled) {
+					cancelled = true
+				}
+				r.emitStderrOnError(rowID, res.stderr)
+				r.uiStatus(rowID, failureStatus(step, err))
 			} else {
+				itemState.Status = "done"
+				itemState.Sensitive = step.Sensitive
+				if !step.Sensitive {
+					itemState.Outputs = res.outputs
+				}
+				ss.SubSteps[itemID] = itemState
+				// Items share the step's namespace: PIPE_<STEP>_<KEY>.
+				r.exportOutputs(res.outputs, step.ID)
 				r.uiStatus(rowID, ui.Done)
 			}
-		}(i, cmd)
+		}(itemID, rowID, cmd)
 	}
 	wg.Wait()
 
 	now := time.Now()
 	ss.At = &now
 
+	if cancelled {
+		ss.Status = "cancelled"
+		r.setStepState(step.ID, ss)
+		return fmt.Errorf("step %q: %w", step.ID, errCancelled)
+	}
 	if len(errs) > 0 {
 		ss.Status = "failed"
+		ss.AllowedFailure = step.ContinueOnError
 		r.setStepState(step.ID, ss)
 		return fmt.Errorf("step %q parallel failures: %s", step.ID, strings.Join(errs, "; "))
 	}
@@ -751,8 +1157,10 @@ func (r *Runner) runParallelStrings(step model.Step, sl *logging.StepLogger) err
 	ss.ExitCode = 0
 	r.setStepState(step.ID, ss)
 
+	r.collectArtifacts(step)
 	r.saveCache(step, &cache.Entry{
 		StepID:  step.ID,
+		Outputs: itemOutputs(step, ss),
 		RunType: "strings",
 	})
 
@@ -760,9 +1168,19 @@ func (r *Runner) runParallelStrings(step model.Step, sl *logging.StepLogger) err
 }
 
 func (r *Runner) runParallelSubRuns(step model.Step, _ *logging.StepLogger) error {
+	return r.runSubRuns(step, step.Run.SubRuns)
+}
+
+// runSubRuns runs subs concurrently, at most max_parallel at a time when set
+// and never more than the free process slots. Each sub-run is tracked in the
+// step's SubSteps and its output exported as PIPE_<STEP>_<SUB>; done sub-runs
+// are skipped on resume.
+func (r *Runner) runSubRuns(step model.Step, subs []model.SubRun) error {
 	r.stateMu.Lock()
 	ss := r.state.Steps[step.ID]
 	ss.Status = "running"
+	ss.Reason = ""
+	ss.AllowedFailure = false
 	if ss.SubSteps == nil {
 		ss.SubSteps = make(map[string]state.StepState)
 	}
@@ -771,13 +1189,17 @@ func (r *Runner) runParallelSubRuns(step model.Step, _ *logging.StepLogger) erro
 	r.stateMu.Unlock()
 
 	var (
-		mu   sync.Mutex
-		errs []string
-		wg   sync.WaitGroup
+		mu        sync.Mutex
+		errs      []string
+		cancelled bool
+		wg        sync.WaitGroup
+		limit     = stepLimiter(step)
 	)
 
-	for _, sub := range step.Run.SubRuns {
+	for _, sub := range subs {
+		mu.Lock()
 		existing := ss.SubSteps[sub.ID]
+		mu.Unlock()
 		// Resume: skip done non-sensitive sub-runs
 		if existing.Status == "done" && !sub.Sensitive {
 			r.log.Log("[%s/%s] skipping (already done)", step.ID, sub.ID)
@@ -788,6 +1210,15 @@ func (r *Runner) runParallelSubRuns(step model.Step, _ *logging.StepLogger) erro
 		wg.Add(1)
 		go func(sr model.SubRun) {
 			defer wg.Done()
+			defer r.acquireItem(limit)()
+			// Queued for a slot while the run stopped: leave it pending.
+			if err := r.stopErr(); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("%s: %v", sr.ID, err))
+				cancelled = cancelled || errors.Is(err, errCancelled)
+				mu.Unlock()
+				return
+			}
 			rowID := step.ID + "/" + sr.ID
 			r.uiStatus(rowID, ui.Running)
 			subSl := r.log.Step(rowID, sr.Sensitive)
@@ -796,38 +1227,40 @@ func (r *Runner) runParallelSubRuns(step model.Step, _ *logging.StepLogger) erro
 			}
 			subSl.Log("%s", sr.Run)
 
-			var stderrBuf *bytes.Buffer
-			if r.ui != nil && !sr.Sensitive {
-				stderrBuf = new(bytes.Buffer)
-			}
-
-			show := shouldShowOutput(step, sr.Sensitive, r.verbosity)
-			output, err := r.execCapture(sr.Run, subSl, show, rowID, stderrBuf)
+			res, err := r.runAttempts(step, subRunSpec(step, sr), sr.Sensitive, subSl, rowID, true)
 
 			mu.Lock()
 			defer mu.Unlock()
 
 			now := time.Now()
-			subState := state.StepState{At: &now}
+			subState := state.StepState{At: &now, Attempts: len(res.attempts), AttemptLog: res.attempts}
 
 			if err != nil {
 				code := exitCode(err)
-				subState.Status = "failed"
+				subState.Status = failureState(err)
 				subState.ExitCode = code
+				subState.Reason = failureReason(err)
 				ss.SubSteps[sr.ID] = subState
 				errs = append(errs, fmt.Sprintf("%s: %v", sr.ID, err))
+				if errors.Is(err, errCancelled) {
+					cancelled = true
+				}
 				subSl.Exit(code)
-				r.emitStderrOnError(rowID, stderrBuf)
-				r.uiStatus(rowID, ui.Failed)
+				r.emitStderrOnError(rowID, res.stderr)
+				r.uiStatus(rowID, failureStatus(step, err))
 			} else {
 				subState.Status = "done"
 				subState.ExitCode = 0
 				subState.Sensitive = sr.Sensitive
+				file := r.saveStdout(res.output, sr.Sensitive, step.ID, sr.ID)
 				if !sr.Sensitive {
-					subState.Output = output
+					subState.Output = stateOutput(step, res.output, file)
+					subState.OutputFile = file
+					subState.Outputs = res.outputs
 				}
 				ss.SubSteps[sr.ID] = subState
-				r.setEnv(EnvKey(step.ID, sr.ID), strings.TrimRight(output, "\n"))
+				r.exportStdout(step, res.output, file, step.ID, sr.ID)
+				r.exportOutputs(res.outputs, step.ID, sr.ID)
 				subSl.Exit(0)
 				r.uiStatus(rowID, ui.Done)
 			}
@@ -838,8 +1271,14 @@ func (r *Runner) runParallelSubRuns(step model.Step, _ *logging.StepLogger) erro
 	now := time.Now()
 	ss.At = &now
 
+	if cancelled {
+		ss.Status = "cancelled"
+		r.setStepState(step.ID, ss)
+		return fmt.Errorf("step %q: %w", step.ID, errCancelled)
+	}
 	if len(errs) > 0 {
 		ss.Status = "failed"
+		ss.AllowedFailure = step.ContinueOnError
 		r.setStepState(step.ID, ss)
 		return fmt.Errorf("step %q sub-run failures: %s", step.ID, strings.Join(errs, "; "))
 	}
@@ -850,15 +1289,21 @@ func (r *Runner) runParallelSubRuns(step model.Step, _ *logging.StepLogger) erro
 
 	// Build sub-outputs for cache
 	var subOutputs []cache.SubEntry
-	for _, sr := range step.Run.SubRuns {
+	for _, sr := range subs {
 		sub := ss.SubSteps[sr.ID]
+		output, err := storedOutput(sub)
+		if err != nil {
+			r.log.Log("[%s/%s] cache warning: %v", step.ID, sr.ID, err)
+		}
 		subOutputs = append(subOutputs, cache.SubEntry{
 			ID:        sr.ID,
-			Output:    sub.Output,
+			Output:    output,
+			Outputs:   sub.Outputs,
 			Sensitive: sub.Sensitive,
 			ExitCode:  sub.ExitCode,
 		})
 	}
+	r.collectArtifacts(step)
 	r.saveCache(step, &cache.Entry{
 		StepID:     step.ID,
 		Sensitive:  step.Sensitive,
@@ -869,9 +1314,9 @@ func (r *Runner) runParallelSubRuns(step model.Step, _ *logging.StepLogger) erro
 	return nil
 }
 
-func (r *Runner) execCapture(cmdStr string, sl *logging.StepLogger, showOutput bool, stepID string, stderrBuf *bytes.Buffer) (string, error) {
-	cmd := exec.Command("sh", "-c", cmdStr)
-	cmd.Env = r.buildEnv()
+func (r *Runner) execCapture(ctx context.Context, spec cmdSpec, sl *logging.StepLogger, showOutput bool, stepID string, stderrBuf *bytes.Buffer) (string, error) {
+	cmd := command(ctx, r.argv(spec))
+	r.prepare(cmd, spec)
 	var stdout bytes.Buffer
 
 	if showOutput {
@@ -891,9 +1336,9 @@ func (r *Runner) execCapture(cmdStr string, sl *logging.StepLogger, showOutput b
 	return stdout.String(), err
 }
 
-func (r *Runner) execNoCapture(cmdStr string, sl *logging.StepLogger, showOutput bool, stepID string, stderrBuf *bytes.Buffer) error {
-	cmd := exec.Command("sh", "-c", cmdStr)
-	cmd.Env = r.buildEnv()
+func (r *Runner) execNoCapture(ctx context.Context, spec cmdSpec, sl *logging.StepLogger, showOutput bool, stepID string, stderrBuf *bytes.Buffer) error {
+	cmd := command(ctx, r.argv(spec))
+	r.prepare(cmd, spec)
 
 	if showOutput {
 		emit, flushOutput := r.outputEmitter(stepID)
@@ -912,7 +1357,14 @@ func (r *Runner) execNoCapture(cmdStr string, sl *logging.StepLogger, showOutput
 }
 
 func exitCode(err error) int {
-	if ee, ok := err.(*exec.ExitError); ok {
+	if errors.Is(err, errCancelled) {
+		return cancelledExitCode
+	}
+	if isTimeout(err) {
+		return timeoutExitCode
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
 		return ee.ExitCode()
 	}
 	return 1
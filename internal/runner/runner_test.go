@@ -0,0 +1,1277 @@
+package runner
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/getpipe-dev/pipe/internal/cache"
+	"github.com/getpipe-dev/pipe/internal/config"
+	"github.com/getpipe-dev/pipe/internal/logging"
+	"github.com/getpipe-dev/pipe/internal/model"
+	"github.com/getpipe-dev/pipe/internal/state"
+)
+
+// newTestRunner points the state, log and cache directories at temp dirs
+// and returns a verbose-mode Runner for p with a fresh run state.
+func newTestRunner(t *testing.T, p *model.Pipeline) (*Runner, *state.RunState) {
+	t.Helper()
+	origState, origLog, origCache := config.StateDir, config.LogDir, config.CacheDir
+	origOutput, origArtifact := config.OutputDir, config.ArtifactDir
+	config.StateDir = t.TempDir()
+	config.LogDir = t.TempDir()
+	config.CacheDir = t.TempDir()
+	config.OutputDir = t.TempDir()
+	config.ArtifactDir = t.TempDir()
+	t.Cleanup(func() {
+		config.StateDir, config.LogDir, config.CacheDir = origState, origLog, origCache
+		config.OutputDir, config.ArtifactDir = origOutput, origArtifact
+	})
+	if err := config.EnsureDirs(p.Name); err != nil {
+		t.Fatalf("EnsureDirs: %v", err)
+	}
+
+	rs := state.NewRunState(p.Name)
+	log, err := logging.New(p.Name, rs.RunID, logging.FileOnly())
+	if err != nil {
+		t.Fatalf("logging.New: %v", err)
+	}
+	t.Cleanup(func() { _ = log.Close() })
+
+	return New(p, rs, log, nil, nil, 0), rs
+}
+
+func TestRun_StepTimeout(t *testing.T) {
+	p := &model.Pipeline{
+		Name: "test-step-timeout",
+		Steps: []model.Step{
+			{ID: "hang", Run: model.RunField{Single: "sleep 5"}, Timeout: "200ms"},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+
+	if err := r.Run(); !errors.Is(err, ErrPipelineFailed) {
+		t.Fatalf("expected ErrPipelineFailed, got %v", err)
+	}
+	ss := rs.Steps["hang"]
+	if ss.Status != "failed" {
+		t.Fatalf("expected status=failed, got %q", ss.Status)
+	}
+	if ss.Reason != "timeout" {
+		t.Fatalf("expected reason=timeout, got %q", ss.Reason)
+	}
+	if ss.ExitCode != timeoutExitCode {
+		t.Fatalf("expected exit code %d, got %d", timeoutExitCode, ss.ExitCode)
+	}
+}
+
+func TestRun_StepTimeoutNotHit(t *testing.T) {
+	p := &model.Pipeline{
+		Name: "test-step-timeout-ok",
+		Steps: []model.Step{
+			{ID: "quick", Run: model.RunField{Single: "echo ok"}, Timeout: "5s"},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if ss := rs.Steps["quick"]; ss.Status != "done" || ss.Reason != "" {
+		t.Fatalf("expected done with no reason, got status=%q reason=%q", ss.Status, ss.Reason)
+	}
+}
+
+func TestRun_PipelineTimeoutSkipsRetry(t *testing.T) {
+	p := &model.Pipeline{
+		Name:    "test-pipeline-timeout",
+		Timeout: "200ms",
+		Steps: []model.Step{
+			{ID: "hang", Run: model.RunField{Single: "sleep 5"}, Retry: model.RetryField{Attempts: 2}},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+
+	if err := r.Run(); !errors.Is(err, ErrPipelineFailed) {
+		t.Fatalf("expected ErrPipelineFailed, got %v", err)
+	}
+	ss := rs.Steps["hang"]
+	if ss.Reason != "timeout" {
+		t.Fatalf("expected reason=timeout, got %q", ss.Reason)
+	}
+	if ss.Attempts != 1 {
+		t.Fatalf("expected 1 attempt (pipeline timeout is not retried), got %d", ss.Attempts)
+	}
+}
+
+func TestRun_TimeoutEscalatesToKill(t *testing.T) {
+	orig := killGrace
+	killGrace = 100 * time.Millisecond
+	t.Cleanup(func() { killGrace = orig })
+
+	p := &model.Pipeline{
+		Name: "test-timeout-kill",
+		Steps: []model.Step{
+			{ID: "stubborn", Run: model.RunField{Single: "trap '' TERM; sleep 5"}, Timeout: "200ms"},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+
+	start := time.Now()
+	if err := r.Run(); !errors.Is(err, ErrPipelineFailed) {
+		t.Fatalf("expected ErrPipelineFailed, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("expected SIGKILL after grace period, step ran for %s", elapsed)
+	}
+	if ss := rs.Steps["stubborn"]; ss.Reason != "timeout" {
+		t.Fatalf("expected reason=timeout, got %q", ss.Reason)
+	}
+}
+
+func TestRun_InterruptCancelsRun(t *testing.T) {
+	p := &model.Pipeline{
+		Name: "test-interrupt",
+		Steps: []model.Step{
+			{ID: "slow", Run: model.RunField{Single: "sleep 5 & wait"}},
+			{ID: "after", Run: model.RunField{Single: "echo after"}, DependsOn: model.DependsOnField{Steps: []string{"slow"}}},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+
+	time.AfterFunc(200*time.Millisecond, func() {
+		_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	})
+	start := time.Now()
+	if err := r.Run(); !errors.Is(err, ErrPipelineCancelled) {
+		t.Fatalf("expected ErrPipelineCancelled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("expected process group to be killed promptly, run took %s", elapsed)
+	}
+	if rs.Status != "cancelled" {
+		t.Fatalf("expected run status=cancelled, got %q", rs.Status)
+	}
+	if ss := rs.Steps["slow"]; ss.Status != "cancelled" {
+		t.Fatalf("expected slow status=cancelled, got %q", ss.Status)
+	}
+	if _, ok := rs.Steps["after"]; ok {
+		t.Fatalf("expected dependent step to stay pending, got %+v", rs.Steps["after"])
+	}
+}
+
+func TestRun_PipelineTimeoutStopsDispatch(t *testing.T) {
+	p := &model.Pipeline{
+		Name:    "test-timeout-dispatch",
+		Timeout: "300ms",
+		Steps: []model.Step{
+			{ID: "first", Run: model.RunField{Single: "sleep 0.5; true"}, Timeout: "5s"},
+			{ID: "second", Run: model.RunField{Single: "echo second"}, DependsOn: model.DependsOnField{Steps: []string{"first"}}},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+
+	if err := r.Run(); !errors.Is(err, ErrPipelineFailed) {
+		t.Fatalf("expected ErrPipelineFailed, got %v", err)
+	}
+	if rs.Status != "failed" {
+		t.Fatalf("expected run status=failed, got %q", rs.Status)
+	}
+	if _, ok := rs.Steps["second"]; ok && rs.Steps["second"].Status == "done" {
+		t.Fatal("expected second step not to run after the pipeline deadline")
+	}
+}
+
+func TestRun_ConditionFalseSkipsStep(t *testing.T) {
+	p := &model.Pipeline{
+		Name: "test-condition",
+		Steps: []model.Step{
+			{ID: "deploy", Run: model.RunField{Single: "echo deployed"}, If: `PIPE_VAR_ENV == "prod"`},
+			{ID: "notify", Run: model.RunField{Single: "echo notified"}, DependsOn: model.DependsOnField{Steps: []string{"deploy"}}},
+			{ID: "audit", Run: model.RunField{Single: "echo audit"}, If: `steps.deploy.status == "skipped"`},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+	r.setEnv("PIPE_VAR_ENV", "staging")
+
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if ss := rs.Steps["deploy"]; ss.Status != "skipped" {
+		t.Fatalf("expected deploy status=skipped, got %q", ss.Status)
+	}
+	if ss := rs.Steps["notify"]; ss.Status != "done" {
+		t.Fatalf("expected dependent of skipped step to run, got %q", ss.Status)
+	}
+	if ss := rs.Steps["audit"]; ss.Status != "done" {
+		t.Fatalf("expected audit to run after deploy was skipped, got %q", ss.Status)
+	}
+	if _, ok := r.envVars["PIPE_DEPLOY"]; ok {
+		t.Fatal("expected no PIPE_DEPLOY output for skipped step")
+	}
+}
+
+func TestRun_ConditionTrueRunsStep(t *testing.T) {
+	p := &model.Pipeline{
+		Name: "test-condition-true",
+		Steps: []model.Step{
+			{ID: "deploy", Run: model.RunField{Single: "echo deployed"}, If: `PIPE_VAR_ENV == "prod"`},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+	r.setEnv("PIPE_VAR_ENV", "prod")
+
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if ss := rs.Steps["deploy"]; ss.Status != "done" || ss.Output != "deployed\n" {
+		t.Fatalf("expected deploy done with output, got status=%q output=%q", ss.Status, ss.Output)
+	}
+}
+
+func TestRun_ConditionOnFailedStepIsEvaluated(t *testing.T) {
+	p := &model.Pipeline{
+		Name: "test-condition-failed",
+		Steps: []model.Step{
+			{ID: "deploy", Run: model.RunField{Single: "exit 4"}},
+			{ID: "rollback", Run: model.RunField{Single: "echo rolled back"}, If: `steps.deploy.status == "failed" && steps.deploy.exit_code == 4`},
+			{ID: "announce", Run: model.RunField{Single: "echo announced"}, If: `steps.deploy.status == "done"`},
+			{ID: "notify", Run: model.RunField{Single: "echo notified"}, DependsOn: model.DependsOnField{Steps: []string{"deploy"}}},
+			{ID: "report", Run: model.RunField{Single: "echo reported"}, DependsOn: model.DependsOnField{Steps: []string{"rollback"}}},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+
+	if err := r.Run(); err == nil {
+		t.Fatal("expected the run to fail")
+	}
+	want := map[string]string{
+		"deploy":   "failed",
+		"rollback": "done",
+		"announce": "skipped",
+		"notify":   "failed",
+		"report":   "done",
+	}
+	for id, status := range want {
+		if got := rs.Steps[id].Status; got != status {
+			t.Errorf("expected %s status=%s, got %q", id, status, got)
+		}
+	}
+}
+
+func TestRun_ContinueOnErrorDoesNotCascade(t *testing.T) {
+	p := &model.Pipeline{
+		Name: "test-continue-on-error",
+		Steps: []model.Step{
+			{ID: "notify", Run: model.RunField{Single: "exit 3"}, ContinueOnError: true},
+			{ID: "deploy", Run: model.RunField{Single: "echo deployed"}, DependsOn: model.DependsOnField{Steps: []string{"notify"}}},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if rs.Status != "done" {
+		t.Fatalf("expected run status=done, got %q", rs.Status)
+	}
+	ss := rs.Steps["notify"]
+	if ss.Status != "failed" || !ss.AllowedFailure || ss.ExitCode != 3 {
+		t.Fatalf("expected notify failed+allowed with exit 3, got %+v", ss)
+	}
+	if ss := rs.Steps["deploy"]; ss.Status != "done" {
+		t.Fatalf("expected deploy to run, got %q", ss.Status)
+	}
+}
+
+func TestRun_FailureWithoutContinueOnErrorCascades(t *testing.T) {
+	p := &model.Pipeline{
+		Name: "test-cascade",
+		Steps: []model.Step{
+			{ID: "build", Run: model.RunField{Single: "exit 1"}},
+			{ID: "deploy", Run: model.RunField{Single: "echo deployed"}, DependsOn: model.DependsOnField{Steps: []string{"build"}}},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+
+	if err := r.Run(); !errors.Is(err, ErrPipelineFailed) {
+		t.Fatalf("expected ErrPipelineFailed, got %v", err)
+	}
+	if ss := rs.Steps["deploy"]; ss.Status != "failed" {
+		t.Fatalf("expected deploy cascade-failed, got %q", ss.Status)
+	}
+}
+
+func TestRun_RetryRecordsAttempts(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "tried")
+	p := &model.Pipeline{
+		Name: "test-retry-attempts",
+		Steps: []model.Step{
+			{
+				ID:    "flaky",
+				Run:   model.RunField{Single: "if [ -f " + marker + " ]; then echo ok; else touch " + marker + "; echo '503 unavailable' >&2; exit 75; fi"},
+				Retry: model.RetryField{Attempts: 2, Delay: "10ms", OnExitCodes: []int{75}, OnOutputMatch: "503"},
+			},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	ss := rs.Steps["flaky"]
+	if ss.Status != "done" || ss.Attempts != 2 {
+		t.Fatalf("expected done after 2 attempts, got %+v", ss)
+	}
+	if len(ss.AttemptLog) != 2 || ss.AttemptLog[0].ExitCode != 75 || ss.AttemptLog[1].ExitCode != 0 {
+		t.Fatalf("unexpected attempt log: %+v", ss.AttemptLog)
+	}
+}
+
+func TestRun_ParallelStringsResumeOnlyFailedItems(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "ran")
+	p := &model.Pipeline{
+		Name: "test-strings-resume",
+		Steps: []model.Step{
+			{ID: "build", Run: model.RunField{Strings: []string{
+				"echo a >> " + log,
+				"echo b >> " + log + "; [ -f " + filepath.Join(dir, "fixed") + " ]",
+			}}},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+	if err := r.Run(); err == nil {
+		t.Fatal("expected first run to fail")
+	}
+	if ss := rs.Steps["build"]; ss.SubSteps["run_0"].Status != "done" || ss.SubSteps["run_1"].Status != "failed" {
+		t.Fatalf("unexpected sub-steps after first run: %+v", ss.SubSteps)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "fixed"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r = New(p, rs, r.log, nil, nil, 0)
+	if err := r.Run(); err != nil {
+		t.Fatalf("resume error: %v", err)
+	}
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(data), "a\n"); got != 1 {
+		t.Fatalf("expected the done item to run once, ran %d times", got)
+	}
+	if got := strings.Count(string(data), "b\n"); got != 2 {
+		t.Fatalf("expected the failed item to re-run, ran %d times", got)
+	}
+}
+
+func TestRun_RetryParallelItems(t *testing.T) {
+	dir := t.TempDir()
+	p := &model.Pipeline{
+		Name: "test-parallel-retry",
+		Steps: []model.Step{
+			{
+				ID: "fetch",
+				Run: model.RunField{SubRuns: []model.SubRun{
+					{ID: "flaky", Run: "if [ -f " + filepath.Join(dir, "m") + " ]; then echo ok; else touch " + filepath.Join(dir, "m") + "; exit 1; fi"},
+					{ID: "steady", Run: "echo ok"},
+				}},
+				Retry: model.RetryField{Attempts: 1, Delay: "10ms"},
+			},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	subs := rs.Steps["fetch"].SubSteps
+	if subs["flaky"].Status != "done" || subs["flaky"].Attempts != 2 {
+		t.Fatalf("expected flaky to succeed on retry, got %+v", subs["flaky"])
+	}
+	if subs["steady"].Attempts != 1 {
+		t.Fatalf("expected steady to run once, got %+v", subs["steady"])
+	}
+}
+
+func TestRun_StepDirAndEnv(t *testing.T) {
+	work := t.TempDir()
+	if err := os.Mkdir(filepath.Join(work, "api"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	p := &model.Pipeline{
+		Name: "test-dir-env",
+		Steps: []model.Step{
+			{ID: "version", Run: model.RunField{Single: "echo 1.2.3"}},
+			{
+				ID:  "build",
+				Run: model.RunField{Single: `echo "$(basename "$PWD") $VERSION $MODE"`},
+				Dir: filepath.Join(work, "api"),
+				Env: map[string]string{"VERSION": "v$PIPE_VERSION", "MODE": "release"},
+			},
+			{
+				ID:        "check",
+				Run:       model.RunField{Single: `echo "[$VERSION]"`},
+				DependsOn: model.DependsOnField{Steps: []string{"build"}},
+			},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if got := rs.Steps["build"].Output; got != "api v1.2.3 release\n" {
+		t.Fatalf("unexpected build output %q", got)
+	}
+	if got := rs.Steps["check"].Output; got != "[]\n" {
+		t.Fatalf("step env leaked into another step: %q", got)
+	}
+	if _, ok := r.envVars["PIPE_VERSION"]; !ok {
+		t.Fatal("expected PIPE_VERSION to be exported")
+	}
+	if _, ok := r.envVars["VERSION"]; ok {
+		t.Fatal("step env must not be exported")
+	}
+}
+
+func TestSubRunSpec(t *testing.T) {
+	step := model.Step{ID: "svc", Dir: "services", Env: map[string]string{"A": "1", "B": "2"}}
+	spec := subRunSpec(step, model.SubRun{ID: "api", Run: "make", Dir: "api", Env: map[string]string{"B": "3"}})
+	if spec.dir != filepath.Join("services", "api") {
+		t.Fatalf("unexpected dir %q", spec.dir)
+	}
+	if spec.env["A"] != "1" || spec.env["B"] != "3" {
+		t.Fatalf("unexpected env %v", spec.env)
+	}
+	if step.Env["B"] != "2" {
+		t.Fatal("sub-run env must not modify the step env")
+	}
+}
+
+func TestRun_Shell(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not installed")
+	}
+	p := &model.Pipeline{
+		Name:  "test-shell",
+		Shell: model.ShellField{Argv: []string{"bash", "-euo", "pipefail", "-c"}},
+		Steps: []model.Step{
+			{ID: "strict", Run: model.RunField{Single: "false | cat\necho unreachable"}},
+			{ID: "posix", Run: model.RunField{Single: "false | cat\necho reached"}, Shell: model.ShellField{Name: "sh"}},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+	if err := r.Run(); err == nil {
+		t.Fatal("expected strict step to fail")
+	}
+	if ss := rs.Steps["strict"]; ss.Status != "failed" {
+		t.Fatalf("expected pipefail to fail the step, got %+v", ss)
+	}
+	if ss := rs.Steps["posix"]; ss.Status != "done" || ss.Output != "reached\n" {
+		t.Fatalf("expected step shell to override the pipeline shell, got %+v", ss)
+	}
+}
+
+func TestRun_MatrixOutputs(t *testing.T) {
+	step := model.Step{
+		ID:     "build",
+		Run:    model.RunField{Single: "echo $GOOS/$GOARCH"},
+		Matrix: model.MatrixField{Axes: []model.MatrixAxis{{Name: "goos", Values: []string{"linux", "darwin"}}, {Name: "goarch", Values: []string{"arm64"}}}},
+	}
+	if err := model.ExpandMatrix(&step); err != nil {
+		t.Fatal(err)
+	}
+	p := &model.Pipeline{Name: "test-matrix", Steps: []model.Step{step}}
+	r, rs := newTestRunner(t, p)
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if got := r.envVars["PIPE_BUILD_DARWIN_ARM64"]; got != "darwin/arm64" {
+		t.Fatalf("expected per-combination output, got %q", got)
+	}
+	if ss := rs.Steps["build"].SubSteps["linux-arm64"]; ss.Status != "done" {
+		t.Fatalf("expected per-combination state, got %+v", ss)
+	}
+}
+
+func TestRun_FinallyRunsAfterFailure(t *testing.T) {
+	p := &model.Pipeline{
+		Name: "test-finally",
+		Steps: []model.Step{
+			{ID: "deploy", Run: model.RunField{Single: "exit 1"}},
+			{ID: "verify", Run: model.RunField{Single: "echo verified"}, DependsOn: model.DependsOnField{Steps: []string{"deploy"}}},
+		},
+		OnSuccess: []model.Step{{ID: "notify_ok", Run: model.RunField{Single: "echo ok"}}},
+		OnFailure: []model.Step{{ID: "notify_fail", Run: model.RunField{Single: "echo $PIPE_RUN_STATUS"}}},
+		Finally: []model.Step{
+			{ID: "broken", Run: model.RunField{Single: "exit 3"}},
+			{ID: "teardown", Run: model.RunField{Single: "echo $PIPE_FAILED_STEPS"}},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+
+	if err := r.Run(); !errors.Is(err, ErrPipelineFailed) {
+		t.Fatalf("expected ErrPipelineFailed, got %v", err)
+	}
+	if rs.Status != "failed" {
+		t.Fatalf("expected run status=failed, got %q", rs.Status)
+	}
+	if got := r.envVars["PIPE_NOTIFY_FAIL"]; got != "failed" {
+		t.Fatalf("expected on_failure hook to see PIPE_RUN_STATUS=failed, got %q", got)
+	}
+	if got := r.envVars["PIPE_TEARDOWN"]; got != "deploy" {
+		t.Fatalf("expected finally hook to run after a failed hook with PIPE_FAILED_STEPS=deploy, got %q", got)
+	}
+	if _, ok := rs.Steps["notify_ok"]; ok {
+		t.Fatal("expected on_success hook not to run")
+	}
+}
+
+func TestRun_HookFailureFailsRun(t *testing.T) {
+	p := &model.Pipeline{
+		Name:      "test-hook-failure",
+		Steps:     []model.Step{{ID: "build", Run: model.RunField{Single: "echo built"}}},
+		OnSuccess: []model.Step{{ID: "notify", Run: model.RunField{Single: "echo $PIPE_RUN_STATUS"}}},
+		Finally:   []model.Step{{ID: "cleanup", Run: model.RunField{Single: "exit 1"}}},
+	}
+	r, rs := newTestRunner(t, p)
+
+	if err := r.Run(); !errors.Is(err, ErrPipelineFailed) {
+		t.Fatalf("expected ErrPipelineFailed, got %v", err)
+	}
+	if got := r.envVars["PIPE_NOTIFY"]; got != "done" {
+		t.Fatalf("expected on_success hook to see PIPE_RUN_STATUS=done, got %q", got)
+	}
+	if rs.Status != "failed" || rs.Steps["cleanup"].Status != "failed" {
+		t.Fatalf("expected failed hook to fail the run, got run=%q cleanup=%q", rs.Status, rs.Steps["cleanup"].Status)
+	}
+}
+
+func TestRun_FinallyRunsAfterInterrupt(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "released")
+	p := &model.Pipeline{
+		Name:    "test-finally-interrupt",
+		Steps:   []model.Step{{ID: "slow", Run: model.RunField{Single: "sleep 5 & wait"}}},
+		Finally: []model.Step{{ID: "release", Run: model.RunField{Single: "echo $PIPE_RUN_STATUS > " + marker}}},
+	}
+	r, rs := newTestRunner(t, p)
+
+	time.AfterFunc(200*time.Millisecond, func() {
+		_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	})
+	if err := r.Run(); !errors.Is(err, ErrPipelineCancelled) {
+		t.Fatalf("expected ErrPipelineCancelled, got %v", err)
+	}
+	if rs.Status != "cancelled" {
+		t.Fatalf("expected run status=cancelled, got %q", rs.Status)
+	}
+	data, err := os.ReadFile(marker)
+	if err != nil {
+		t.Fatalf("expected finally hook to run after interrupt: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "cancelled" {
+		t.Fatalf("expected PIPE_RUN_STATUS=cancelled, got %q", got)
+	}
+}
+
+func TestRun_MoreItemsThanSlots(t *testing.T) {
+	t.Setenv("PIPE_MAX_PARALLEL", "1")
+	p := &model.Pipeline{
+		Name: "test-small-pool",
+		Steps: []model.Step{
+			{ID: "lint", Run: model.RunField{Strings: []string{"true", "true", "true"}}},
+			{ID: "build", Run: model.RunField{SubRuns: []model.SubRun{
+				{ID: "api", Run: "echo api"},
+				{ID: "web", Run: "echo web"},
+				{ID: "cli", Run: "echo cli"},
+			}}},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+
+	done := make(chan error, 1)
+	go func() { done <- r.Run() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run() error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() blocked with more items than process slots")
+	}
+	if rs.Steps["lint"].Status != "done" || rs.Steps["build"].Status != "done" {
+		t.Fatalf("expected both steps done, got %+v", rs.Steps)
+	}
+}
+
+// exclusive returns a command that fails if another command holding the
+// same marker directory is running at the same time.
+func exclusive(dir string) string {
+	return "mkdir " + dir + " && sleep 0.2 && rmdir " + dir
+}
+
+func TestRun_LocksSerializeSteps(t *testing.T) {
+	t.Setenv("PIPE_MAX_PARALLEL", "4")
+	held := filepath.Join(t.TempDir(), "held")
+	p := &model.Pipeline{
+		Name: "test-locks",
+		Steps: []model.Step{
+			{ID: "migrate", Run: model.RunField{Single: exclusive(held)}, ConcurrencyGroup: "db"},
+			{ID: "seed", Run: model.RunField{Single: exclusive(held)}, Locks: []string{"db", "docker"}},
+			{ID: "reset", Run: model.RunField{Single: exclusive(held)}, Locks: []string{"db"}},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error (steps sharing a lock overlapped?): %v", err)
+	}
+	if rs.Status != "done" {
+		t.Fatalf("expected run status=done, got %q", rs.Status)
+	}
+}
+
+func TestRun_StepMaxParallel(t *testing.T) {
+	t.Setenv("PIPE_MAX_PARALLEL", "4")
+	held := filepath.Join(t.TempDir(), "held")
+	p := &model.Pipeline{
+		Name: "test-step-max-parallel",
+		Steps: []model.Step{
+			{ID: "upload", Run: model.RunField{Strings: []string{exclusive(held), exclusive(held), exclusive(held)}}, MaxParallel: 1},
+		},
+	}
+	r, _ := newTestRunner(t, p)
+
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error (items ran past max_parallel?): %v", err)
+	}
+}
+
+func TestRun_NamedOutputs(t *testing.T) {
+	p := &model.Pipeline{
+		Name: "test-named-outputs",
+		Steps: []model.Step{
+			{ID: "describe", Run: model.RunField{Single: `echo "version=1.2.0" >> $PIPE_OUTPUT; printf 'notes<<EOF\na\nb\nEOF\n' >> $PIPE_OUTPUT; echo done`}},
+			{ID: "release", Run: model.RunField{Single: `echo "$PIPE_DESCRIBE_VERSION:$PIPE_DESCRIBE_NOTES"`}},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if got := r.envVars["PIPE_RELEASE"]; got != "1.2.0:a\nb" {
+		t.Fatalf("expected named outputs passed downstream, got %q", got)
+	}
+	if got := r.envVars["PIPE_DESCRIBE"]; got != "done" {
+		t.Fatalf("expected stdout output unchanged, got %q", got)
+	}
+	if got := rs.Steps["describe"].Outputs["version"]; got != "1.2.0" {
+		t.Fatalf("expected outputs in state, got %+v", rs.Steps["describe"])
+	}
+}
+
+func TestRun_InvalidOutputFileFailsStep(t *testing.T) {
+	p := &model.Pipeline{
+		Name:  "test-invalid-outputs",
+		Steps: []model.Step{{ID: "describe", Run: model.RunField{Single: `echo "not an output" >> $PIPE_OUTPUT`}}},
+	}
+	r, rs := newTestRunner(t, p)
+	if err := r.Run(); !errors.Is(err, ErrPipelineFailed) {
+		t.Fatalf("expected ErrPipelineFailed, got %v", err)
+	}
+	if ss := rs.Steps["describe"]; ss.Status != "failed" {
+		t.Fatalf("expected step to fail, got %+v", ss)
+	}
+}
+
+func TestRun_CacheHitRestoresNamedOutputs(t *testing.T) {
+	count := filepath.Join(t.TempDir(), "count")
+	p := &model.Pipeline{
+		Name: "test-cached-outputs",
+		Steps: []model.Step{
+			{ID: "describe", Run: model.RunField{Single: `echo x >> ` + count + `; echo "version=1.2.0" >> $PIPE_OUTPUT`}, Cached: model.CacheField{Enabled: true}},
+			{ID: "release", Run: model.RunField{Single: `echo $PIPE_DESCRIBE_VERSION`}},
+		},
+	}
+	r, _ := newTestRunner(t, p)
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	r2 := New(p, state.NewRunState(p.Name), r.log, nil, nil, 0)
+	if err := r2.Run(); err != nil {
+		t.Fatalf("second Run() error: %v", err)
+	}
+	if got := r2.envVars["PIPE_RELEASE"]; got != "1.2.0" {
+		t.Fatalf("expected cached named output, got %q", got)
+	}
+	if data, _ := os.ReadFile(count); strings.Count(string(data), "x") != 1 {
+		t.Fatalf("expected second run to hit the cache, ran %d times", strings.Count(string(data), "x"))
+	}
+}
+
+func TestRun_CacheKey(t *testing.T) {
+	dir := t.TempDir()
+	count := filepath.Join(dir, "count")
+	version := filepath.Join(dir, "version")
+	sum := filepath.Join(dir, "go.sum")
+	for path, data := range map[string]string{version: "v1", sum: "h1"} {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pipeline := func(name string) *model.Pipeline {
+		return &model.Pipeline{
+			Name: name,
+			Steps: []model.Step{
+				{ID: "fetch", Run: model.RunField{Single: "cat " + version}},
+				{ID: "build", Dir: dir, Run: model.RunField{Single: "echo x >> " + count + "; echo $PIPE_FETCH $PIPE_VAR_ENV"},
+					Cached: model.CacheField{Enabled: true, KeyFiles: []string{"go.sum"}}},
+			},
+		}
+	}
+	r, _ := newTestRunner(t, pipeline("app"))
+	runs := func() int {
+		data, _ := os.ReadFile(count)
+		return strings.Count(string(data), "x")
+	}
+	run := func(name, env string) {
+		t.Helper()
+		r2 := New(pipeline(name), state.NewRunState(name), r.log, map[string]string{"PIPE_VAR_ENV": env}, nil, 0)
+		if err := r2.Run(); err != nil {
+			t.Fatalf("Run() error: %v", err)
+		}
+	}
+
+	steps := []struct {
+		name   string
+		change func()
+		runs   int
+	}{
+		{"first run", func() {}, 1},
+		{"unchanged", func() {}, 1},
+		{"var changed", func() { run("app", "dev") }, 3},
+		{"upstream output changed", func() { _ = os.WriteFile(version, []byte("v2"), 0o644) }, 4},
+		{"key file changed", func() { _ = os.WriteFile(sum, []byte("h2"), 0o644) }, 5},
+		{"other pipeline with the same step", func() { run("web", "prod") }, 6},
+	}
+	for _, st := range steps {
+		st.change()
+		run("app", "prod")
+		if got := runs(); got != st.runs {
+			t.Fatalf("%s: expected build to have run %d times, ran %d", st.name, st.runs, got)
+		}
+	}
+}
+
+// readLogs returns the contents of every run log written so far.
+func readLogs(t *testing.T) string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(config.LogDir, "*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	for _, f := range files {
+		data, _ := os.ReadFile(f)
+		b.Write(data)
+	}
+	return b.String()
+}
+
+func TestRun_CacheInvalidationCascades(t *testing.T) {
+	dir := t.TempDir()
+	version := filepath.Join(dir, "version")
+	if err := os.WriteFile(version, []byte("v1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p := &model.Pipeline{
+		Name: "test-cache-cascade",
+		Steps: []model.Step{
+			{ID: "fetch", Run: model.RunField{Single: "cat " + version}},
+			{ID: "build", Run: model.RunField{Single: "echo x >> " + filepath.Join(dir, "build") + "; echo built-$PIPE_FETCH"}, Cached: model.CacheField{Enabled: true}},
+			{ID: "ship", Run: model.RunField{Single: "echo x >> " + filepath.Join(dir, "ship") + "; echo $PIPE_BUILD"}, Cached: model.CacheField{Enabled: true}},
+			{ID: "lint", Run: model.RunField{Single: "echo x >> " + filepath.Join(dir, "lint")}, Cached: model.CacheField{Enabled: true}},
+		},
+	}
+	r, _ := newTestRunner(t, p)
+	runs := func(id string) int {
+		data, _ := os.ReadFile(filepath.Join(dir, id))
+		return strings.Count(string(data), "x")
+	}
+	run := func() {
+		t.Helper()
+		if err := New(p, state.NewRunState(p.Name), r.log, nil, nil, 0).Run(); err != nil {
+			t.Fatalf("Run() error: %v", err)
+		}
+	}
+
+	run()
+	run()
+	for _, id := range []string{"build", "ship", "lint"} {
+		if got := runs(id); got != 1 {
+			t.Fatalf("expected %s to run once before the change, ran %d times", id, got)
+		}
+	}
+
+	// fetch's new output invalidates build, whose new output invalidates
+	// ship; lint depends on neither and stays cached.
+	if err := os.WriteFile(version, []byte("v2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run()
+	for id, want := range map[string]int{"build": 2, "ship": 2, "lint": 1} {
+		if got := runs(id); got != want {
+			t.Fatalf("expected %s to have run %d times, ran %d", id, want, got)
+		}
+	}
+	logs := readLogs(t)
+	for _, want := range []string{`[build] cache miss: upstream "fetch" output changed (`, `[ship] cache miss: upstream "build" output changed (`} {
+		if !strings.Contains(logs, want) {
+			t.Fatalf("expected log line %q, got:\n%s", want, logs)
+		}
+	}
+}
+
+func TestRun_SharedCacheStore(t *testing.T) {
+	dir := t.TempDir()
+	count := filepath.Join(dir, "count")
+	p := &model.Pipeline{
+		Name:       "test-shared-cache",
+		CacheStore: filepath.Join(dir, "shared"),
+		Steps: []model.Step{
+			{ID: "build", Run: model.RunField{Single: "echo x >> " + count + "; echo built"}, Cached: model.CacheField{Enabled: true}},
+		},
+	}
+	r, _ := newTestRunner(t, p)
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	// Another machine: its own local cache, the same shared store.
+	config.CacheDir = t.TempDir()
+	r2 := New(p, state.NewRunState(p.Name), r.log, nil, nil, 0)
+	if err := r2.Run(); err != nil {
+		t.Fatalf("second Run() error: %v", err)
+	}
+	if got := r2.envVars["PIPE_BUILD"]; got != "built" {
+		t.Fatalf("expected output from the shared store, got %q", got)
+	}
+	if data, _ := os.ReadFile(count); strings.Count(string(data), "x") != 1 {
+		t.Fatalf("expected a cache hit from the shared store, ran %d times", strings.Count(string(data), "x"))
+	}
+}
+
+func TestRun_CacheStats(t *testing.T) {
+	p := &model.Pipeline{
+		Name: "test-cache-stats",
+		Steps: []model.Step{
+			{ID: "build", Run: model.RunField{Single: "echo built"}, Cached: model.CacheField{Enabled: true}},
+		},
+	}
+	r, _ := newTestRunner(t, p)
+	run := func() {
+		t.Helper()
+		r2 := New(p, state.NewRunState(p.Name), r.log, nil, nil, 0)
+		if err := r2.Run(); err != nil {
+			t.Fatalf("Run() error: %v", err)
+		}
+	}
+	for range 3 {
+		run()
+	}
+	entry, _ := cache.Load(p.Name, "build")
+	if entry == nil || entry.Hits != 2 || entry.Misses != 1 || entry.LastHitAt == nil {
+		t.Fatalf("expected 2 hits and 1 miss, got %+v", entry)
+	}
+
+	// A changed command is a miss; the counts carry over to the new entry.
+	p.Steps[0].Run.Single = "echo rebuilt"
+	run()
+	entry, _ = cache.Load(p.Name, "build")
+	if entry == nil || entry.Hits != 2 || entry.Misses != 2 {
+		t.Fatalf("expected 2 hits and 2 misses, got %+v", entry)
+	}
+}
+
+func TestRun_CacheStoreFallsBackToLocal(t *testing.T) {
+	// Nothing listens on port 1.
+	t.Setenv(cache.StoreEnv, "http://127.0.0.1:1/cache")
+	count := filepath.Join(t.TempDir(), "count")
+	p := &model.Pipeline{
+		Name: "test-cache-fallback",
+		Steps: []model.Step{
+			{ID: "build", Run: model.RunField{Single: "echo x >> " + count}, Cached: model.CacheField{Enabled: true}},
+		},
+	}
+	r, _ := newTestRunner(t, p)
+	for range 2 {
+		if err := New(p, state.NewRunState(p.Name), r.log, nil, nil, 0).Run(); err != nil {
+			t.Fatalf("Run() error: %v", err)
+		}
+	}
+	if data, _ := os.ReadFile(count); strings.Count(string(data), "x") != 1 {
+		t.Fatalf("expected the local cache to be used, ran %d times", strings.Count(string(data), "x"))
+	}
+	if entry, _ := cache.Load(p.Name, "build"); entry == nil {
+		t.Fatal("expected the entry in the local cache")
+	}
+}
+
+func TestRun_UpToDate(t *testing.T) {
+	dir := t.TempDir()
+	count := filepath.Join(dir, "count")
+	src := filepath.Join(dir, "src", "pkg", "a.txt")
+	if err := os.MkdirAll(filepath.Dir(src), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(src, []byte("v1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p := &model.Pipeline{
+		Name: "test-up-to-date",
+		Steps: []model.Step{
+			{ID: "build", Dir: dir, Run: model.RunField{Single: "echo x >> " + count + "; cat src/pkg/a.txt > out.txt"},
+				Sources: []string{"src/**/*.txt"}, Generates: []string{"out.txt"}},
+		},
+	}
+	r, _ := newTestRunner(t, p)
+	run := func() *state.RunState {
+		t.Helper()
+		rs := state.NewRunState(p.Name)
+		if err := New(p, rs, r.log, nil, nil, 0).Run(); err != nil {
+			t.Fatalf("Run() error: %v", err)
+		}
+		return rs
+	}
+	runs := func() int {
+		data, _ := os.ReadFile(count)
+		return strings.Count(string(data), "x")
+	}
+
+	steps := []struct {
+		name     string
+		change   func()
+		runs     int
+		upToDate bool
+	}{
+		{"output missing", func() {}, 1, false},
+		{"output newer than sources", func() {}, 1, true},
+		// The touched source is newer than out.txt, but it hashes the same
+		// as in the last successful run.
+		{"source touched", func() {
+			future := time.Now().Add(time.Hour)
+			_ = os.Chtimes(src, future, future)
+		}, 1, true},
+		{"source edited", func() { _ = os.WriteFile(src, []byte("v2"), 0o644) }, 2, false},
+		{"output newer again", func() {}, 2, true},
+		{"output removed", func() { _ = os.Remove(filepath.Join(dir, "out.txt")) }, 3, false},
+	}
+	for _, st := range steps {
+		st.change()
+		rs := run()
+		if got := runs(); got != st.runs {
+			t.Fatalf("%s: expected build to have run %d times, ran %d", st.name, st.runs, got)
+		}
+		if ss := rs.Steps["build"]; ss.Status != "done" || ss.UpToDate != st.upToDate {
+			t.Fatalf("%s: expected done with up_to_date=%v, got %+v", st.name, st.upToDate, ss)
+		}
+	}
+}
+
+func TestRun_UpToDateExportsOutputs(t *testing.T) {
+	dir := t.TempDir()
+	count := filepath.Join(dir, "count")
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("v1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p := &model.Pipeline{
+		Name: "test-up-to-date-outputs",
+		Steps: []model.Step{
+			{ID: "build", Dir: dir, Run: model.RunField{Single: "echo x >> " + count + `; cp a.txt out.txt; echo "version=1.2.0" >> $PIPE_OUTPUT; echo app-v1`},
+				Sources: []string{"a.txt"}, Generates: []string{"out.txt"}, Artifacts: []string{"out.txt"}, Outputs: []string{"version"}},
+			{ID: "ship", Run: model.RunField{Single: "echo $PIPE_BUILD $PIPE_BUILD_VERSION $(cat $PIPE_BUILD_ARTIFACTS/out.txt)"}},
+		},
+	}
+	r, _ := newTestRunner(t, p)
+	for i := range 2 {
+		rs := state.NewRunState(p.Name)
+		r2 := New(p, rs, r.log, nil, nil, 0)
+		if err := r2.Run(); err != nil {
+			t.Fatalf("run %d: Run() error: %v", i+1, err)
+		}
+		if got := r2.envVars["PIPE_SHIP"]; got != "app-v1 1.2.0 v1" {
+			t.Fatalf("run %d: expected the build outputs downstream, got %q", i+1, got)
+		}
+		if ss := rs.Steps["build"]; i == 1 && (!ss.UpToDate || ss.Output != "app-v1\n" || ss.Outputs["version"] != "1.2.0") {
+			t.Fatalf("expected the up-to-date step to keep its outputs, got %+v", ss)
+		}
+	}
+	if data, _ := os.ReadFile(count); strings.Count(string(data), "x") != 1 {
+		t.Fatalf("expected build to run once, ran %d times", strings.Count(string(data), "x"))
+	}
+}
+
+func TestRun_UpToDateNeedsEarlierOutputs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "out.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("v1"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	future := time.Now().Add(time.Hour)
+	_ = os.Chtimes(filepath.Join(dir, "out.txt"), future, future)
+	p := &model.Pipeline{
+		Name: "test-up-to-date-no-run",
+		Steps: []model.Step{
+			{ID: "build", Dir: dir, Run: model.RunField{Single: "echo app-v1"}, Sources: []string{"a.txt"}, Generates: []string{"out.txt"}},
+			{ID: "ship", Run: model.RunField{Single: "echo $PIPE_BUILD"}},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if ss := rs.Steps["build"]; ss.UpToDate {
+		t.Fatalf("expected build to run without an earlier run to take outputs from, got %+v", ss)
+	}
+	if got := r.envVars["PIPE_SHIP"]; got != "app-v1" {
+		t.Fatalf("expected the build output downstream, got %q", got)
+	}
+}
+
+func TestRun_JSONOutput(t *testing.T) {
+	p := &model.Pipeline{
+		Name: "test-json-output",
+		Steps: []model.Step{
+			{ID: "describe", Run: model.RunField{Single: `echo '{"image": {"tag": "v1"}, "pods": [{"name": "api-0"}]}'`}, OutputFormat: model.OutputJSON},
+			{ID: "push", Run: model.RunField{Single: `echo "$PIPE_DESCRIBE__IMAGE__TAG {{ .steps.describe.pods.0.name }}"`}},
+		},
+	}
+	r, _ := newTestRunner(t, p)
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if got := r.envVars["PIPE_PUSH"]; got != "v1 api-0" {
+		t.Fatalf("expected JSON fields passed downstream, got %q", got)
+	}
+}
+
+func TestRun_InvalidJSONOutputFailsStep(t *testing.T) {
+	p := &model.Pipeline{
+		Name: "test-invalid-json",
+		Steps: []model.Step{
+			{ID: "describe", Run: model.RunField{Single: "echo not json"}, OutputFormat: model.OutputJSON},
+			{ID: "push", Run: model.RunField{Single: "echo $PIPE_DESCRIBE__TAG"}},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+	if err := r.Run(); !errors.Is(err, ErrPipelineFailed) {
+		t.Fatalf("expected ErrPipelineFailed, got %v", err)
+	}
+	if ss := rs.Steps["describe"]; ss.Status != "failed" {
+		t.Fatalf("expected step to fail, got %+v", ss)
+	}
+	if ss := rs.Steps["push"]; ss.Status == "done" {
+		t.Fatalf("expected dependent not to run, got %+v", ss)
+	}
+}
+
+func TestRun_OutputFileAndInlineLimit(t *testing.T) {
+	dir := t.TempDir()
+	p := &model.Pipeline{
+		Name: "test-output-file",
+		Steps: []model.Step{
+			{ID: "manifest", Run: model.RunField{Single: "head -c 2048 /dev/zero | tr '\\0' x"}, InlineLimit: "1KB"},
+			{ID: "version", Run: model.RunField{Single: "echo 1.2.0"}},
+			{ID: "apply", Run: model.RunField{Single: `[ -f ` + filepath.Join(dir, "ready") + ` ] && echo "${PIPE_MANIFEST:-unset} $(wc -c < $PIPE_MANIFEST_FILE | tr -d " ") $(cat $PIPE_VERSION_FILE)"`}},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+	if err := r.Run(); !errors.Is(err, ErrPipelineFailed) {
+		t.Fatalf("expected ErrPipelineFailed, got %v", err)
+	}
+	ss := rs.Steps["manifest"]
+	if ss.Output != "" || ss.OutputFile == "" {
+		t.Fatalf("expected large output kept out of state, got %+v", ss)
+	}
+	if rs.Steps["version"].Output != "1.2.0\n" {
+		t.Fatalf("expected small output kept inline, got %+v", rs.Steps["version"])
+	}
+
+	// On resume the large output comes back from its file.
+	if err := os.WriteFile(filepath.Join(dir, "ready"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r2 := New(p, rs, r.log, nil, nil, 0)
+	r2.RestoreEnvFromState()
+	if err := r2.Run(); err != nil {
+		t.Fatalf("resumed Run() error: %v", err)
+	}
+	if got := r2.envVars["PIPE_APPLY"]; got != "unset 2048 1.2.0" {
+		t.Fatalf("expected output via file only, got %q", got)
+	}
+}
+
+func TestRun_OverInlineLimitForeachAndCondition(t *testing.T) {
+	p := &model.Pipeline{
+		Name: "test-inline-limit-readers",
+		Steps: []model.Step{
+			{ID: "list", Run: model.RunField{Single: "for i in $(seq 1 300); do echo item-$i; done"}, InlineLimit: "1KB"},
+			{ID: "each", Foreach: "$PIPE_LIST", Run: model.RunField{Single: "echo $PIPE_ITEM"}},
+			{ID: "check", If: `steps.list.output != ""`, Run: model.RunField{Single: "echo checked"}, DependsOn: model.DependsOnField{Steps: []string{"list"}}},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if _, ok := r.envVars["PIPE_LIST"]; ok {
+		t.Fatal("expected PIPE_LIST over the inline_limit not to be exported")
+	}
+	if n := len(rs.Steps["each"].SubSteps); n != 300 {
+		t.Fatalf("expected foreach to read the items from the output file, got %d", n)
+	}
+	if ss := rs.Steps["check"]; ss.Status != "done" {
+		t.Fatalf("expected the condition to see the output from its file, got %+v", ss)
+	}
+}
+
+func TestRun_LargeOutputSpillsFromState(t *testing.T) {
+	p := &model.Pipeline{
+		Name: "test-state-spill",
+		Steps: []model.Step{
+			{ID: "render", Run: model.RunField{Single: "head -c 100000 /dev/zero | tr '\\0' x"}},
+			{ID: "count", Run: model.RunField{Single: "echo ${#PIPE_RENDER}"}, DependsOn: model.DependsOnField{Steps: []string{"render"}}},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if ss := rs.Steps["render"]; ss.Output != "" || ss.OutputFile == "" {
+		t.Fatalf("expected large output kept in its file only, got output of %d bytes", len(ss.Output))
+	}
+	if got := r.envVars["PIPE_COUNT"]; got != "100000" {
+		t.Fatalf("expected the output exported inline without inline_limit, got %q", got)
+	}
+}
+
+func TestRun_JSONOutputOverInlineLimit(t *testing.T) {
+	p := &model.Pipeline{
+		Name: "test-json-inline-limit",
+		Steps: []model.Step{
+			{ID: "small", Run: model.RunField{Single: `echo '{"tag": "v1"}'`}, OutputFormat: model.OutputJSON, InlineLimit: "1KB"},
+			{ID: "large", Run: model.RunField{Single: `printf '{"pad": "%s", "tag": "v2"}' "$(head -c 2048 /dev/zero | tr '\0' x)"`}, OutputFormat: model.OutputJSON, InlineLimit: "1KB"},
+		},
+	}
+	r, _ := newTestRunner(t, p)
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if got := r.envVars["PIPE_SMALL__TAG"]; got != "v1" {
+		t.Fatalf("expected fields of output under the limit, got %q", got)
+	}
+	for k := range r.envVars {
+		if strings.HasPrefix(k, "PIPE_LARGE") && k != "PIPE_LARGE_FILE" {
+			t.Errorf("expected only PIPE_LARGE_FILE for output over the limit, got %s", k)
+		}
+	}
+}
+
+func TestRun_ArtifactsRestoredOnResume(t *testing.T) {
+	dir := t.TempDir()
+	ready := filepath.Join(dir, "ready")
+	p := &model.Pipeline{
+		Name: "test-artifacts-resume",
+		Steps: []model.Step{
+			{ID: "build", Run: model.RunField{Single: "mkdir -p dist && echo v1 > dist/app && echo log > build.log"}, Dir: dir, Artifacts: []string{"dist", "*.log"}},
+			{ID: "ship", Run: model.RunField{Single: "test -f " + ready + " && cat dist/app && ls $PIPE_BUILD_ARTIFACTS"}, Dir: dir, DependsOn: model.DependsOnField{Steps: []string{"build"}}},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+	if err := r.Run(); !errors.Is(err, ErrPipelineFailed) {
+		t.Fatalf("expected ErrPipelineFailed, got %v", err)
+	}
+	arts := rs.Steps["build"].Artifacts
+	if arts == nil || arts.Dir != dir || !slices.Equal(arts.Paths, []string{"build.log", "dist"}) {
+		t.Fatalf("expected artifacts recorded in state, got %+v", arts)
+	}
+
+	// The binary is overwritten before the run is resumed.
+	if err := os.WriteFile(filepath.Join(dir, "dist", "app"), []byte("broken\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ready, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r2 := New(p, rs, r.log, nil, nil, 0)
+	r2.RestoreEnvFromState()
+	if err := r2.Run(); err != nil {
+		t.Fatalf("resumed Run() error: %v", err)
+	}
+	if got := r2.envVars["PIPE_SHIP"]; got != "v1\nbuild.log\ndist" {
+		t.Fatalf("expected restored artifacts, got %q", got)
+	}
+}
+
+func TestRestoreArtifacts_RejectsNonLocalPaths(t *testing.T) {
+	root := t.TempDir()
+	src, base := filepath.Join(root, "src"), filepath.Join(root, "work", "app")
+	if err := os.MkdirAll(filepath.Join(src, "x"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "src", "x", "key"), []byte("evil"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p := &model.Pipeline{Name: "test-artifacts-paths", Steps: []model.Step{{ID: "build", Run: model.RunField{Single: "true"}}}}
+	r, _ := newTestRunner(t, p)
+	for _, rel := range []string{"../x/key", "/etc/key"} {
+		err := r.restoreArtifacts(p.Steps[0], filepath.Join(src, "x"), base, []string{rel})
+		if err == nil || !strings.Contains(err.Error(), "invalid path") {
+			t.Errorf("%s: expected an invalid path error, got %v", rel, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "work", "x", "key")); !os.IsNotExist(err) {
+		t.Fatal("expected nothing written outside the working directory")
+	}
+}
+
+func TestRun_ArtifactsRestoredOnCacheHit(t *testing.T) {
+	dir := t.TempDir()
+	count := filepath.Join(t.TempDir(), "count")
+	p := &model.Pipeline{
+		Name: "test-artifacts-cache",
+		Steps: []model.Step{
+			{ID: "build", Run: model.RunField{Single: "echo x >> " + count + " && echo v1 > app"}, Dir: dir, Artifacts: []string{"app"}, Cached: model.CacheField{Enabled: true}},
+			{ID: "ship", Run: model.RunField{Single: "cat app"}, Dir: dir, DependsOn: model.DependsOnField{Steps: []string{"build"}}},
+		},
+	}
+	r, _ := newTestRunner(t, p)
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "app")); err != nil {
+		t.Fatal(err)
+	}
+
+	r2 := New(p, state.NewRunState(p.Name), r.log, nil, nil, 0)
+	if err := r2.Run(); err != nil {
+		t.Fatalf("second Run() error: %v", err)
+	}
+	if got := r2.envVars["PIPE_SHIP"]; got != "v1" {
+		t.Fatalf("expected artifact restored from cache, got %q", got)
+	}
+	if data, _ := os.ReadFile(count); strings.Count(string(data), "x") != 1 {
+		t.Fatalf("expected second run to hit the cache")
+	}
+	if _, err := os.Stat(filepath.Join(r2.artifactDir("build"), "app")); err != nil {
+		t.Fatalf("expected artifact copied into the new run: %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package runner
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/getpipe-dev/pipe/internal/cache"
+	"github.com/getpipe-dev/pipe/internal/graph"
+	"github.com/getpipe-dev/pipe/internal/model"
+	"github.com/getpipe-dev/pipe/internal/state"
+	"github.com/getpipe-dev/pipe/internal/ui"
+)
+
+// Selection picks the steps of a partial run. The filters narrow each
+// other: --from build --until push runs the steps between the two.
+type Selection struct {
+	Only     []string // run just these steps
+	WithDeps bool     // with Only, also the steps they transitively depend on
+	From     string   // run this step and every step that depends on it
+	Until    string   // run this step and every step it depends on
+	Skip     []string // leave these steps out
+}
+
+// IsZero reports whether sel selects every step.
+func (sel Selection) IsZero() bool {
+	return len(sel.Only) == 0 && sel.From == "" && sel.Until == "" && len(sel.Skip) == 0
+}
+
+// Validate reports whether sel names only steps of p and selects at least
+// one of them.
+func (sel Selection) Validate(p *model.Pipeline) error {
+	_, _, err := sel.resolve(p)
+	return err
+}
+
+// Select limits the run to the steps sel picks. Steps left out are not run;
+// those a selected step depends on export the outputs of their latest
+// successful run, or of their cache entry, so $PIPE_* references to them
+// still resolve. It fails when one of them has neither. Hooks always run.
+func (r *Runner) Select(sel Selection) error {
+	selected, reuse, err := sel.resolve(r.pipeline)
+	if err != nil {
+		return err
+	}
+	r.selected, r.reuse = selected, reuse
+	for _, step := range r.pipeline.Steps {
+		if !reuse[step.ID] {
+			continue
+		}
+		if ss := r.state.Steps[step.ID]; ss.Status == "done" && !step.Sensitive {
+			continue // done in the run being resumed
+		}
+		if _, ok := r.reusedState(step); !ok {
+			return errNoReuse(step.ID)
+		}
+	}
+	return nil
+}
+
+func errNoReuse(id string) error {
+	return fmt.Errorf("selected steps depend on step %q, but no earlier run or cache entry has its outputs — run it first", id)
+}
+
+// resolve returns the steps sel selects and the unselected steps they
+// depend on; both are nil when every step is selected.
+func (sel Selection) resolve(p *model.Pipeline) (selected, reuse map[string]bool, err error) {
+	if sel.IsZero() {
+		return nil, nil, nil
+	}
+	g, err := graph.Build(p.Steps)
+	if err != nil {
+		return nil, nil, fmt.Errorf("building dependency graph: %w", err)
+	}
+	named := slices.Concat(sel.Only, sel.Skip, []string{sel.From, sel.Until})
+	for _, id := range named {
+		if _, ok := g.InDegree[id]; id != "" && !ok {
+			return nil, nil, fmt.Errorf("pipeline %q has no step %q", p.Name, id)
+		}
+	}
+
+	selected = make(map[string]bool)
+	for _, id := range g.Order {
+		selected[id] = true
+	}
+	keep := func(ids map[string]bool) {
+		for id := range selected {
+			if !ids[id] {
+				delete(selected, id)
+			}
+		}
+	}
+	if len(sel.Only) > 0 {
+		only := make(map[string]bool)
+		for _, id := range sel.Only {
+			only[id] = true
+		}
+		if sel.WithDeps {
+			only = reachable(g.Deps, sel.Only...)
+		}
+		keep(only)
+	}
+	if sel.From != "" {
+		keep(reachable(g.Dependents, sel.From))
+	}
+	if sel.Until != "" {
+		keep(reachable(g.Deps, sel.Until))
+	}
+	for _, id := range sel.Skip {
+		delete(selected, id)
+	}
+	if len(selected) == 0 {
+		return nil, nil, fmt.Errorf("no steps of pipeline %q are selected", p.Name)
+	}
+
+	reuse = make(map[string]bool)
+	for id := range selected {
+		for dep := range reachable(g.Deps, id) {
+			if !selected[dep] {
+				reuse[dep] = true
+			}
+		}
+	}
+	return selected, reuse, nil
+}
+
+// reachable returns ids and every step reachable from them through edges.
+func reachable(edges map[string][]string, ids ...string) map[string]bool {
+	seen := make(map[string]bool)
+	queue := slices.Clone(ids)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		queue = append(queue, edges[id]...)
+	}
+	return seen
+}
+
+// isSelected reports whether a partial run executes a step. Hooks are not
+// part of a selection and always run.
+func (r *Runner) isSelected(id string) bool {
+	if r.selected == nil || r.selected[id] {
+		return true
+	}
+	hooks := slices.Concat(r.pipeline.OnSuccess, r.pipeline.OnFailure, r.pipeline.Finally)
+	return slices.ContainsFunc(hooks, func(s model.Step) bool { return s.ID == id })
+}
+
+// skipUnselected records a step a partial run leaves out. One that selected
+// steps depend on is recorded as done with the outputs reusedState finds,
+// and exports them; it fails if those outputs are gone since Select.
+func (r *Runner) skipUnselected(step model.Step) error {
+	ss := state.StepState{Status: "skipped"}
+	switch prev, ok := r.reusedState(step); {
+	case ok:
+		ss = prev
+		r.log.Log("[%s] not selected, using the outputs of %s", step.ID, ss.ReusedFrom)
+		r.exportState(step, ss)
+	case r.reuse[step.ID]:
+		err := errNoReuse(step.ID)
+		r.failNotStarted(step, err)
+		return err
+	default:
+		r.log.Log("[%s] skipped (not selected)", step.ID)
+	}
+	ss.Unselected = true
+	now := time.Now()
+	ss.At = &now
+	r.setStepState(step.ID, ss)
+	r.uiStatusStep(step, ui.Skipped)
+	return nil
+}
+
+// reusedState returns the state of an unselected step that selected steps
+// depend on, from the latest run in which it succeeded, else from a valid
+// cache entry. Sensitive outputs are never stored, so they cannot be
+// reused. Artifacts are not restored: a partial run works on what the
+// earlier run left in place.
+func (r *Runner) reusedState(step model.Step) (state.StepState, bool) {
+	if !r.reuse[step.ID] {
+		return state.StepState{}, false
+	}
+	prev, err := state.Latest(r.pipeline.Name, r.state.RunID, func(rs *state.RunState) bool {
+		ss := rs.Steps[step.ID]
+		return ss.Status == "done" && !ss.Sensitive
+	})
+	if err != nil {
+		r.log.Log("[%s] cannot read earlier runs: %v", step.ID, err)
+	}
+	if prev != nil {
+		ss := prev.Steps[step.ID]
+		ss.Artifacts = nil
+		ss.ReusedFrom = "run " + prev.RunID
+		return ss, true
+	}
+
+	if !step.Cached.Enabled {
+		return state.StepState{}, false
+	}
+	entry, err := r.cacheStore().Load(r.pipeline.Name, step.ID)
+	if err != nil {
+		r.log.Log("[%s] cache warning: %v", step.ID, err)
+	}
+	if entry == nil || entry.Sensitive || !cache.IsValid(entry, time.Now()) {
+		return state.StepState{}, false
+	}
+	ss := state.StepState{Status: "done", Output: entry.Output, Outputs: entry.Outputs, ReusedFrom: "cache"}
+	for _, sub := range entry.SubOutputs {
+		if ss.SubSteps == nil {
+			ss.SubSteps = make(map[string]state.StepState)
+		}
+		subSS := state.StepState{Status: "done", Sensitive: sub.Sensitive}
+		if !sub.Sensitive {
+			subSS.Output = sub.Output
+			subSS.Outputs = sub.Outputs
+		}
+		ss.SubSteps[sub.ID] = subSS
+	}
+	return ss, true
+}
@@ -0,0 +1,188 @@
+package runner
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/getpipe-dev/pipe/internal/config"
+	"github.com/getpipe-dev/pipe/internal/model"
+	"github.com/getpipe-dev/pipe/internal/state"
+)
+
+// releasePipeline is version → build → push → notify, with lint on its own.
+func releasePipeline(name, count string) *model.Pipeline {
+	return &model.Pipeline{
+		Name: name,
+		Steps: []model.Step{
+			{ID: "version", Run: model.RunField{Single: "echo version >> " + count + "; echo 1.2.0"}, Cached: model.CacheField{Enabled: true}},
+			{ID: "build", Run: model.RunField{Single: "echo build >> " + count + "; echo app-$PIPE_VERSION"}},
+			{ID: "push", Run: model.RunField{Single: "echo push >> " + count + "; echo pushed $PIPE_BUILD"}},
+			{ID: "notify", Run: model.RunField{Single: "echo notify >> " + count + "; echo $PIPE_PUSH"}},
+			{ID: "lint", Run: model.RunField{Single: "echo lint >> " + count}},
+		},
+	}
+}
+
+func TestSelection_Resolve(t *testing.T) {
+	p := releasePipeline("test-select", "/dev/null")
+	tests := []struct {
+		name     string
+		sel      Selection
+		selected []string
+		reuse    []string
+		err      string
+	}{
+		{name: "all", sel: Selection{}},
+		{name: "only", sel: Selection{Only: []string{"push"}}, selected: []string{"push"}, reuse: []string{"build", "version"}},
+		{name: "only with deps", sel: Selection{Only: []string{"push"}, WithDeps: true}, selected: []string{"build", "push", "version"}, reuse: []string{}},
+		{name: "from", sel: Selection{From: "build"}, selected: []string{"build", "notify", "push"}, reuse: []string{"version"}},
+		{name: "until", sel: Selection{Until: "build"}, selected: []string{"build", "version"}, reuse: []string{}},
+		{name: "from until", sel: Selection{From: "build", Until: "push"}, selected: []string{"build", "push"}, reuse: []string{"version"}},
+		{name: "skip", sel: Selection{Skip: []string{"notify", "version"}}, selected: []string{"build", "lint", "push"}, reuse: []string{"version"}},
+		{name: "unknown step", sel: Selection{From: "deploy"}, err: `pipeline "test-select" has no step "deploy"`},
+		{name: "nothing left", sel: Selection{Only: []string{"lint"}, Skip: []string{"lint"}}, err: `no steps of pipeline "test-select" are selected`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, reuse, err := tt.sel.resolve(p)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.selected == nil {
+				if selected != nil || reuse != nil {
+					t.Fatalf("expected every step to be selected, got %v", selected)
+				}
+				return
+			}
+			if got := slices.Sorted(maps.Keys(selected)); !slices.Equal(got, tt.selected) {
+				t.Errorf("selected = %v, want %v", got, tt.selected)
+			}
+			if got := slices.Sorted(maps.Keys(reuse)); !slices.Equal(got, tt.reuse) {
+				t.Errorf("reuse = %v, want %v", got, tt.reuse)
+			}
+		})
+	}
+}
+
+func TestRun_OnlyReusesEarlierOutputs(t *testing.T) {
+	count := filepath.Join(t.TempDir(), "count")
+	p := releasePipeline("test-only", count)
+	p.Steps[0].Cached = model.CacheField{}
+	r, _ := newTestRunner(t, p)
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	_ = os.Remove(count)
+
+	rs := state.NewRunState(p.Name)
+	r2 := New(p, rs, r.log, nil, nil, 0)
+	if err := r2.Select(Selection{Only: []string{"push"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r2.Run(); err != nil {
+		t.Fatalf("partial Run() error: %v", err)
+	}
+	if data, _ := os.ReadFile(count); string(data) != "push\n" {
+		t.Fatalf("expected only push to run, ran %q", data)
+	}
+	if got := r2.envVars["PIPE_PUSH"]; got != "pushed app-1.2.0" {
+		t.Fatalf("expected push to see the earlier build output, got %q", got)
+	}
+	build := rs.Steps["build"]
+	if build.Status != "done" || !build.Unselected || !strings.HasPrefix(build.ReusedFrom, "run ") {
+		t.Errorf("expected build to be reused from an earlier run, got %+v", build)
+	}
+	for _, id := range []string{"notify", "lint"} {
+		if ss := rs.Steps[id]; ss.Status != "skipped" || !ss.Unselected {
+			t.Errorf("expected %s to be skipped as unselected, got %+v", id, ss)
+		}
+	}
+}
+
+func TestSelect_FailsWithoutEarlierOutputs(t *testing.T) {
+	count := filepath.Join(t.TempDir(), "count")
+	p := releasePipeline("test-only-first", count)
+	p.Steps[0].Cached = model.CacheField{}
+	r, _ := newTestRunner(t, p)
+
+	err := r.Select(Selection{Only: []string{"push"}})
+	if err == nil || !strings.Contains(err.Error(), `step "version"`) {
+		t.Fatalf("expected an error naming version, got %v", err)
+	}
+}
+
+func TestRun_FromReusesCachedOutputs(t *testing.T) {
+	count := filepath.Join(t.TempDir(), "count")
+	p := releasePipeline("test-from-cache", count)
+	r, _ := newTestRunner(t, p)
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	// Without earlier run states only the cache entry is left.
+	if err := os.RemoveAll(filepath.Join(config.StateDir, p.Name)); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.EnsureDirs(p.Name); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Remove(count)
+
+	rs := state.NewRunState(p.Name)
+	r2 := New(p, rs, r.log, nil, nil, 0)
+	if err := r2.Select(Selection{From: "build", Until: "push"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r2.Run(); err != nil {
+		t.Fatalf("partial Run() error: %v", err)
+	}
+	if data, _ := os.ReadFile(count); string(data) != "build\npush\n" {
+		t.Fatalf("expected build and push to run, ran %q", data)
+	}
+	if got := r2.envVars["PIPE_BUILD"]; got != "app-1.2.0" {
+		t.Fatalf("expected build to see the cached version, got %q", got)
+	}
+	if got := rs.Steps["version"].ReusedFrom; got != "cache" {
+		t.Errorf("expected version to be reused from the cache, got %q", got)
+	}
+}
+
+func TestRun_OnlyRunsHooks(t *testing.T) {
+	p := &model.Pipeline{
+		Name: "test-only-hooks",
+		Steps: []model.Step{
+			{ID: "a", Run: model.RunField{Single: "echo a"}},
+			{ID: "b", Run: model.RunField{Single: "exit 1"}},
+		},
+		OnFailure: []model.Step{{ID: "alert", Run: model.RunField{Single: "echo $PIPE_FAILED_STEPS"}}},
+		Finally:   []model.Step{{ID: "cleanup", Run: model.RunField{Single: "echo cleaned"}}},
+	}
+	r, rs := newTestRunner(t, p)
+	if err := r.Select(Selection{Only: []string{"b"}}); err != nil {
+		t.Fatal(err)
+	}
+	_ = r.Run()
+
+	for id, want := range map[string]string{"PIPE_ALERT": "b", "PIPE_CLEANUP": "cleaned"} {
+		if got := r.envVars[id]; got != want {
+			t.Errorf("expected %s=%q, got %q", id, want, got)
+		}
+	}
+	for _, id := range []string{"alert", "cleanup"} {
+		if ss := rs.Steps[id]; ss.Status != "done" || ss.Unselected {
+			t.Errorf("expected hook %s to run, got %+v", id, ss)
+		}
+	}
+	if ss := rs.Steps["a"]; !ss.Unselected {
+		t.Errorf("expected a to be left out, got %+v", ss)
+	}
+}
@@ -0,0 +1,129 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/getpipe-dev/pipe/internal/config"
+	"github.com/getpipe-dev/pipe/internal/model"
+	"github.com/getpipe-dev/pipe/internal/state"
+)
+
+// stdoutPath returns the per-run file a command's captured stdout is written
+// to: <OutputDir>/<pipeline>/<run-id>/<step>[/<sub-run>].out.
+func (r *Runner) stdoutPath(id ...string) string {
+	return filepath.Join(config.OutputDir, r.pipeline.Name, r.state.RunID, filepath.Join(id...)+".out")
+}
+
+// saveStdout writes a command's captured stdout to its per-run file and
+// returns the path. Sensitive output is never written; a file that cannot be
+// written is logged and left out.
+func (r *Runner) saveStdout(output string, sensitive bool, id ...string) string {
+	if sensitive {
+		return ""
+	}
+	path := r.stdoutPath(id...)
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err == nil {
+		err = os.WriteFile(path, []byte(output), 0o644)
+	}
+	if err != nil {
+		r.log.Log("[%s] output file warning: %v", strings.Join(id, "/"), err)
+		return ""
+	}
+	return path
+}
+
+// inlined reports whether output is small enough for the step's inline_limit
+// to be exported as PIPE_<ID> and kept in the state file. Larger output is
+// only available through PIPE_<ID>_FILE.
+func inlined(step model.Step, output string) bool {
+	if step.InlineLimit == "" {
+		return true
+	}
+	limit, err := model.ParseSize(step.InlineLimit)
+	return err != nil || int64(len(output)) <= limit
+}
+
+// stateSpillSize is the largest stdout the state file keeps inline when an
+// output file holds it too.
+const stateSpillSize = 64 << 10
+
+// stateOutput returns what the state file keeps of a command's stdout:
+// nothing when it exceeds the step's inline_limit or, with an output file
+// to read it from instead, stateSpillSize.
+func stateOutput(step model.Step, output, file string) string {
+	if !inlined(step, output) || (file != "" && len(output) > stateSpillSize) {
+		return ""
+	}
+	return output
+}
+
+// storedOutput returns the stdout recorded for a finished command, reading
+// its output file when the output was too large to keep inline.
+func storedOutput(ss state.StepState) (string, error) {
+	if ss.Output != "" || ss.OutputFile == "" {
+		return ss.Output, nil
+	}
+	data, err := os.ReadFile(ss.OutputFile)
+	if err != nil {
+		return "", fmt.Errorf("reading output file: %w", err)
+	}
+	return string(data), nil
+}
+
+// exportStdout sets PIPE_<ID...>_FILE to the command's output file and,
+// unless the stdout exceeds the step's inline_limit, PIPE_<ID...> to it.
+// For output_format: json it then also sets PIPE_<ID...>__<FIELD> for every
+// field; output over the limit exports no fields either, since together they
+// would put as much into the environment as the output itself.
+func (r *Runner) exportStdout(step model.Step, output, file string, id ...string) {
+	key := EnvKey(id...)
+	if file != "" {
+		r.setEnv(key+"_FILE", file)
+	}
+	if !inlined(step, output) {
+		return
+	}
+	r.setEnv(key, strings.TrimRight(output, "\n"))
+	if step.OutputFormat != model.OutputJSON {
+		return
+	}
+	// Validated when the command ran; a stored output that no longer
+	// parses just exports no fields.
+	fields, _ := jsonFields(output, key)
+	for k, v := range fields {
+		r.setEnv(k, v)
+	}
+}
+
+// expandStdout is expandEnv for values that take a step's whole stdout, such
+// as a foreach source: a PIPE_<ID...> reference to stdout over the step's
+// inline_limit, which is not exported, reads PIPE_<ID...>_FILE instead.
+func (r *Runner) expandStdout(s string) string {
+	r.envMu.Lock()
+	defer r.envMu.Unlock()
+	return os.Expand(r.renderFieldsLocked(s), func(key string) string {
+		if _, ok := r.envVars[key]; !ok {
+			if file, ok := r.envVars[key+"_FILE"]; ok {
+				if data, err := os.ReadFile(file); err == nil {
+					return strings.TrimRight(string(data), "\n")
+				}
+			}
+		}
+		return r.lookupEnvLocked(key)
+	})
+}
+
+// restoreStdout re-exports the stdout of a command finished in an earlier
+// attempt of this run.
+func (r *Runner) restoreStdout(step model.Step, ss state.StepState, id ...string) {
+	output, err := storedOutput(ss)
+	if err != nil {
+		r.log.Log("[%s] cannot restore output: %v", strings.Join(id, "/"), err)
+		return
+	}
+	r.exportStdout(step, output, ss.OutputFile, id...)
+}
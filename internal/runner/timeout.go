@@ -0,0 +1,72 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// timeoutExitCode mirrors coreutils timeout(1).
+const timeoutExitCode = 124
+
+// timeoutError is returned when a command is killed because its step or
+// pipeline deadline passed.
+type timeoutError struct {
+	after    time.Duration
+	pipeline bool // pipeline-wide deadline rather than the step's own timeout
+}
+
+func (e *timeoutError) Error() string {
+	if e.pipeline {
+		return "pipeline timeout exceeded"
+	}
+	return fmt.Sprintf("timed out after %s", e.after)
+}
+
+// isTimeout reports whether err was caused by a step or pipeline timeout.
+func isTimeout(err error) bool {
+	var te *timeoutError
+	return errors.As(err, &te)
+}
+
+// parseTimeout parses a timeout field. Empty or invalid values yield zero
+// (no timeout); the parser rejects invalid values before the runner sees them.
+func parseTimeout(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0
+	}
+	return d
+}
+
+// stepContext derives a per-attempt context from the pipeline context,
+// bounded by the step's own timeout when one is set.
+func (r *Runner) stepContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(r.ctx, timeout)
+	}
+	return context.WithCancel(r.ctx)
+}
+
+// ctxErr translates a command error caused by ctx ending: a Ctrl-C becomes
+// errCancelled, a passed deadline becomes a timeoutError. Cancellations and
+// pipeline-wide timeouts are marked so Retry does not retry them.
+func (r *Runner) ctxErr(ctx context.Context, timeout time.Duration, err error) error {
+	if err == nil {
+		return nil
+	}
+	if r.interrupted.Load() {
+		return noRetry(errCancelled)
+	}
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	if r.ctx.Err() != nil {
+		return noRetry(&timeoutError{pipeline: true})
+	}
+	return &timeoutError{after: timeout}
+}
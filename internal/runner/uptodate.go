@@ -0,0 +1,348 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/getpipe-dev/pipe/internal/config"
+	"github.com/getpipe-dev/pipe/internal/model"
+	"github.com/getpipe-dev/pipe/internal/state"
+	"github.com/getpipe-dev/pipe/internal/ui"
+)
+
+// checkUpToDate decides whether a step with sources: or generates: can be
+// skipped, logs why or why not, and records it in the step's state. A step
+// that is not up to date keeps the hash of its sources, so the next run can
+// compare against it once this one succeeds. One that is up to date takes
+// over the outputs and artifacts of the earlier run upToDate matched and
+// exports them; when they cannot be restored the step is not up to date.
+func (r *Runner) checkUpToDate(step model.Step) bool {
+	if len(step.Sources) == 0 && len(step.Generates) == 0 {
+		return false
+	}
+	ok, reason, digest, prev := r.upToDate(step)
+	var reused state.StepState
+	if ok {
+		var err error
+		if reused, err = r.reuseOutputs(step, prev); err != nil {
+			ok, reason = false, fmt.Sprintf("cannot restore the outputs of run %s: %v", prev.RunID, err)
+		}
+	}
+	if !ok {
+		r.log.Log("[%s] not up to date: %s", step.ID, reason)
+		ss := r.getStepState(step.ID)
+		ss.UpToDate = false
+		ss.SourcesDigest = digest
+		r.setStepState(step.ID, ss)
+		return false
+	}
+
+	r.log.Log("[%s] up to date: %s", step.ID, reason)
+	r.exportState(step, reused)
+	r.stateMu.Lock()
+	ss := r.state.Steps[step.ID]
+	ss.Status = "done"
+	ss.ExitCode = 0
+	ss.Reason = ""
+	ss.Output, ss.OutputFile, ss.Outputs = reused.Output, reused.OutputFile, reused.Outputs
+	ss.SubSteps, ss.Artifacts = reused.SubSteps, reused.Artifacts
+	ss.UpToDate = true
+	ss.SourcesDigest = digest
+	now := time.Now()
+	ss.At = &now
+	r.state.Steps[step.ID] = ss
+	r.saveState()
+	r.stateMu.Unlock()
+
+	r.uiStatusStep(step, ui.UpToDate)
+	return true
+}
+
+// reuseOutputs returns the outputs an up-to-date step takes over from its
+// state in prev: its stdout, copied into this run's output files, its named
+// outputs and those of its sub-runs, and its artifacts, copied back into
+// this run's artifact directory and the working directory.
+func (r *Runner) reuseOutputs(step model.Step, prev *state.RunState) (state.StepState, error) {
+	done := prev.Steps[step.ID]
+	ss := state.StepState{Status: "done", Outputs: done.Outputs}
+	carry := func(from state.StepState, id ...string) (state.StepState, error) {
+		to := state.StepState{Status: from.Status, Outputs: from.Outputs}
+		if from.Output == "" && from.OutputFile == "" {
+			return to, nil
+		}
+		output, err := storedOutput(from)
+		if err != nil {
+			return to, err
+		}
+		to.OutputFile = r.saveStdout(output, false, id...)
+		to.Output = stateOutput(step, output, to.OutputFile)
+		return to, nil
+	}
+	out, err := carry(done, step.ID)
+	if err != nil {
+		return ss, err
+	}
+	ss.Output, ss.OutputFile = out.Output, out.OutputFile
+	for id, sub := range done.SubSteps {
+		if ss.SubSteps == nil {
+			ss.SubSteps = make(map[string]state.StepState)
+		}
+		if ss.SubSteps[id], err = carry(sub, step.ID, id); err != nil {
+			return ss, err
+		}
+	}
+	if a := done.Artifacts; a != nil {
+		dir := filepath.Join(config.ArtifactDir, r.pipeline.Name, prev.RunID, step.ID)
+		if err := r.restoreArtifacts(step, dir, a.Dir, a.Paths); err != nil {
+			return ss, err
+		}
+		ss.Artifacts = a
+	}
+	return ss, nil
+}
+
+// upToDate reports whether every generates: pattern matches an existing file
+// and either all generated files are newer than all sources or the sources
+// hash to the same digest as in the step's last successful run. reason
+// explains the answer; digest is the hash of the current sources. An
+// up-to-date step also needs an earlier successful run whose outputs it can
+// take over: prev, the run whose sources matched, else the latest one.
+func (r *Runner) upToDate(step model.Step) (ok bool, reason, digest string, prev *state.RunState) {
+	base, err := r.workDir(step)
+	if err != nil {
+		return false, err.Error(), "", nil
+	}
+	sources, err := r.matchFiles(base, step.Sources)
+	if err != nil {
+		return false, err.Error(), "", nil
+	}
+	digest, err = sourcesDigest(base, sources)
+	if err != nil {
+		return false, err.Error(), "", nil
+	}
+
+	if len(step.Generates) > 0 {
+		var generated []string
+		for _, p := range step.Generates {
+			matches, err := r.matchFiles(base, []string{p})
+			if err != nil {
+				return false, err.Error(), digest, nil
+			}
+			if len(matches) == 0 {
+				return false, fmt.Sprintf("no file matches generates: %q", p), digest, nil
+			}
+			generated = append(generated, matches...)
+		}
+		if len(sources) == 0 {
+			return r.upToDateFrom(step, "generated files exist and no file matches sources:", digest)
+		}
+		newest, err := extremeModTime(sources, func(a, b time.Time) bool { return a.After(b) })
+		if err != nil {
+			return false, err.Error(), digest, nil
+		}
+		oldest, err := extremeModTime(generated, func(a, b time.Time) bool { return a.Before(b) })
+		if err != nil {
+			return false, err.Error(), digest, nil
+		}
+		if oldest.mod.After(newest.mod) {
+			return r.upToDateFrom(step, fmt.Sprintf("%d generated file(s) are newer than %d source(s)", len(generated), len(sources)), digest)
+		}
+		reason = fmt.Sprintf("%s is newer than %s", relTo(base, newest.path), relTo(base, oldest.path))
+	}
+
+	if len(step.Sources) == 0 {
+		return false, reason, digest, nil
+	}
+	prev, err = state.Latest(r.pipeline.Name, r.state.RunID, func(rs *state.RunState) bool {
+		ss := rs.Steps[step.ID]
+		return ss.Status == "done" && ss.SourcesDigest != ""
+	})
+	switch {
+	case err != nil:
+		return false, joinReasons(reason, err.Error()), digest, nil
+	case prev == nil:
+		return false, joinReasons(reason, "no earlier successful run recorded its sources"), digest, nil
+	case prev.Steps[step.ID].SourcesDigest == digest:
+		return reusable(step, prev, fmt.Sprintf("sources unchanged since run %s", prev.RunID), digest)
+	default:
+		return false, joinReasons(reason, fmt.Sprintf("sources changed since run %s", prev.RunID)), digest, nil
+	}
+}
+
+// upToDateFrom finishes an up-to-date answer with the latest earlier run in
+// which the step succeeded.
+func (r *Runner) upToDateFrom(step model.Step, reason, digest string) (bool, string, string, *state.RunState) {
+	prev, err := state.Latest(r.pipeline.Name, r.state.RunID, func(rs *state.RunState) bool {
+		return rs.Steps[step.ID].Status == "done"
+	})
+	switch {
+	case err != nil:
+		return false, joinReasons(reason, err.Error()), digest, nil
+	case prev == nil:
+		return false, joinReasons(reason, "no earlier successful run has its outputs"), digest, nil
+	}
+	return reusable(step, prev, reason, digest)
+}
+
+// reusable reports an up-to-date step whose outputs prev holds, unless the
+// step is sensitive: its outputs were never stored.
+func reusable(step model.Step, prev *state.RunState, reason, digest string) (bool, string, string, *state.RunState) {
+	if prev.Steps[step.ID].Sensitive {
+		return false, joinReasons(reason, "the outputs of a sensitive step are not kept"), digest, nil
+	}
+	return true, reason, digest, prev
+}
+
+func joinReasons(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "; " + b
+}
+
+func relTo(base, path string) string {
+	if rel, err := filepath.Rel(base, path); err == nil {
+		return rel
+	}
+	return path
+}
+
+type fileTime struct {
+	path string
+	mod  time.Time
+}
+
+// extremeModTime returns the file whose modification time wins over all
+// others by better, e.g. the newest with After.
+func extremeModTime(paths []string, better func(a, b time.Time) bool) (fileTime, error) {
+	var best fileTime
+	for i, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return best, err
+		}
+		if i == 0 || better(info.ModTime(), best.mod) {
+			best = fileTime{path: p, mod: info.ModTime()}
+		}
+	}
+	return best, nil
+}
+
+// sourcesDigest hashes the paths, relative to base, and contents of files.
+func sourcesDigest(base string, files []string) (string, error) {
+	h := sha256.New()
+	for _, f := range files {
+		in, err := os.Open(f)
+		if err != nil {
+			return "", fmt.Errorf("hashing sources: %w", err)
+		}
+		fh := sha256.New()
+		_, err = io.Copy(fh, in)
+		_ = in.Close()
+		if err != nil {
+			return "", fmt.Errorf("hashing sources: %w", err)
+		}
+		fmt.Fprintf(h, "%s\x00%x\n", filepath.ToSlash(relTo(base, f)), fh.Sum(nil))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// matchFiles expands patterns, relative to base unless absolute, into the
+// sorted regular files they match. Matched directories contribute every
+// file under them, and ** matches any number of directories.
+func (r *Runner) matchFiles(base string, patterns []string) ([]string, error) {
+	var files []string
+	for _, p := range patterns {
+		p = r.expandEnv(p)
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(base, p)
+		}
+		matches, err := globAll(p)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", p, err)
+		}
+		for _, m := range matches {
+			err := filepath.WalkDir(m, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.Type().IsRegular() {
+					files = append(files, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	slices.Sort(files)
+	return slices.Compact(files), nil
+}
+
+// globAll is filepath.Glob with support for ** segments matching zero or
+// more directories.
+func globAll(pattern string) ([]string, error) {
+	if !strings.Contains(pattern, "**") {
+		return filepath.Glob(pattern)
+	}
+	segs := strings.Split(filepath.ToSlash(pattern), "/")
+	// Walk from the longest leading path without wildcards.
+	i := 0
+	for i < len(segs) && !strings.ContainsAny(segs[i], `*?[\`) {
+		i++
+	}
+	root := filepath.FromSlash(strings.Join(segs[:i], "/"))
+	if root == "" && filepath.IsAbs(pattern) {
+		root = string(filepath.Separator)
+	} else if root == "" {
+		root = "."
+	}
+	var matches []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		if rel != "." && matchSegments(segs[i:], strings.Split(filepath.ToSlash(rel), "/")) {
+			matches = append(matches, path)
+			if d.IsDir() {
+				return fs.SkipDir // matchFiles takes every file under it
+			}
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// matchSegments matches path segments against pattern segments, where a **
+// segment matches any number of path segments.
+func matchSegments(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+	if pattern[0] == "**" {
+		for k := 0; k <= len(path); k++ {
+			if matchSegments(pattern[1:], path[k:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 {
+		return false
+	}
+	ok, _ := filepath.Match(pattern[0], path[0])
+	return ok && matchSegments(pattern[1:], path[1:])
+}
@@ -0,0 +1,58 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMatchSegments(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "cmd/pipe/main.go", true},
+		{"src/**/*.go", "src/a/b/c.go", true},
+		{"src/**/*.go", "src/c.go", true},
+		{"src/**/*.go", "lib/c.go", false},
+		{"src/*.go", "src/a/c.go", false},
+		{"**", "any/thing", true},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"a/**/b", "a/x/y/c", false},
+	}
+	for _, tt := range tests {
+		got := matchSegments(strings.Split(tt.pattern, "/"), strings.Split(tt.path, "/"))
+		if got != tt.want {
+			t.Errorf("matchSegments(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestGlobAll(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"main.go", "cmd/pipe/main.go", "cmd/pipe/README.md", "docs/index.md"} {
+		path := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := globAll(filepath.Join(dir, "**", "*.go"))
+	if err != nil {
+		t.Fatalf("globAll: %v", err)
+	}
+	want := []string{filepath.Join(dir, "cmd/pipe/main.go"), filepath.Join(dir, "main.go")}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if got, err := globAll(filepath.Join(dir, "missing", "**")); err != nil || len(got) != 0 {
+		t.Fatalf("expected no matches under a missing dir, got %v, %v", got, err)
+	}
+}
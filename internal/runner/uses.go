@@ -0,0 +1,184 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/getpipe-dev/pipe/internal/config"
+	"github.com/getpipe-dev/pipe/internal/logging"
+	"github.com/getpipe-dev/pipe/internal/model"
+	"github.com/getpipe-dev/pipe/internal/parser"
+	"github.com/getpipe-dev/pipe/internal/resolve"
+	"github.com/getpipe-dev/pipe/internal/state"
+	"github.com/getpipe-dev/pipe/internal/ui"
+)
+
+// nested reports whether the runner runs a pipeline on behalf of a uses: step.
+func (r *Runner) nested() bool { return len(r.parents) > 0 }
+
+// loadUses resolves and parses the pipeline a uses: step refers to.
+func (r *Runner) loadUses(step model.Step) (*model.Pipeline, error) {
+	ref, err := resolve.Resolve(step.Uses)
+	if err != nil {
+		return nil, fmt.Errorf("uses %q: %w", step.Uses, err)
+	}
+	child, err := parser.LoadPipelineFromPath(ref.Path, ref.Name)
+	if err != nil {
+		return nil, fmt.Errorf("uses %q: %w", step.Uses, err)
+	}
+	chain := append(slices.Clone(r.parents), r.pipeline.Name)
+	if slices.Contains(chain, child.Name) {
+		return nil, fmt.Errorf("uses %q: pipeline runs itself (%s → %s)", step.Uses, strings.Join(chain, " → "), child.Name)
+	}
+	if InteractiveStep(child) != nil {
+		return nil, fmt.Errorf("uses %q: pipelines with an interactive step cannot be nested", step.Uses)
+	}
+	return child, nil
+}
+
+// childState returns the run state for a uses: step's pipeline: the linked
+// run when resuming a step that did not finish, otherwise a new one.
+func (r *Runner) childState(ss state.StepState, child *model.Pipeline) (rs *state.RunState, resumed bool) {
+	if ss.Child != nil && ss.Child.Pipeline == child.Name {
+		rs, err := state.Load(child.Name, ss.Child.RunID)
+		if err == nil {
+			rs.Status = "running"
+			return rs, true
+		}
+		r.log.Log("cannot resume run %s of %q, starting over: %v", ss.Child.RunID, child.Name, err)
+	}
+	return state.NewRunState(child.Name), false
+}
+
+// runUses runs another pipeline as this step. The child gets the step's
+// with: values as vars, shares the run's process slots, and shows its steps
+// nested under this step in the UI. Its run state is linked from this step
+// so --resume continues it, and its declared outputs are exported as
+// PIPE_<STEP>_<OUTPUT>.
+func (r *Runner) runUses(step model.Step, sl *logging.StepLogger) error {
+	child, err := r.loadUses(step)
+	if err != nil {
+		r.failNotStarted(step, err)
+		return fmt.Errorf("step %q: %w", step.ID, err)
+	}
+	if err := config.EnsureDirs(child.Name); err != nil {
+		r.failNotStarted(step, err)
+		return fmt.Errorf("step %q: %w", step.ID, err)
+	}
+
+	ss := r.getStepState(step.ID)
+	rs, resumed := r.childState(ss, child)
+	ss.Status = "running"
+	ss.Reason = ""
+	ss.AllowedFailure = false
+	ss.Child = &state.ChildRun{Pipeline: child.Name, RunID: rs.RunID}
+	r.setStepState(step.ID, ss)
+	if err := state.Save(rs); err != nil {
+		r.failNotStarted(step, err)
+		return fmt.Errorf("step %q: %w", step.ID, err)
+	}
+
+	var opts []logging.Option
+	if r.ui != nil {
+		opts = append(opts, logging.FileOnly())
+	}
+	clog, err := logging.New(child.Name, rs.RunID, opts...)
+	if err != nil {
+		r.failNotStarted(step, err)
+		return fmt.Errorf("step %q: %w", step.ID, err)
+	}
+	defer func() { _ = clog.Close() }()
+
+	cr := New(child, rs, clog, r.childVars(step, child, sl, r.expandEnv), nil, r.verbosity)
+	if r.ui != nil {
+		r.ui.Expand(step.ID, ui.RowIDs(child.Steps))
+		cr.ui = r.ui.Nested(step.ID)
+	}
+	cr.slots = r.slots
+	cr.parents = append(slices.Clone(r.parents), r.pipeline.Name)
+	cr.ctx = r.ctx
+	timeout := parseTimeout(step.Timeout)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		cr.ctx, cancel = context.WithTimeout(r.ctx, timeout)
+		defer cancel()
+	}
+	if resumed {
+		cr.RestoreEnvFromState()
+		sl.Log("resuming %s (run %s)", child.Name, rs.RunID)
+	} else {
+		sl.Log("running %s (run %s)", child.Name, rs.RunID)
+	}
+	clog.Log("starting pipeline %q for step %q of %q (run %s)", child.Name, step.ID, r.pipeline.Name, r.state.RunID)
+
+	runErr := cr.Run()
+	now := time.Now()
+	ss.At = &now
+
+	if runErr != nil {
+		switch {
+		case r.interrupted.Load() || errors.Is(runErr, ErrPipelineCancelled):
+			runErr = errCancelled
+		case timeout > 0 && errors.Is(cr.ctx.Err(), context.DeadlineExceeded):
+			runErr = &timeoutError{after: timeout}
+		default:
+			runErr = fmt.Errorf("pipeline %q failed (run %s)", child.Name, rs.RunID)
+		}
+		ss.Status = failureState(runErr)
+		ss.ExitCode = exitCode(runErr)
+		ss.Reason = failureReason(runErr)
+		ss.AllowedFailure = step.ContinueOnError
+		r.setStepState(step.ID, ss)
+		sl.Exit(ss.ExitCode)
+		r.uiStatus(step.ID, failureStatus(step, runErr))
+		return fmt.Errorf("step %q: %w", step.ID, runErr)
+	}
+
+	outputs := make(map[string]string, len(child.Outputs))
+	for name, value := range child.Outputs {
+		outputs[name] = cr.expandEnv(value)
+		r.setEnv(EnvKey(step.ID, name), outputs[name])
+	}
+	ss.Status = "done"
+	ss.ExitCode = 0
+	ss.Sensitive = step.Sensitive
+	if !step.Sensitive {
+		ss.Outputs = outputs
+	}
+	r.setStepState(step.ID, ss)
+	sl.Exit(0)
+	r.uiStatus(step.ID, ui.Done)
+	return nil
+}
+
+// childVars resolves the vars of a uses: step's pipeline: its own defaults
+// and dot_file, overridden by the step's with: values expanded against this
+// run's variables by expand.
+func (r *Runner) childVars(step model.Step, child *model.Pipeline, sl *logging.StepLogger, expand func(string) string) map[string]string {
+	var dotFileVars map[string]string
+	if child.DotFile != "" {
+		var warns []string
+		var err error
+		dotFileVars, warns, err = ParseDotFile(child.DotFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			sl.Log("dot_file %s could not be fully read: %v", child.DotFile, err)
+		}
+		for _, w := range warns {
+			sl.Log("%s", w)
+		}
+	}
+	with := make(map[string]string, len(step.With))
+	for k, v := range step.With {
+		with[k] = expand(v)
+	}
+	vars, warns := ResolveVars(child.Vars, dotFileVars, with)
+	for _, w := range warns {
+		sl.Log("%s", strings.Replace(w, "passed via CLI", "passed via with:", 1))
+	}
+	return vars
+}
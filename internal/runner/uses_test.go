@@ -0,0 +1,141 @@
+package runner
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/getpipe-dev/pipe/internal/config"
+	"github.com/getpipe-dev/pipe/internal/model"
+	"github.com/getpipe-dev/pipe/internal/state"
+)
+
+// writePipes points the pipeline and alias files at a temp dir and writes
+// each name → YAML pair as a local pipe.
+func writePipes(t *testing.T, pipes map[string]string) {
+	t.Helper()
+	origFiles, origAliases := config.FilesDir, config.AliasesPath
+	config.FilesDir = t.TempDir()
+	config.AliasesPath = filepath.Join(t.TempDir(), "aliases.json")
+	t.Cleanup(func() { config.FilesDir, config.AliasesPath = origFiles, origAliases })
+	for name, yaml := range pipes {
+		if err := os.WriteFile(filepath.Join(config.FilesDir, name+".yaml"), []byte(yaml), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRun_UsesExportsOutputs(t *testing.T) {
+	writePipes(t, map[string]string{"docker-build": `
+name: docker-build
+vars:
+  tag: latest
+steps:
+  - id: build
+    run: "echo registry/app:$PIPE_VAR_TAG"
+outputs:
+  image: $PIPE_BUILD
+`})
+	p := &model.Pipeline{
+		Name: "test-uses",
+		Steps: []model.Step{
+			{ID: "version", Run: model.RunField{Single: "echo 1.2.0"}},
+			{ID: "image", Uses: "docker-build", With: map[string]string{"tag": "$PIPE_VERSION"}},
+			{ID: "push", Run: model.RunField{Single: "echo pushed $PIPE_IMAGE_IMAGE"}},
+		},
+	}
+	r, rs := newTestRunner(t, p)
+
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if got := r.envVars["PIPE_PUSH"]; got != "pushed registry/app:1.2.0" {
+		t.Fatalf("expected child output passed downstream, got %q", got)
+	}
+	ss := rs.Steps["image"]
+	if ss.Outputs["image"] != "registry/app:1.2.0" || ss.Child == nil || ss.Child.Pipeline != "docker-build" {
+		t.Fatalf("expected outputs and child run link in state, got %+v", ss)
+	}
+	child, err := state.Load("docker-build", ss.Child.RunID)
+	if err != nil {
+		t.Fatalf("loading child state: %v", err)
+	}
+	if child.Status != "done" || child.Steps["build"].Status != "done" {
+		t.Fatalf("expected finished child run, got %+v", child)
+	}
+}
+
+func TestRun_UsesResumesChildRun(t *testing.T) {
+	dir := t.TempDir()
+	ready := filepath.Join(dir, "ready")
+	count := filepath.Join(dir, "count")
+	writePipes(t, map[string]string{"deploy": `
+name: deploy
+steps:
+  - id: prepare
+    run: "echo x >> ` + count + `"
+  - id: apply
+    run: "test -f ` + ready + `"
+    depends_on: prepare
+`})
+	p := &model.Pipeline{
+		Name:  "test-uses-resume",
+		Steps: []model.Step{{ID: "deploy", Uses: "deploy"}},
+	}
+	r, rs := newTestRunner(t, p)
+
+	if err := r.Run(); !errors.Is(err, ErrPipelineFailed) {
+		t.Fatalf("expected ErrPipelineFailed, got %v", err)
+	}
+	link := rs.Steps["deploy"].Child
+	if link == nil || rs.Steps["deploy"].Status != "failed" {
+		t.Fatalf("expected failed step linked to its child run, got %+v", rs.Steps["deploy"])
+	}
+
+	if err := os.WriteFile(ready, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r2 := New(p, rs, r.log, nil, nil, 0)
+	r2.RestoreEnvFromState()
+	if err := r2.Run(); err != nil {
+		t.Fatalf("resumed Run() error: %v", err)
+	}
+	if got := rs.Steps["deploy"].Child; got == nil || got.RunID != link.RunID {
+		t.Fatalf("expected the same child run to be resumed, got %+v", got)
+	}
+	data, err := os.ReadFile(count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "x"); n != 1 {
+		t.Fatalf("expected done child step to be skipped on resume, ran %d times", n)
+	}
+}
+
+func TestRun_UsesCycle(t *testing.T) {
+	writePipes(t, map[string]string{"loop": `
+name: loop
+steps:
+  - id: again
+    uses: test-uses-cycle
+`, "test-uses-cycle": `
+name: test-uses-cycle
+steps:
+  - id: inner
+    uses: loop
+`})
+	p := &model.Pipeline{
+		Name:  "test-uses-cycle",
+		Steps: []model.Step{{ID: "outer", Uses: "loop"}},
+	}
+	r, rs := newTestRunner(t, p)
+
+	if err := r.Run(); !errors.Is(err, ErrPipelineFailed) {
+		t.Fatalf("expected ErrPipelineFailed, got %v", err)
+	}
+	if rs.Steps["outer"].Status != "failed" {
+		t.Fatalf("expected outer step to fail, got %+v", rs.Steps["outer"])
+	}
+}
@@ -14,7 +14,7 @@ import (
 // RotateStates removes old state files for the given pipeline, keeping the
 // newest N files (default 10, controlled by PIPE_STATE_ROTATE). The current
 // run's state file is never deleted. Setting the env var to 0 disables
-// rotation.
+// rotation. The output files and artifacts of removed runs go with them.
 func RotateStates(pipelineName, currentRunID string) error {
 	limit := config.ParseRotateEnv("PIPE_STATE_ROTATE", 10)
 	if limit == 0 {
@@ -77,6 +77,14 @@ func RotateStates(pipelineName, currentRunID string) error {
 		} else {
 			log.Debug("rotated old state file", "path", path)
 		}
+		// The run's output files and artifacts go with its state.
+		runID := strings.TrimSuffix(entry.name, ".json")
+		for _, dir := range []string{config.OutputDir, config.ArtifactDir} {
+			files := filepath.Join(dir, pipelineName, runID)
+			if err := os.RemoveAll(files); err != nil {
+				log.Warn("failed to remove old run files", "path", files, "err", err)
+			}
+		}
 	}
 
 	return nil
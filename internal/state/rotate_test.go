@@ -5,6 +5,8 @@ import (
 	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/getpipe-dev/pipe/internal/config"
 )
 
 // createStateFile creates a fake state file with the given name and sets its
@@ -220,3 +222,41 @@ func TestRotateStates_EmptyDir(t *testing.T) {
 		t.Fatalf("RotateStates error on missing dir: %v", err)
 	}
 }
+
+func TestRotateStates_RemovesRunFiles(t *testing.T) {
+	tmp := overrideStateDir(t)
+	pipeDir := filepath.Join(tmp, "demo")
+	if err := os.MkdirAll(pipeDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PIPE_STATE_ROTATE", "2")
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, runID := range []string{"old-run", "kept-run", "current-run"} {
+		createStateFile(t, pipeDir, runID+".json", base, i)
+		for _, dir := range []string{config.OutputDir, config.ArtifactDir} {
+			runDir := filepath.Join(dir, "demo", runID)
+			if err := os.MkdirAll(runDir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(runDir, "build.out"), []byte("x"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := RotateStates("demo", "current-run"); err != nil {
+		t.Fatalf("RotateStates error: %v", err)
+	}
+
+	for _, dir := range []string{config.OutputDir, config.ArtifactDir} {
+		if _, err := os.Stat(filepath.Join(dir, "demo", "old-run")); !os.IsNotExist(err) {
+			t.Fatalf("expected rotated run's files in %s to be removed, got %v", dir, err)
+		}
+		for _, runID := range []string{"kept-run", "current-run"} {
+			if _, err := os.Stat(filepath.Join(dir, "demo", runID, "build.out")); err != nil {
+				t.Fatalf("expected files of %s to be kept: %v", runID, err)
+			}
+		}
+	}
+}
@@ -6,28 +6,62 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/getpipe-dev/pipe/internal/config"
 )
 
 type RunState struct {
-	RunID        string                `json:"run_id"`
-	PipelineName string                `json:"pipeline_name"`
-	StartedAt    time.Time             `json:"started_at"`
-	FinishedAt   *time.Time            `json:"finished_at,omitempty"`
-	Status       string                `json:"status"` // running|done|failed
-	Steps        map[string]StepState  `json:"steps"`
+	RunID        string               `json:"run_id"`
+	PipelineName string               `json:"pipeline_name"`
+	StartedAt    time.Time            `json:"started_at"`
+	FinishedAt   *time.Time           `json:"finished_at,omitempty"`
+	Status       string               `json:"status"` // running|done|failed|cancelled
+	Steps        map[string]StepState `json:"steps"`
 }
 
 type StepState struct {
-	Status    string                `json:"status"` // pending|running|done|failed
-	ExitCode  int                   `json:"exit_code"`
-	Output    string                `json:"output,omitempty"`
-	Sensitive bool                  `json:"sensitive"`
-	At        *time.Time            `json:"at,omitempty"`
-	Attempts  int                   `json:"attempts,omitempty"`
-	SubSteps  map[string]StepState  `json:"sub_steps,omitempty"`
+	Status         string               `json:"status"` // pending|running|done|failed|cancelled|skipped
+	ExitCode       int                  `json:"exit_code"`
+	Reason         string               `json:"reason,omitempty"`          // why a failed step failed, e.g. "timeout"
+	AllowedFailure bool                 `json:"allowed_failure,omitempty"` // failed with continue_on_error
+	Output         string               `json:"output,omitempty"`
+	OutputFile     string               `json:"output_file,omitempty"` // per-run copy of the captured stdout
+	Sensitive      bool                 `json:"sensitive"`
+	At             *time.Time           `json:"at,omitempty"`
+	Attempts       int                  `json:"attempts,omitempty"`
+	AttemptLog     []Attempt            `json:"attempt_log,omitempty"`
+	SubSteps       map[string]StepState `json:"sub_steps,omitempty"`
+	Outputs        map[string]string    `json:"outputs,omitempty"`   // named outputs, exported as PIPE_<STEP>_<NAME>
+	Child          *ChildRun            `json:"child_run,omitempty"` // run of a uses: step's pipeline
+	Artifacts      *Artifacts           `json:"artifacts,omitempty"`
+	UpToDate       bool                 `json:"up_to_date,omitempty"`     // not run because its sources: and generates: were up to date
+	SourcesDigest  string               `json:"sources_digest,omitempty"` // hash of the files matching sources: before the step ran
+	Unselected     bool                 `json:"unselected,omitempty"`     // left out of a partial run (--only, --from, --until, --skip)
+	ReusedFrom     string               `json:"reused_from,omitempty"`    // where an unselected step's outputs came from: "run <id>" or "cache"
+}
+
+// Artifacts records the files a step's artifacts: patterns matched, copied
+// into the run's artifact directory.
+type Artifacts struct {
+	Dir   string   `json:"dir"`   // the step's working directory
+	Paths []string `json:"paths"` // matched files and directories, relative to Dir
+}
+
+// ChildRun links a uses: step to the run state of the pipeline it ran, so
+// resuming the parent resumes the child.
+type ChildRun struct {
+	Pipeline string `json:"pipeline"`
+	RunID    string `json:"run_id"`
+}
+
+// Attempt records one execution of a step's command.
+type Attempt struct {
+	ExitCode   int    `json:"exit_code"`
+	DurationMS int64  `json:"duration_ms"`
+	Reason     string `json:"reason,omitempty"`
 }
 
 func NewUUID() string {
@@ -87,3 +121,35 @@ func Load(pipelineName, runID string) (*RunState, error) {
 	}
 	return &rs, nil
 }
+
+// Latest returns the most recently started saved run of a pipeline, other
+// than excludeRunID, for which match returns true, or nil if there is none.
+// Unreadable state files are skipped.
+func Latest(pipelineName, excludeRunID string, match func(*RunState) bool) (*RunState, error) {
+	entries, err := os.ReadDir(filepath.Join(config.StateDir, pipelineName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading state directory: %w", err)
+	}
+	var runs []*RunState
+	for _, e := range entries {
+		runID, ok := strings.CutSuffix(e.Name(), ".json")
+		if e.IsDir() || !ok || runID == excludeRunID {
+			continue
+		}
+		rs, err := Load(pipelineName, runID)
+		if err != nil {
+			continue
+		}
+		runs = append(runs, rs)
+	}
+	slices.SortFunc(runs, func(a, b *RunState) int { return b.StartedAt.Compare(a.StartedAt) })
+	for _, rs := range runs {
+		if match(rs) {
+			return rs, nil
+		}
+	}
+	return nil, nil
+}
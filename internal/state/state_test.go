@@ -6,18 +6,24 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/getpipe-dev/pipe/internal/config"
 )
 
-// overrideStateDir points config.StateDir at a temp directory for the test
-// and restores the original value when the test finishes.
+// overrideStateDir points config.StateDir, config.OutputDir and
+// config.ArtifactDir at temp directories for the test and restores the
+// original values when the test finishes.
 func overrideStateDir(t *testing.T) string {
 	t.Helper()
-	orig := config.StateDir
+	orig, origOutput, origArtifact := config.StateDir, config.OutputDir, config.ArtifactDir
 	tmp := t.TempDir()
 	config.StateDir = tmp
-	t.Cleanup(func() { config.StateDir = orig })
+	config.OutputDir = t.TempDir()
+	config.ArtifactDir = t.TempDir()
+	t.Cleanup(func() {
+		config.StateDir, config.OutputDir, config.ArtifactDir = orig, origOutput, origArtifact
+	})
 	return tmp
 }
 
@@ -172,3 +178,37 @@ func TestNewRunState_Defaults(t *testing.T) {
 		t.Fatalf("RunID %q is not a valid UUID", rs.RunID)
 	}
 }
+
+func TestLatest(t *testing.T) {
+	dir := overrideStateDir(t)
+	if err := os.MkdirAll(filepath.Join(dir, "app"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Now()
+	var ids []string
+	for i, status := range []string{"done", "done", "failed", "running"} {
+		rs := NewRunState("app")
+		rs.StartedAt = base.Add(time.Duration(i) * time.Minute)
+		rs.Status = status
+		if err := Save(rs); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		ids = append(ids, rs.RunID)
+	}
+
+	done := func(rs *RunState) bool { return rs.Status == "done" }
+	got, err := Latest("app", ids[3], done)
+	if err != nil {
+		t.Fatalf("Latest: %v", err)
+	}
+	if got == nil || got.RunID != ids[1] {
+		t.Fatalf("expected newest done run %s, got %+v", ids[1], got)
+	}
+	if got, _ := Latest("app", ids[1], done); got == nil || got.RunID != ids[0] {
+		t.Fatalf("expected excluded run to be skipped, got %+v", got)
+	}
+	if got, _ := Latest("other", "", done); got != nil {
+		t.Fatalf("expected nil for a pipeline without runs, got %+v", got)
+	}
+}
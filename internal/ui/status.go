@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -15,12 +16,22 @@ import (
 type Status int
 
 const (
-	Waiting Status = iota // ○
-	Running               // ●
-	Done                  // ✓
-	Failed                // ✗
+	Waiting        Status = iota // ○
+	Running                      // ●
+	Done                         // ✓
+	Failed                       // ✗
+	TimedOut                     // ✗ (killed by timeout)
+	Cancelled                    // ⊘ (interrupted by Ctrl-C)
+	Skipped                      // ↷ (if: condition was false, or left out of a partial run)
+	AllowedFailure               // ✗ (failed with continue_on_error)
+	UpToDate                     // ≡ (not run, sources: and generates: are up to date)
 )
 
+// finished reports whether s is a terminal status.
+func (s Status) finished() bool {
+	return s != Waiting && s != Running
+}
+
 // ANSI color helpers
 const (
 	colorReset  = "\033[0m"
@@ -31,10 +42,15 @@ const (
 )
 
 var icons = [...]string{
-	Waiting: colorDim + "○" + colorReset,
-	Running: colorYellow + "●" + colorReset,
-	Done:    colorGreen + "✓" + colorReset,
-	Failed:  colorRed + "✗" + colorReset,
+	Waiting:        colorDim + "○" + colorReset,
+	Running:        colorYellow + "●" + colorReset,
+	Done:           colorGreen + "✓" + colorReset,
+	Failed:         colorRed + "✗" + colorReset,
+	TimedOut:       colorRed + "✗" + colorReset,
+	Cancelled:      colorYellow + "⊘" + colorReset,
+	Skipped:        colorDim + "↷" + colorReset,
+	AllowedFailure: colorYellow + "✗" + colorReset,
+	UpToDate:       colorGreen + "≡" + colorReset,
 }
 
 type row struct {
@@ -54,6 +70,9 @@ type StatusUI struct {
 	index    map[string]int // id → rows index
 	lines    int            // lines rendered last frame (for cursor-up)
 	maxWidth int            // longest id (for column alignment)
+
+	root   *StatusUI // set on a view returned by Nested
+	prefix string    // row id prefix of a nested view, e.g. "deploy/"
 }
 
 // NewStatusUI creates a StatusUI from the pipeline steps.
@@ -64,6 +83,17 @@ func NewStatusUI(w io.Writer, steps []model.Step) *StatusUI {
 		index: make(map[string]int),
 	}
 
+	for _, id := range RowIDs(steps) {
+		s.addRow(id)
+	}
+
+	return s
+}
+
+// RowIDs returns the status row ids for steps: one per step, or one per
+// parallel item or sub-run. Interactive steps get no row.
+func RowIDs(steps []model.Step) []string {
+	var ids []string
 	for _, step := range steps {
 		if step.Interactive {
 			continue
@@ -71,20 +101,72 @@ func NewStatusUI(w io.Writer, steps []model.Step) *StatusUI {
 		switch {
 		case step.Run.IsStrings():
 			for i := range step.Run.Strings {
-				id := fmt.Sprintf("%s/run_%d", step.ID, i)
-				s.addRow(id)
+				ids = append(ids, fmt.Sprintf("%s/run_%d", step.ID, i))
 			}
 		case step.Run.IsSubRuns():
 			for _, sub := range step.Run.SubRuns {
-				id := fmt.Sprintf("%s/%s", step.ID, sub.ID)
-				s.addRow(id)
+				ids = append(ids, fmt.Sprintf("%s/%s", step.ID, sub.ID))
 			}
 		default:
-			s.addRow(step.ID)
+			ids = append(ids, step.ID)
 		}
 	}
+	return ids
+}
 
-	return s
+// Nested returns a view of s for a child pipeline run by step id. Row ids
+// passed to the view are prefixed with "id/", so the child's rows appear
+// under the step. Finish and Detach are no-ops on a view.
+func (s *StatusUI) Nested(id string) *StatusUI {
+	if s.root != nil {
+		return &StatusUI{root: s.root, prefix: s.prefix + id + "/"}
+	}
+	return &StatusUI{root: s, prefix: id + "/"}
+}
+
+// AddSteps adds rows for steps that run after the main pipeline, such as
+// finally: hooks, and re-renders. On a nested view the rows go after the
+// view's existing rows.
+func (s *StatusUI) AddSteps(steps []model.Step) {
+	if s.root != nil {
+		s.root.addSteps(s.prefix, steps)
+		return
+	}
+	s.addSteps("", steps)
+}
+
+func (s *StatusUI) addSteps(prefix string, steps []model.Step) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at := len(s.rows)
+	if prefix != "" {
+		for i, r := range s.rows {
+			if strings.HasPrefix(r.id, prefix) {
+				at = i + 1
+			}
+		}
+	}
+	ids := RowIDs(steps)
+	for i := range ids {
+		ids[i] = prefix + ids[i]
+	}
+	s.insertRows(at, ids)
+	s.render()
+}
+
+// Detach treats the rows drawn so far as printed, so the next render starts
+// below anything written to the terminal since (e.g. by an interactive step).
+func (s *StatusUI) Detach() {
+	if s.root != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.rows {
+		s.rows[i].flushed = true
+	}
+	s.lines = 0
 }
 
 func (s *StatusUI) addRow(id string) {
@@ -95,10 +177,54 @@ func (s *StatusUI) addRow(id string) {
 	}
 }
 
+// Expand replaces the row id with one row per child, named id/<child>, for
+// steps whose items are only known at run time. Unknown ids are ignored.
+func (s *StatusUI) Expand(id string, children []string) {
+	if s.root != nil {
+		s.root.Expand(s.prefix+id, children)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx, ok := s.index[id]
+	if !ok || len(children) == 0 {
+		return
+	}
+	s.rows = slices.Delete(s.rows, idx, idx+1)
+	ids := make([]string, len(children))
+	for i, c := range children {
+		ids[i] = id + "/" + c
+	}
+	s.insertRows(idx, ids)
+
+	s.render()
+}
+
+// insertRows inserts waiting rows for ids at position at and rebuilds the
+// index. Must be called with s.mu held.
+func (s *StatusUI) insertRows(at int, ids []string) {
+	rest := slices.Clone(s.rows[at:])
+	s.rows = s.rows[:at]
+	for _, id := range ids {
+		s.rows = append(s.rows, row{id: id, status: Waiting})
+		s.maxWidth = max(s.maxWidth, len(id))
+	}
+	s.rows = append(s.rows, rest...)
+	s.index = make(map[string]int, len(s.rows))
+	for i, r := range s.rows {
+		s.index[r.id] = i
+	}
+}
+
 // SetStatus updates the status of a step and re-renders.
-// When transitioning to Done/Failed, any collected output is flushed
+// When transitioning to a finished status, any collected output is flushed
 // above the status block with a colored pipe prefix.
 func (s *StatusUI) SetStatus(id string, st Status) {
+	if s.root != nil {
+		s.root.SetStatus(s.prefix+id, st)
+		return
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -109,10 +235,10 @@ func (s *StatusUI) SetStatus(id string, st Status) {
 	r := &s.rows[idx]
 	r.status = st
 
-	switch st {
-	case Running:
+	switch {
+	case st == Running:
 		r.startedAt = time.Now()
-	case Done, Failed:
+	case st.finished():
 		if !r.startedAt.IsZero() {
 			r.duration = time.Since(r.startedAt)
 		}
@@ -139,7 +265,7 @@ func (s *StatusUI) flushOutput(r *row) {
 	targetIdx := s.index[r.id]
 	for i := 0; i < targetIdx; i++ {
 		prev := &s.rows[i]
-		if prev.flushed || (prev.status != Done && prev.status != Failed) {
+		if prev.flushed || !prev.status.finished() {
 			continue
 		}
 		s.flushRow(prev)
@@ -171,6 +297,10 @@ func (s *StatusUI) flushRow(r *row) {
 // status rows below. The output scrolls into terminal history while the
 // status block stays pinned at the bottom.
 func (s *StatusUI) PrintAbove(msg string) {
+	if s.root != nil {
+		s.root.PrintAbove(msg)
+		return
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -190,8 +320,12 @@ func (s *StatusUI) PrintAbove(msg string) {
 }
 
 // AddOutput appends a line of output to the given step row.
-// Output is collected but only rendered after the step finishes (Done/Failed).
+// Output is collected but only rendered after the step finishes.
 func (s *StatusUI) AddOutput(id string, line string) {
+	if s.root != nil {
+		s.root.AddOutput(s.prefix+id, line)
+		return
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	idx, ok := s.index[id]
@@ -203,6 +337,9 @@ func (s *StatusUI) AddOutput(id string, line string) {
 
 // Finish performs a final render. No subsequent redraws occur.
 func (s *StatusUI) Finish() {
+	if s.root != nil {
+		return
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.render()
@@ -233,10 +370,12 @@ func (s *StatusUI) render() {
 
 func outputPipe(s Status) string {
 	switch s {
-	case Done:
+	case Done, UpToDate:
 		return colorGreen + "│" + colorReset
-	case Failed:
+	case Failed, TimedOut:
 		return colorRed + "│" + colorReset
+	case AllowedFailure:
+		return colorYellow + "│" + colorReset
 	default:
 		return colorDim + "│" + colorReset
 	}
@@ -252,6 +391,16 @@ func statusSuffix(r row) string {
 		return colorDim + FormatDuration(r.duration) + colorReset
 	case Failed:
 		return colorRed + FormatDuration(r.duration) + colorReset
+	case TimedOut:
+		return colorRed + "timed out " + FormatDuration(r.duration) + colorReset
+	case Cancelled:
+		return colorYellow + "cancelled" + colorReset
+	case Skipped:
+		return colorDim + "skipped" + colorReset
+	case AllowedFailure:
+		return colorYellow + "failed, allowed " + FormatDuration(r.duration) + colorReset
+	case UpToDate:
+		return colorDim + "up to date" + colorReset
 	default:
 		return ""
 	}
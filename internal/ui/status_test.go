@@ -119,6 +119,68 @@ func TestRender_FailedIcon(t *testing.T) {
 	}
 }
 
+func TestRender_TimedOut(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewStatusUI(&buf, steps("rollout"))
+	s.SetStatus("rollout", Running)
+	buf.Reset()
+	s.SetStatus("rollout", TimedOut)
+	out := buf.String()
+	if !strings.Contains(out, "✗") {
+		t.Fatalf("expected ✗ in output, got: %s", out)
+	}
+	if !strings.Contains(out, "timed out") {
+		t.Fatalf("expected 'timed out' in output, got: %s", out)
+	}
+}
+
+func TestRender_Cancelled(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewStatusUI(&buf, steps("deploy"))
+	s.SetStatus("deploy", Running)
+	buf.Reset()
+	s.SetStatus("deploy", Cancelled)
+	out := buf.String()
+	if !strings.Contains(out, "⊘") {
+		t.Fatalf("expected ⊘ in output, got: %s", out)
+	}
+	if !strings.Contains(out, "cancelled") {
+		t.Fatalf("expected 'cancelled' in output, got: %s", out)
+	}
+}
+
+func TestRender_Skipped(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewStatusUI(&buf, steps("deploy"))
+	s.SetStatus("deploy", Skipped)
+	out := buf.String()
+	if !strings.Contains(out, "↷") || !strings.Contains(out, "skipped") {
+		t.Fatalf("expected skipped row, got: %s", out)
+	}
+}
+
+func TestRender_UpToDate(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewStatusUI(&buf, steps("build"))
+	s.SetStatus("build", UpToDate)
+	out := buf.String()
+	if !strings.Contains(out, "≡") || !strings.Contains(out, "up to date") {
+		t.Fatalf("expected up-to-date row, got: %s", out)
+	}
+}
+
+func TestRender_AllowedFailure(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewStatusUI(&buf, steps("notify"))
+	s.SetStatus("notify", Running)
+	buf.Reset()
+	s.SetStatus("notify", AllowedFailure)
+	out := buf.String()
+	if !strings.Contains(out, "failed, allowed") {
+		t.Fatalf("expected 'failed, allowed' in output, got: %s", out)
+	}
+}
+
 func TestRender_WaitingIcon(t *testing.T) {
 	var buf bytes.Buffer
 	s := NewStatusUI(&buf, steps("push"))
@@ -296,3 +358,64 @@ func TestFlushOutput_PreservesOrder(t *testing.T) {
 		t.Fatalf("expected change-context before pods, but got change-context at %d, pods at %d\noutput: %q", ctxIdx, podsIdx, out)
 	}
 }
+
+func TestExpand(t *testing.T) {
+	s := NewStatusUI(&bytes.Buffer{}, steps("list", "deploy", "notify"))
+	s.Expand("deploy", []string{"api", "web"})
+	var ids []string
+	for _, r := range s.rows {
+		ids = append(ids, r.id)
+	}
+	if strings.Join(ids, ",") != "list,deploy/api,deploy/web,notify" {
+		t.Fatalf("unexpected rows %v", ids)
+	}
+	s.SetStatus("notify", Done)
+	if s.rows[s.index["notify"]].status != Done {
+		t.Fatal("expected index to follow the shifted rows")
+	}
+	if s.maxWidth != len("deploy/api") {
+		t.Fatalf("expected maxWidth to grow, got %d", s.maxWidth)
+	}
+}
+
+func TestAddSteps(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewStatusUI(&buf, steps("build"))
+	s.SetStatus("build", Done)
+	s.Detach()
+	buf.Reset()
+
+	s.AddSteps(steps("teardown"))
+	if len(s.rows) != 2 || s.rows[1].id != "teardown" {
+		t.Fatalf("expected teardown row appended, got %+v", s.rows)
+	}
+	out := buf.String()
+	if strings.Contains(out, "build") || !strings.Contains(out, "teardown") {
+		t.Fatalf("expected only the new row to render after Detach, got %q", out)
+	}
+	if strings.Contains(out, "\033[1A") {
+		t.Fatalf("expected no cursor-up over detached rows, got %q", out)
+	}
+}
+
+func TestNested(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewStatusUI(&buf, steps("version", "image", "push"))
+	s.Expand("image", RowIDs(steps("build", "scan")))
+	child := s.Nested("image")
+	child.SetStatus("build", Done)
+	child.AddSteps(steps("cleanup"))
+	child.Finish()
+
+	var ids []string
+	for _, r := range s.rows {
+		ids = append(ids, r.id)
+	}
+	want := "version image/build image/scan image/cleanup push"
+	if got := strings.Join(ids, " "); got != want {
+		t.Fatalf("expected rows %q, got %q", want, got)
+	}
+	if s.rows[s.index["image/build"]].status != Done {
+		t.Fatal("expected nested SetStatus to update the prefixed row")
+	}
+}